@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"github.com/allape/gocrud"
+	"github.com/allape/stepin/acme"
+	"github.com/allape/stepin/audit"
+	"github.com/allape/stepin/env"
+	"github.com/allape/stepin/model"
+	"github.com/allape/stepin/stepin/create"
+	"github.com/allape/stepin/stepin/revoke"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"time"
+)
+
+// SetupACMEController
+// ACME directory is served at /acme/directory when STEPIN_ACME_CA_ID is set
+func SetupACMEController(group *gin.RouterGroup, db *gorm.DB, backend create.Backend) error {
+	if env.ACMECaID == 0 {
+		return nil
+	}
+
+	caID := gocrud.ID(env.ACMECaID)
+
+	_, err := findACMECA(db, caID)
+	if err != nil {
+		return err
+	}
+
+	_, err = acme.Setup(group.Group("acme"), acme.Config{
+		DB:        db,
+		PublicURL: env.PublicURL,
+		Issue: func(csr create.CSR, notBefore, notAfter time.Time) (*model.Cert, error) {
+			return issueACMECert(db, backend, caID, csr, notBefore, notAfter)
+		},
+		Revoke: func(cert *model.Cert, reason revoke.Reason) error {
+			issuer, err := FindIssuer(db, cert.Crt.ToBytes())
+			if err != nil {
+				return err
+			}
+			var issuerPassword create.Password
+			if issuer != nil {
+				issuerPassword, err = handleCAPassword(issuer, "")
+				if err != nil {
+					return err
+				}
+			}
+			return RevokeCert(db, cert, reason, issuer, issuerPassword)
+		},
+	})
+	return err
+}
+
+func findACMECA(db *gorm.DB, caID gocrud.ID) (*model.Cert, error) {
+	var ca model.Cert
+	err := db.Model(&ca).Scopes(model.Live).First(&ca, caID).Error
+	if err != nil {
+		return nil, fmt.Errorf("acme ca %d: %w", caID, err)
+	}
+	if !ca.IsCA() {
+		return nil, fmt.Errorf("acme ca %d is not a ca", caID)
+	}
+	return &ca, nil
+}
+
+func issueACMECert(
+	db *gorm.DB,
+	backend create.Backend,
+	caID gocrud.ID,
+	csr create.CSR,
+	notBefore, notAfter time.Time,
+) (*model.Cert, error) {
+	ca, err := findACMECA(db, caID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ca.Decode()
+	if err != nil {
+		return nil, err
+	}
+
+	caPassword, err := handleCAPassword(ca, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if notAfter.IsZero() {
+		notAfter = time.Now().Add(time.Duration(env.ACMEValidityHours) * time.Hour)
+	}
+
+	cert, _, err := SignCSR(db, backend, ca, caPassword, csr, nil, notBefore, notAfter)
+
+	entry := &model.AuditLog{
+		Actor:      "acme",
+		Action:     audit.CertSignCSR,
+		Parameters: fmt.Sprintf(`{"caID":%d}`, caID),
+		Outcome:    model.AuditSuccess,
+	}
+	if err != nil {
+		entry.Outcome = model.AuditFailure
+		entry.Message = err.Error()
+	} else {
+		entry.CertID = cert.ID
+	}
+	auditErr := audit.Append(db, entry)
+	if auditErr != nil {
+		l.Error().Printf("failed to append audit log of acme: %v", auditErr)
+	}
+
+	return cert, err
+}
@@ -0,0 +1,1215 @@
+package acme
+
+// ACME server, https://www.rfc-editor.org/rfc/rfc8555
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/allape/gocrud"
+	"github.com/allape/gogger"
+	"github.com/allape/stepin/model"
+	"github.com/allape/stepin/stepin/create"
+	"github.com/allape/stepin/stepin/native"
+	"github.com/allape/stepin/stepin/revoke"
+	"github.com/gin-gonic/gin"
+	"github.com/go-jose/go-jose/v3"
+	"gorm.io/gorm"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	JOSEMimeType             = "application/jose+json"
+	CertificateChainMimeType = "application/pem-certificate-chain"
+
+	IdentifierDNS = "dns"
+
+	ChallengeHTTP01 = "http-01"
+	ChallengeDNS01  = "dns-01"
+
+	OrderValidity = 7 * 24 * time.Hour
+)
+
+var l = gogger.New("acme")
+
+// IssueFunc
+// Sign the verified CSR and store the result as a model.Cert, notBefore and notAfter are zero if the client did not ask for them
+type IssueFunc func(csr create.CSR, notBefore, notAfter time.Time) (*model.Cert, error)
+
+// RevokeFunc
+// Revoke a model.Cert issued by IssueFunc, the Crt of cert is decoded
+type RevokeFunc func(cert *model.Cert, reason revoke.Reason) error
+
+type Config struct {
+	DB        *gorm.DB
+	PublicURL string // e.g. http://stepin.internal:8080, taken from requests if empty
+	Issue     IssueFunc
+	Revoke    RevokeFunc
+
+	HTTP01Port int                                                      // 80 if zero
+	LookupTXT  func(ctx context.Context, name string) ([]string, error) // net.DefaultResolver.LookupTXT if nil
+}
+
+type Server struct {
+	Config
+	basePath string
+	nonces   *nonces
+}
+
+// Setup
+// Register ACME endpoints under group, the directory is at {group}/directory
+func Setup(group *gin.RouterGroup, config Config) (*Server, error) {
+	if config.DB == nil || config.Issue == nil || config.Revoke == nil {
+		return nil, fmt.Errorf("db, issue and revoke are required")
+	}
+	if config.HTTP01Port == 0 {
+		config.HTTP01Port = 80
+	}
+	if config.LookupTXT == nil {
+		config.LookupTXT = net.DefaultResolver.LookupTXT
+	}
+
+	s := &Server{
+		Config:   config,
+		basePath: strings.TrimSuffix(group.BasePath(), "/"),
+		nonces: &nonces{
+			values: map[string]time.Time{},
+		},
+	}
+
+	group.Use(s.withNonce)
+
+	group.GET("directory", s.directory)
+	group.HEAD("new-nonce", s.newNonce)
+	group.GET("new-nonce", s.newNonce)
+	group.POST("new-account", s.newAccount)
+	group.POST("account/:id", s.account)
+	group.POST("account/:id/orders", s.orders)
+	group.POST("key-change", s.keyChange)
+	group.POST("new-order", s.newOrder)
+	group.POST("order/:id", s.order)
+	group.POST("order/:id/finalize", s.finalize)
+	group.POST("authz/:id", s.authorization)
+	group.POST("challenge/:id", s.challenge)
+	group.POST("certificate/:id", s.certificate)
+	group.POST("revoke-cert", s.revokeCert)
+
+	return s, nil
+}
+
+// region helpers
+
+func (s *Server) origin(ctx *gin.Context) string {
+	if s.PublicURL != "" {
+		return strings.TrimSuffix(s.PublicURL, "/")
+	}
+	scheme := "http"
+	if ctx.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := ctx.GetHeader("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + ctx.Request.Host
+}
+
+func (s *Server) url(ctx *gin.Context, path string) string {
+	return s.origin(ctx) + s.basePath + path
+}
+
+func (s *Server) withNonce(ctx *gin.Context) {
+	nonce, err := s.nonces.New()
+	if err != nil {
+		s.problem(ctx, serverInternal(err))
+		ctx.Abort()
+		return
+	}
+	ctx.Header("Replay-Nonce", nonce)
+	ctx.Header("Link", fmt.Sprintf(`<%s>;rel="index"`, s.url(ctx, "/directory")))
+	ctx.Next()
+}
+
+func (s *Server) problem(ctx *gin.Context, problem *Problem) {
+	if problem.Status >= http.StatusInternalServerError {
+		l.Error().Printf("%s %s: %v", ctx.Request.Method, ctx.Request.URL.Path, problem)
+	}
+	bs, _ := json.Marshal(problem)
+	ctx.Data(problem.Status, ProblemMimeType, bs)
+}
+
+func (s *Server) respond(ctx *gin.Context, status int, location string, body any) {
+	if location != "" {
+		ctx.Header("Location", location)
+	}
+	ctx.JSON(status, body)
+}
+
+// paramID
+// ID in the path, an invalid one is not found, as gorm takes a string condition as raw SQL
+func paramID(ctx *gin.Context) (gocrud.ID, *Problem) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		return 0, notFound("invalid id: %s", ctx.Param("id"))
+	}
+	return gocrud.ID(id), nil
+}
+
+func (s *Server) handleError(ctx *gin.Context, err error) {
+	var problem *Problem
+	if errors.As(err, &problem) {
+		s.problem(ctx, problem)
+		return
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		s.problem(ctx, notFound("%v", err))
+		return
+	}
+	s.problem(ctx, serverInternal(err))
+}
+
+// endregion helpers
+
+// region directory and nonce
+
+type Directory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+	RevokeCert string `json:"revokeCert"`
+	KeyChange  string `json:"keyChange"`
+}
+
+func (s *Server) directory(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, Directory{
+		NewNonce:   s.url(ctx, "/new-nonce"),
+		NewAccount: s.url(ctx, "/new-account"),
+		NewOrder:   s.url(ctx, "/new-order"),
+		RevokeCert: s.url(ctx, "/revoke-cert"),
+		KeyChange:  s.url(ctx, "/key-change"),
+	})
+}
+
+func (s *Server) newNonce(ctx *gin.Context) {
+	ctx.Header("Cache-Control", "no-store")
+	if ctx.Request.Method == http.MethodHead {
+		ctx.Status(http.StatusOK)
+	} else {
+		ctx.Status(http.StatusNoContent)
+	}
+}
+
+// endregion directory and nonce
+
+// region account
+
+type Account struct {
+	Status  model.ACMEStatus `json:"status"`
+	Contact []string         `json:"contact,omitempty"`
+	Orders  string           `json:"orders"`
+}
+
+func (s *Server) renderAccount(ctx *gin.Context, account *model.ACMEAccount) Account {
+	return Account{
+		Status:  account.Status,
+		Contact: account.Contact,
+		Orders:  s.url(ctx, fmt.Sprintf("/account/%d/orders", account.ID)),
+	}
+}
+
+func (s *Server) accountURL(ctx *gin.Context, id gocrud.ID) string {
+	return s.url(ctx, fmt.Sprintf("/account/%d", id))
+}
+
+func validateContact(contact []string) *Problem {
+	for _, c := range contact {
+		if !strings.HasPrefix(c, "mailto:") {
+			return NewProblem(http.StatusBadRequest, UnsupportedContact, "only mailto is supported: %s", c)
+		}
+		if !create.IsEmailAddress(strings.TrimPrefix(c, "mailto:")) {
+			return NewProblem(http.StatusBadRequest, InvalidContact, "invalid email address: %s", c)
+		}
+	}
+	return nil
+}
+
+type NewAccountPayload struct {
+	Contact              []string `json:"contact"`
+	TermsOfServiceAgreed bool     `json:"termsOfServiceAgreed"`
+	OnlyReturnExisting   bool     `json:"onlyReturnExisting"`
+}
+
+func (s *Server) newAccount(ctx *gin.Context) {
+	req, problem := s.verify(ctx, withJWK)
+	if problem != nil {
+		s.problem(ctx, problem)
+		return
+	}
+
+	var payload NewAccountPayload
+	err := json.Unmarshal(req.Payload, &payload)
+	if err != nil {
+		s.problem(ctx, malformed("invalid payload: %v", err))
+		return
+	}
+
+	thumbprint, err := Thumbprint(req.JWK)
+	if err != nil {
+		s.problem(ctx, malformed("invalid jwk: %v", err))
+		return
+	}
+
+	var account model.ACMEAccount
+	err = s.DB.Model(&account).Where("thumbprint = ?", thumbprint).First(&account).Error
+	if err == nil {
+		s.respond(ctx, http.StatusOK, s.accountURL(ctx, account.ID), s.renderAccount(ctx, &account))
+		return
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		s.handleError(ctx, err)
+		return
+	}
+
+	if payload.OnlyReturnExisting {
+		s.problem(ctx, NewProblem(http.StatusBadRequest, AccountDoesNotExist, "account does not exist"))
+		return
+	}
+
+	if problem = validateContact(payload.Contact); problem != nil {
+		s.problem(ctx, problem)
+		return
+	}
+
+	key, err := json.Marshal(req.JWK)
+	if err != nil {
+		s.handleError(ctx, err)
+		return
+	}
+
+	account = model.ACMEAccount{
+		Status:     model.ACMEValid,
+		Key:        string(key),
+		Thumbprint: thumbprint,
+		Contact:    payload.Contact,
+	}
+	err = s.DB.Create(&account).Error
+	if err != nil {
+		s.handleError(ctx, err)
+		return
+	}
+
+	s.respond(ctx, http.StatusCreated, s.accountURL(ctx, account.ID), s.renderAccount(ctx, &account))
+}
+
+type UpdateAccountPayload struct {
+	Status  model.ACMEStatus `json:"status"`
+	Contact []string         `json:"contact"`
+}
+
+func (s *Server) account(ctx *gin.Context) {
+	req, problem := s.verify(ctx, withKID)
+	if problem != nil {
+		s.problem(ctx, problem)
+		return
+	}
+	if ctx.Param("id") != fmt.Sprintf("%d", req.Account.ID) {
+		s.problem(ctx, unauthorized("account mismatch"))
+		return
+	}
+
+	if !req.IsPostAsGet() {
+		var payload UpdateAccountPayload
+		err := json.Unmarshal(req.Payload, &payload)
+		if err != nil {
+			s.problem(ctx, malformed("invalid payload: %v", err))
+			return
+		}
+
+		switch payload.Status {
+		case "":
+		case model.ACMEDeactivated:
+			req.Account.Status = model.ACMEDeactivated
+		default:
+			s.problem(ctx, malformed("status can only be changed to %s", model.ACMEDeactivated))
+			return
+		}
+
+		if payload.Contact != nil {
+			if problem = validateContact(payload.Contact); problem != nil {
+				s.problem(ctx, problem)
+				return
+			}
+			req.Account.Contact = payload.Contact
+		}
+
+		err = s.DB.Save(req.Account).Error
+		if err != nil {
+			s.handleError(ctx, err)
+			return
+		}
+	}
+
+	s.respond(ctx, http.StatusOK, "", s.renderAccount(ctx, req.Account))
+}
+
+type OrderList struct {
+	Orders []string `json:"orders"`
+}
+
+func (s *Server) orders(ctx *gin.Context) {
+	req, problem := s.verify(ctx, withKID)
+	if problem != nil {
+		s.problem(ctx, problem)
+		return
+	}
+	if ctx.Param("id") != fmt.Sprintf("%d", req.Account.ID) {
+		s.problem(ctx, unauthorized("account mismatch"))
+		return
+	}
+
+	var orders []model.ACMEOrder
+	err := s.DB.Model(&model.ACMEOrder{}).
+		Where("account_id = ? AND status IN ?", req.Account.ID, []model.ACMEStatus{model.ACMEPending, model.ACMEReady, model.ACMEProcessing}).
+		Find(&orders).Error
+	if err != nil {
+		s.handleError(ctx, err)
+		return
+	}
+
+	list := OrderList{Orders: make([]string, 0, len(orders))}
+	for _, order := range orders {
+		list.Orders = append(list.Orders, s.orderURL(ctx, order.ID))
+	}
+
+	s.respond(ctx, http.StatusOK, "", list)
+}
+
+// KeyChangePayload
+// https://www.rfc-editor.org/rfc/rfc8555#section-7.3.5
+type KeyChangePayload struct {
+	Account string          `json:"account"`
+	OldKey  jose.JSONWebKey `json:"oldKey"`
+}
+
+func (s *Server) keyChange(ctx *gin.Context) {
+	req, problem := s.verify(ctx, withKID)
+	if problem != nil {
+		s.problem(ctx, problem)
+		return
+	}
+
+	inner, err := jose.ParseSigned(string(req.Payload))
+	if err != nil {
+		s.problem(ctx, malformed("invalid inner jws: %v", err))
+		return
+	}
+	if len(inner.Signatures) != 1 {
+		s.problem(ctx, malformed("exactly one signature is required in inner jws"))
+		return
+	}
+	header := inner.Signatures[0].Protected
+	if header.JSONWebKey == nil || !header.JSONWebKey.Valid() || !header.JSONWebKey.IsPublic() {
+		s.problem(ctx, malformed("jwk is required in inner jws"))
+		return
+	}
+	if url, _ := header.ExtraHeaders["url"].(string); url != req.URL {
+		s.problem(ctx, malformed("url of inner jws does not match"))
+		return
+	}
+
+	payloadBytes, err := inner.Verify(header.JSONWebKey)
+	if err != nil {
+		s.problem(ctx, malformed("invalid signature of inner jws: %v", err))
+		return
+	}
+
+	var payload KeyChangePayload
+	err = json.Unmarshal(payloadBytes, &payload)
+	if err != nil {
+		s.problem(ctx, malformed("invalid payload: %v", err))
+		return
+	}
+
+	if payload.Account != s.accountURL(ctx, req.Account.ID) {
+		s.problem(ctx, malformed("account of inner jws does not match"))
+		return
+	}
+	oldThumbprint, err := Thumbprint(&payload.OldKey)
+	if err != nil || oldThumbprint != req.Account.Thumbprint {
+		s.problem(ctx, malformed("old key does not match"))
+		return
+	}
+
+	newThumbprint, err := Thumbprint(header.JSONWebKey)
+	if err != nil {
+		s.problem(ctx, malformed("invalid new key: %v", err))
+		return
+	}
+
+	var existing model.ACMEAccount
+	err = s.DB.Model(&existing).Where("thumbprint = ?", newThumbprint).First(&existing).Error
+	if err == nil {
+		ctx.Header("Location", s.accountURL(ctx, existing.ID))
+		s.problem(ctx, NewProblem(http.StatusConflict, Malformed, "new key is in use by another account"))
+		return
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		s.handleError(ctx, err)
+		return
+	}
+
+	key, err := json.Marshal(header.JSONWebKey)
+	if err != nil {
+		s.handleError(ctx, err)
+		return
+	}
+
+	req.Account.Key = string(key)
+	req.Account.Thumbprint = newThumbprint
+	err = s.DB.Save(req.Account).Error
+	if err != nil {
+		s.handleError(ctx, err)
+		return
+	}
+
+	s.respond(ctx, http.StatusOK, "", s.renderAccount(ctx, req.Account))
+}
+
+// endregion account
+
+// region order
+
+type Order struct {
+	Status         model.ACMEStatus       `json:"status"`
+	Expires        time.Time              `json:"expires"`
+	Identifiers    []model.ACMEIdentifier `json:"identifiers"`
+	NotBefore      *time.Time             `json:"notBefore,omitempty"`
+	NotAfter       *time.Time             `json:"notAfter,omitempty"`
+	Error          *Problem               `json:"error,omitempty"`
+	Authorizations []string               `json:"authorizations"`
+	Finalize       string                 `json:"finalize"`
+	Certificate    string                 `json:"certificate,omitempty"`
+}
+
+func (s *Server) orderURL(ctx *gin.Context, id gocrud.ID) string {
+	return s.url(ctx, fmt.Sprintf("/order/%d", id))
+}
+
+func (s *Server) renderOrder(ctx *gin.Context, order *model.ACMEOrder) (*Order, error) {
+	var authorizations []model.ACMEAuthorization
+	err := s.DB.Model(&model.ACMEAuthorization{}).Where("order_id = ?", order.ID).Order("id").Find(&authorizations).Error
+	if err != nil {
+		return nil, err
+	}
+
+	rendered := &Order{
+		Status:         order.Status,
+		Expires:        order.Expires,
+		Identifiers:    order.Identifiers,
+		NotBefore:      order.NotBefore,
+		NotAfter:       order.NotAfter,
+		Error:          decodeProblem(order.Error),
+		Authorizations: make([]string, 0, len(authorizations)),
+		Finalize:       s.url(ctx, fmt.Sprintf("/order/%d/finalize", order.ID)),
+	}
+	for _, authorization := range authorizations {
+		rendered.Authorizations = append(rendered.Authorizations, s.url(ctx, fmt.Sprintf("/authz/%d", authorization.ID)))
+	}
+	if order.CertID != 0 {
+		rendered.Certificate = s.url(ctx, fmt.Sprintf("/certificate/%d", order.CertID))
+	}
+
+	return rendered, nil
+}
+
+// findOrder
+// Find the order of the account, pending or ready orders past their expiry become invalid
+func (s *Server) findOrder(account *model.ACMEAccount, id gocrud.ID) (*model.ACMEOrder, error) {
+	var order model.ACMEOrder
+	err := s.DB.Model(&order).First(&order, id).Error
+	if err != nil {
+		return nil, err
+	}
+	if order.AccountID != account.ID {
+		return nil, unauthorized("order %d does not belong to account %d", order.ID, account.ID)
+	}
+
+	if (order.Status == model.ACMEPending || order.Status == model.ACMEReady) && time.Now().After(order.Expires) {
+		order.Status = model.ACMEInvalid
+		order.Error = encodeProblem(malformed("order expired"))
+		err = s.DB.Save(&order).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &order, nil
+}
+
+type NewOrderPayload struct {
+	Identifiers []model.ACMEIdentifier `json:"identifiers"`
+	NotBefore   *time.Time             `json:"notBefore"`
+	NotAfter    *time.Time             `json:"notAfter"`
+}
+
+func validateIdentifier(identifier model.ACMEIdentifier) (model.ACMEIdentifier, bool, *Problem) {
+	if identifier.Type != IdentifierDNS {
+		return identifier, false, NewProblem(http.StatusBadRequest, UnsupportedIdentifier, "unsupported identifier type: %s", identifier.Type)
+	}
+
+	value := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(identifier.Value)), ".")
+	wildcard := strings.HasPrefix(value, "*.")
+	name := strings.TrimPrefix(value, "*.")
+	if name == "" || strings.Contains(name, "*") || !create.IsDNSName(name) {
+		return identifier, false, NewProblem(http.StatusBadRequest, RejectedIdentifier, "invalid dns name: %s", identifier.Value)
+	}
+
+	return model.ACMEIdentifier{Type: IdentifierDNS, Value: value}, wildcard, nil
+}
+
+func (s *Server) newOrder(ctx *gin.Context) {
+	req, problem := s.verify(ctx, withKID)
+	if problem != nil {
+		s.problem(ctx, problem)
+		return
+	}
+
+	var payload NewOrderPayload
+	err := json.Unmarshal(req.Payload, &payload)
+	if err != nil {
+		s.problem(ctx, malformed("invalid payload: %v", err))
+		return
+	}
+	if len(payload.Identifiers) == 0 {
+		s.problem(ctx, malformed("identifiers are required"))
+		return
+	}
+	if payload.NotBefore != nil && payload.NotAfter != nil && !payload.NotBefore.Before(*payload.NotAfter) {
+		s.problem(ctx, malformed("notBefore should be before notAfter"))
+		return
+	}
+
+	identifiers := make([]model.ACMEIdentifier, 0, len(payload.Identifiers))
+	wildcards := make([]bool, 0, len(payload.Identifiers))
+	for _, identifier := range payload.Identifiers {
+		validated, wildcard, problem := validateIdentifier(identifier)
+		if problem != nil {
+			s.problem(ctx, problem)
+			return
+		}
+		if slices.Contains(identifiers, validated) {
+			continue
+		}
+		identifiers = append(identifiers, validated)
+		wildcards = append(wildcards, wildcard)
+	}
+
+	order := model.ACMEOrder{
+		AccountID:   req.Account.ID,
+		Status:      model.ACMEPending,
+		Expires:     time.Now().Add(OrderValidity),
+		Identifiers: identifiers,
+		NotBefore:   payload.NotBefore,
+		NotAfter:    payload.NotAfter,
+	}
+
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(&order).Error
+		if err != nil {
+			return err
+		}
+
+		for i, identifier := range identifiers {
+			authorization := model.ACMEAuthorization{
+				AccountID:  req.Account.ID,
+				OrderID:    order.ID,
+				Status:     model.ACMEPending,
+				Expires:    order.Expires,
+				Identifier: model.ACMEIdentifier{Type: identifier.Type, Value: strings.TrimPrefix(identifier.Value, "*.")},
+				Wildcard:   wildcards[i],
+			}
+			err = tx.Create(&authorization).Error
+			if err != nil {
+				return err
+			}
+
+			// https://www.rfc-editor.org/rfc/rfc8555#section-7.1.3, wildcard domain names can only be validated with dns-01
+			types := []string{ChallengeHTTP01, ChallengeDNS01}
+			if authorization.Wildcard {
+				types = []string{ChallengeDNS01}
+			}
+			for _, challengeType := range types {
+				token, err := NewToken()
+				if err != nil {
+					return err
+				}
+				err = tx.Create(&model.ACMEChallenge{
+					AuthorizationID: authorization.ID,
+					Type:            challengeType,
+					Status:          model.ACMEPending,
+					Token:           token,
+				}).Error
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		s.handleError(ctx, err)
+		return
+	}
+
+	rendered, err := s.renderOrder(ctx, &order)
+	if err != nil {
+		s.handleError(ctx, err)
+		return
+	}
+
+	s.respond(ctx, http.StatusCreated, s.orderURL(ctx, order.ID), rendered)
+}
+
+func (s *Server) order(ctx *gin.Context) {
+	req, problem := s.verify(ctx, withKID)
+	if problem != nil {
+		s.problem(ctx, problem)
+		return
+	}
+
+	id, problem := paramID(ctx)
+	if problem != nil {
+		s.problem(ctx, problem)
+		return
+	}
+
+	order, err := s.findOrder(req.Account, id)
+	if err != nil {
+		s.handleError(ctx, err)
+		return
+	}
+
+	rendered, err := s.renderOrder(ctx, order)
+	if err != nil {
+		s.handleError(ctx, err)
+		return
+	}
+
+	s.respond(ctx, http.StatusOK, "", rendered)
+}
+
+type FinalizePayload struct {
+	CSR string `json:"csr"`
+}
+
+// csrNames
+// DNS names requested by the CSR, other types of SANs are not allowed
+func csrNames(csr *x509.CertificateRequest) ([]string, *Problem) {
+	if len(csr.IPAddresses) > 0 || len(csr.EmailAddresses) > 0 || len(csr.URIs) > 0 {
+		return nil, NewProblem(http.StatusBadRequest, BadCSR, "only dns names are allowed")
+	}
+
+	names := make([]string, 0, len(csr.DNSNames)+1)
+	for _, name := range append(csr.DNSNames, csr.Subject.CommonName) {
+		name = strings.TrimSuffix(strings.ToLower(name), ".")
+		if name == "" || slices.Contains(names, name) {
+			continue
+		}
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
+func (s *Server) finalize(ctx *gin.Context) {
+	req, problem := s.verify(ctx, withKID)
+	if problem != nil {
+		s.problem(ctx, problem)
+		return
+	}
+
+	id, problem := paramID(ctx)
+	if problem != nil {
+		s.problem(ctx, problem)
+		return
+	}
+
+	order, err := s.findOrder(req.Account, id)
+	if err != nil {
+		s.handleError(ctx, err)
+		return
+	}
+	if order.Status != model.ACMEReady {
+		s.problem(ctx, NewProblem(http.StatusForbidden, OrderNotReady, "order is %s", order.Status))
+		return
+	}
+
+	var payload FinalizePayload
+	err = json.Unmarshal(req.Payload, &payload)
+	if err != nil {
+		s.problem(ctx, malformed("invalid payload: %v", err))
+		return
+	}
+
+	der, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(payload.CSR, "="))
+	if err != nil {
+		s.problem(ctx, NewProblem(http.StatusBadRequest, BadCSR, "invalid base64url: %v", err))
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		s.problem(ctx, NewProblem(http.StatusBadRequest, BadCSR, "invalid csr: %v", err))
+		return
+	}
+	err = csr.CheckSignature()
+	if err != nil {
+		s.problem(ctx, NewProblem(http.StatusBadRequest, BadCSR, "invalid csr signature: %v", err))
+		return
+	}
+
+	names, problem := csrNames(csr)
+	if problem != nil {
+		s.problem(ctx, problem)
+		return
+	}
+	expected := make([]string, 0, len(order.Identifiers))
+	for _, identifier := range order.Identifiers {
+		expected = append(expected, identifier.Value)
+	}
+	slices.Sort(names)
+	slices.Sort(expected)
+	if !slices.Equal(names, expected) {
+		s.problem(ctx, NewProblem(http.StatusBadRequest, BadCSR, "names in csr %v do not match the order %v", names, expected))
+		return
+	}
+
+	order.Status = model.ACMEProcessing
+	err = s.DB.Save(order).Error
+	if err != nil {
+		s.handleError(ctx, err)
+		return
+	}
+
+	var notBefore, notAfter time.Time
+	if order.NotBefore != nil {
+		notBefore = *order.NotBefore
+	}
+	if order.NotAfter != nil {
+		notAfter = *order.NotAfter
+	}
+
+	cert, issueErr := s.Issue(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), notBefore, notAfter)
+	if issueErr != nil {
+		order.Status = model.ACMEInvalid
+		order.Error = encodeProblem(serverInternal(issueErr))
+	} else {
+		order.Status = model.ACMEValid
+		order.CertID = cert.ID
+	}
+	err = s.DB.Save(order).Error
+	if err != nil {
+		s.handleError(ctx, err)
+		return
+	}
+	if issueErr != nil {
+		s.handleError(ctx, issueErr)
+		return
+	}
+
+	rendered, err := s.renderOrder(ctx, order)
+	if err != nil {
+		s.handleError(ctx, err)
+		return
+	}
+
+	s.respond(ctx, http.StatusOK, s.orderURL(ctx, order.ID), rendered)
+}
+
+// refreshOrder
+// A pending order becomes ready once all of its authorizations are valid, or invalid if any of them fails
+func refreshOrder(db *gorm.DB, orderID gocrud.ID) error {
+	var order model.ACMEOrder
+	err := db.Model(&order).First(&order, orderID).Error
+	if err != nil {
+		return err
+	}
+	if order.Status != model.ACMEPending {
+		return nil
+	}
+
+	var authorizations []model.ACMEAuthorization
+	err = db.Model(&model.ACMEAuthorization{}).Where("order_id = ?", orderID).Find(&authorizations).Error
+	if err != nil {
+		return err
+	}
+
+	ready := true
+	for _, authorization := range authorizations {
+		switch authorization.Status {
+		case model.ACMEValid:
+		case model.ACMEPending:
+			ready = false
+		default:
+			order.Status = model.ACMEInvalid
+			order.Error = encodeProblem(unauthorized("authorization of %s is %s", authorization.Identifier.Value, authorization.Status))
+			return db.Save(&order).Error
+		}
+	}
+	if !ready {
+		return nil
+	}
+
+	order.Status = model.ACMEReady
+	return db.Save(&order).Error
+}
+
+// endregion order
+
+// region authorization and challenge
+
+type Authorization struct {
+	Identifier model.ACMEIdentifier `json:"identifier"`
+	Status     model.ACMEStatus     `json:"status"`
+	Expires    time.Time            `json:"expires"`
+	Challenges []Challenge          `json:"challenges"`
+	Wildcard   bool                 `json:"wildcard,omitempty"`
+}
+
+type Challenge struct {
+	Type      string           `json:"type"`
+	URL       string           `json:"url"`
+	Status    model.ACMEStatus `json:"status"`
+	Token     string           `json:"token"`
+	Validated *time.Time       `json:"validated,omitempty"`
+	Error     *Problem         `json:"error,omitempty"`
+}
+
+func (s *Server) renderChallenge(ctx *gin.Context, challenge *model.ACMEChallenge) Challenge {
+	return Challenge{
+		Type:      challenge.Type,
+		URL:       s.url(ctx, fmt.Sprintf("/challenge/%d", challenge.ID)),
+		Status:    challenge.Status,
+		Token:     challenge.Token,
+		Validated: challenge.Validated,
+		Error:     decodeProblem(challenge.Error),
+	}
+}
+
+func (s *Server) renderAuthorization(ctx *gin.Context, authorization *model.ACMEAuthorization) (*Authorization, error) {
+	var challenges []model.ACMEChallenge
+	err := s.DB.Model(&model.ACMEChallenge{}).Where("authorization_id = ?", authorization.ID).Order("id").Find(&challenges).Error
+	if err != nil {
+		return nil, err
+	}
+
+	rendered := &Authorization{
+		Identifier: authorization.Identifier,
+		Status:     authorization.Status,
+		Expires:    authorization.Expires,
+		Challenges: make([]Challenge, 0, len(challenges)),
+		Wildcard:   authorization.Wildcard,
+	}
+	for i := range challenges {
+		rendered.Challenges = append(rendered.Challenges, s.renderChallenge(ctx, &challenges[i]))
+	}
+
+	return rendered, nil
+}
+
+// findAuthorization
+// Find the authorization of the account, pending ones past their expiry become expired
+func (s *Server) findAuthorization(account *model.ACMEAccount, id gocrud.ID) (*model.ACMEAuthorization, error) {
+	var authorization model.ACMEAuthorization
+	err := s.DB.Model(&authorization).First(&authorization, id).Error
+	if err != nil {
+		return nil, err
+	}
+	if authorization.AccountID != account.ID {
+		return nil, unauthorized("authorization %d does not belong to account %d", authorization.ID, account.ID)
+	}
+
+	if authorization.Status == model.ACMEPending && time.Now().After(authorization.Expires) {
+		authorization.Status = model.ACMEExpired
+		err = s.DB.Transaction(func(tx *gorm.DB) error {
+			err := tx.Save(&authorization).Error
+			if err != nil {
+				return err
+			}
+			return refreshOrder(tx, authorization.OrderID)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &authorization, nil
+}
+
+type UpdateAuthorizationPayload struct {
+	Status model.ACMEStatus `json:"status"`
+}
+
+func (s *Server) authorization(ctx *gin.Context) {
+	req, problem := s.verify(ctx, withKID)
+	if problem != nil {
+		s.problem(ctx, problem)
+		return
+	}
+
+	id, problem := paramID(ctx)
+	if problem != nil {
+		s.problem(ctx, problem)
+		return
+	}
+
+	authorization, err := s.findAuthorization(req.Account, id)
+	if err != nil {
+		s.handleError(ctx, err)
+		return
+	}
+
+	if !req.IsPostAsGet() {
+		var payload UpdateAuthorizationPayload
+		err = json.Unmarshal(req.Payload, &payload)
+		if err != nil {
+			s.problem(ctx, malformed("invalid payload: %v", err))
+			return
+		}
+		if payload.Status != model.ACMEDeactivated {
+			s.problem(ctx, malformed("status can only be changed to %s", model.ACMEDeactivated))
+			return
+		}
+		if authorization.Status != model.ACMEPending && authorization.Status != model.ACMEValid {
+			s.problem(ctx, malformed("authorization is %s", authorization.Status))
+			return
+		}
+
+		authorization.Status = model.ACMEDeactivated
+		err = s.DB.Transaction(func(tx *gorm.DB) error {
+			err := tx.Save(authorization).Error
+			if err != nil {
+				return err
+			}
+			return refreshOrder(tx, authorization.OrderID)
+		})
+		if err != nil {
+			s.handleError(ctx, err)
+			return
+		}
+	}
+
+	rendered, err := s.renderAuthorization(ctx, authorization)
+	if err != nil {
+		s.handleError(ctx, err)
+		return
+	}
+
+	s.respond(ctx, http.StatusOK, "", rendered)
+}
+
+func (s *Server) challenge(ctx *gin.Context) {
+	req, problem := s.verify(ctx, withKID)
+	if problem != nil {
+		s.problem(ctx, problem)
+		return
+	}
+
+	id, problem := paramID(ctx)
+	if problem != nil {
+		s.problem(ctx, problem)
+		return
+	}
+
+	var challenge model.ACMEChallenge
+	err := s.DB.Model(&challenge).Where("id = ?", id).First(&challenge).Error
+	if err != nil {
+		s.handleError(ctx, err)
+		return
+	}
+
+	authorization, err := s.findAuthorization(req.Account, challenge.AuthorizationID)
+	if err != nil {
+		s.handleError(ctx, err)
+		return
+	}
+
+	// an empty JSON object asks the server to validate the challenge, https://www.rfc-editor.org/rfc/rfc8555#section-7.5.1
+	if !req.IsPostAsGet() && challenge.Status == model.ACMEPending {
+		if authorization.Status != model.ACMEPending {
+			s.problem(ctx, malformed("authorization is %s", authorization.Status))
+			return
+		}
+
+		keyAuthorization := challenge.Token + "." + req.Account.Thumbprint
+
+		challenge.Status = model.ACMEProcessing
+		err = s.DB.Save(&challenge).Error
+		if err != nil {
+			s.handleError(ctx, err)
+			return
+		}
+
+		go s.validate(challenge, *authorization, keyAuthorization)
+	}
+
+	ctx.Header("Link", fmt.Sprintf(`<%s>;rel="up"`, s.url(ctx, fmt.Sprintf("/authz/%d", authorization.ID))))
+	s.respond(ctx, http.StatusOK, "", s.renderChallenge(ctx, &challenge))
+}
+
+// endregion authorization and challenge
+
+// region certificate
+
+func (s *Server) certificate(ctx *gin.Context) {
+	req, problem := s.verify(ctx, withKID)
+	if problem != nil {
+		s.problem(ctx, problem)
+		return
+	}
+
+	id, problem := paramID(ctx)
+	if problem != nil {
+		s.problem(ctx, problem)
+		return
+	}
+
+	var count int64
+	err := s.DB.Model(&model.ACMEOrder{}).
+		Where("account_id = ? AND cert_id = ?", req.Account.ID, id).
+		Count(&count).Error
+	if err != nil {
+		s.handleError(ctx, err)
+		return
+	}
+	if count == 0 {
+		s.problem(ctx, unauthorized("certificate %d was not issued to account %d", id, req.Account.ID))
+		return
+	}
+
+	var cert model.Cert
+	err = s.DB.Model(&cert).Where("id = ?", id).First(&cert).Error
+	if err != nil {
+		s.handleError(ctx, err)
+		return
+	}
+	err = cert.Decode()
+	if err != nil {
+		s.handleError(ctx, err)
+		return
+	}
+
+	ctx.Data(http.StatusOK, CertificateChainMimeType, cert.Crt.ToBytes())
+}
+
+type RevokeCertPayload struct {
+	Certificate string `json:"certificate"`
+	Reason      *int   `json:"reason"`
+}
+
+func (s *Server) revokeCert(ctx *gin.Context) {
+	req, problem := s.verify(ctx, withEither)
+	if problem != nil {
+		s.problem(ctx, problem)
+		return
+	}
+
+	var payload RevokeCertPayload
+	err := json.Unmarshal(req.Payload, &payload)
+	if err != nil {
+		s.problem(ctx, malformed("invalid payload: %v", err))
+		return
+	}
+
+	reason := revoke.Unspecified
+	if payload.Reason != nil {
+		reason = revoke.Reason(*payload.Reason)
+		if !slices.Contains(slices.Collect(maps.Values(revoke.AllReasons)), reason) {
+			s.problem(ctx, NewProblem(http.StatusBadRequest, BadRevocationReason, "invalid reason: %d", *payload.Reason))
+			return
+		}
+	}
+
+	der, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(payload.Certificate, "="))
+	if err != nil {
+		s.problem(ctx, malformed("invalid base64url: %v", err))
+		return
+	}
+	crt, err := x509.ParseCertificate(der)
+	if err != nil {
+		s.problem(ctx, malformed("invalid certificate: %v", err))
+		return
+	}
+
+	cert, err := s.findCert(crt)
+	if err != nil {
+		s.handleError(ctx, err)
+		return
+	}
+
+	if req.Account != nil {
+		var count int64
+		err = s.DB.Model(&model.ACMEOrder{}).Where("account_id = ? AND cert_id = ?", req.Account.ID, cert.ID).Count(&count).Error
+		if err != nil {
+			s.handleError(ctx, err)
+			return
+		}
+		if count == 0 {
+			s.problem(ctx, unauthorized("certificate was not issued to account %d", req.Account.ID))
+			return
+		}
+	} else {
+		publicKey, ok := crt.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+		if !ok || !publicKey.Equal(req.JWK.Key) {
+			s.problem(ctx, unauthorized("jwk does not match the certificate"))
+			return
+		}
+	}
+
+	if cert.RevokedAt != nil {
+		s.problem(ctx, NewProblem(http.StatusBadRequest, AlreadyRevoked, "certificate has been revoked"))
+		return
+	}
+
+	err = s.Revoke(cert, reason)
+	if err != nil {
+		s.handleError(ctx, err)
+		return
+	}
+
+	ctx.Status(http.StatusOK)
+}
+
+// findCert
+// Find the decoded model.Cert of crt by its serial number
+func (s *Server) findCert(crt *x509.Certificate) (*model.Cert, error) {
+	var certs []model.Cert
+	err := s.DB.Model(&model.Cert{}).Where("serial_number = ?", model.SerialNumber(crt.SerialNumber)).Find(&certs).Error
+	if err != nil {
+		return nil, err
+	}
+	for i := range certs {
+		err = certs[i].Decode()
+		if err != nil {
+			return nil, err
+		}
+		stored, err := native.ParseCrt(certs[i].Crt.ToBytes())
+		if err != nil {
+			return nil, err
+		}
+		if stored.Equal(crt) {
+			return &certs[i], nil
+		}
+	}
+	return nil, notFound("certificate not found")
+}
+
+// endregion certificate
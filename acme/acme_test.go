@@ -0,0 +1,302 @@
+package acme_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"github.com/allape/stepin/acme"
+	"github.com/allape/stepin/model"
+	"github.com/allape/stepin/stepin/create"
+	"github.com/allape/stepin/stepin/native"
+	"github.com/allape/stepin/stepin/revoke"
+	"github.com/gin-gonic/gin"
+	client "golang.org/x/crypto/acme"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestServer(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "acme.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.Cert{}, &model.ACMEAccount{}, &model.ACMEOrder{}, &model.ACMEAuthorization{}, &model.ACMEChallenge{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backend := native.Backend{}
+	_, caCrt, caKey, err := backend.NewRootCA(create.RootOptions{
+		PrimaryOptions: create.PrimaryOptions{
+			Subject:  "acme root",
+			Password: "123456",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// region http-01 responder and dns-01 records
+
+	var (
+		locker    sync.Mutex
+		responses = map[string]string{}
+		records   = map[string][]string{}
+	)
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		locker.Lock()
+		defer locker.Unlock()
+		response, ok := responses[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(response))
+	}))
+	defer responder.Close()
+
+	_, port, err := net.SplitHostPort(responder.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	http01Port, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// endregion http-01 responder and dns-01 records
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	_, err = acme.Setup(engine.Group("acme"), acme.Config{
+		DB: db,
+		Issue: func(csr create.CSR, notBefore, notAfter time.Time) (*model.Cert, error) {
+			inspection, crt, err := backend.SignCSR(create.SignOptions{
+				CSR:          csr,
+				RootCaCrt:    caCrt,
+				RootCaKey:    caKey,
+				RootPassword: "123456",
+			}, create.OptionBundle{Bundle: true})
+			if err != nil {
+				return nil, err
+			}
+			cert := &model.Cert{
+				Profile:    create.Leaf,
+				Name:       "acme",
+				Crt:        model.CensoredField(base64.StdEncoding.EncodeToString(crt)),
+				Inspection: inspection,
+			}
+			err = cert.ParseCrt()
+			if err != nil {
+				return nil, err
+			}
+			err = cert.Encode()
+			if err != nil {
+				return nil, err
+			}
+			return cert, db.Create(cert).Error
+		},
+		Revoke: func(cert *model.Cert, reason revoke.Reason) error {
+			now := time.Now()
+			return db.Model(cert).Updates(&model.Cert{RevokedAt: &now, RevocationReason: reason}).Error
+		},
+		HTTP01Port: http01Port,
+		LookupTXT: func(_ context.Context, name string) ([]string, error) {
+			locker.Lock()
+			defer locker.Unlock()
+			return records[name], nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	accountKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	c := &client.Client{
+		Key:          accountKey,
+		DirectoryURL: server.URL + "/acme/directory",
+	}
+
+	_, err = c.Register(ctx, &client.Account{Contact: []string{"mailto:admin@example.internal"}}, client.AcceptTOS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ids in the path are never passed to the database as they are
+	for _, request := range []func(uri string) error{
+		func(uri string) error { _, err := c.GetOrder(ctx, uri); return err },
+		func(uri string) error { _, err := c.GetAuthorization(ctx, uri); return err },
+		func(uri string) error { _, err := c.GetChallenge(ctx, uri); return err },
+		func(uri string) error { _, err := c.FetchCert(ctx, uri, false); return err },
+	} {
+		for _, path := range []string{"/acme/order/", "/acme/authz/", "/acme/challenge/", "/acme/certificate/"} {
+			uri := server.URL + path + "0=0"
+			var acmeError *client.Error
+			if err := request(uri); !errors.As(err, &acmeError) || acmeError.StatusCode != http.StatusNotFound {
+				t.Fatalf("%s should not be found, got %v", uri, err)
+			}
+		}
+	}
+
+	order, err := c.AuthorizeOrder(ctx, client.DomainIDs("localhost", "*.example.internal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != client.StatusPending {
+		t.Fatalf("unexpected order status: %s", order.Status)
+	}
+
+	for _, uri := range order.AuthzURLs {
+		authorization, err := c.GetAuthorization(ctx, uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var challenge *client.Challenge
+		for _, ch := range authorization.Challenges {
+			if authorization.Wildcard && ch.Type == acme.ChallengeHTTP01 {
+				t.Fatal("http-01 should not be offered for wildcard identifiers")
+			}
+			if (authorization.Wildcard && ch.Type == acme.ChallengeDNS01) || (!authorization.Wildcard && ch.Type == acme.ChallengeHTTP01) {
+				challenge = ch
+			}
+		}
+		if challenge == nil {
+			t.Fatalf("no suitable challenge for %s", authorization.Identifier.Value)
+		}
+
+		switch challenge.Type {
+		case acme.ChallengeHTTP01:
+			response, err := c.HTTP01ChallengeResponse(challenge.Token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			locker.Lock()
+			responses[c.HTTP01ChallengePath(challenge.Token)] = response
+			locker.Unlock()
+		case acme.ChallengeDNS01:
+			record, err := c.DNS01ChallengeRecord(challenge.Token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			locker.Lock()
+			records["_acme-challenge."+authorization.Identifier.Value] = []string{record}
+			locker.Unlock()
+		}
+
+		_, err = c.Accept(ctx, challenge)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = c.WaitAuthorization(ctx, uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	order, err = c.WaitOrder(ctx, order.URI)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// names must match the order
+	badCSR, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		DNSNames: []string{"localhost", "other.example.internal"},
+	}, leafKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = c.CreateOrderCert(ctx, order.FinalizeURL, badCSR, true)
+	if err == nil || !strings.Contains(err.Error(), string(acme.BadCSR)) {
+		t.Fatalf("csr with names not in the order should be rejected, got %v", err)
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "localhost"},
+		DNSNames: []string{"localhost", "*.example.internal"},
+	}, leafKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, _, err := c.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 2 {
+		t.Fatalf("certificate should be bundled with its issuer, got %d certs", len(chain))
+	}
+
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := native.ParseCrt(caCrt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	for _, name := range []string{"localhost", "www.example.internal"} {
+		_, err = leaf.Verify(x509.VerifyOptions{DNSName: name, Roots: pool})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var stored model.Cert
+	err = db.Model(&stored).Where("serial_number = ?", model.SerialNumber(leaf.SerialNumber)).First(&stored).Error
+	if err != nil {
+		t.Fatalf("issued certificate should be stored: %v", err)
+	}
+
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.RevokeCert(ctx, otherKey, chain[0], client.CRLReasonKeyCompromise)
+	if err == nil || !strings.Contains(err.Error(), string(acme.Unauthorized)) {
+		t.Fatalf("revocation signed by an unrelated key should be rejected, got %v", err)
+	}
+
+	// signed with the key of the certificate
+	err = c.RevokeCert(ctx, leafKey, chain[0], client.CRLReasonKeyCompromise)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Model(&stored).First(&stored, stored.ID).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.RevokedAt == nil || stored.RevocationReason != revoke.KeyCompromise {
+		t.Fatalf("certificate should be revoked with key compromise, got %v %v", stored.RevokedAt, stored.RevocationReason)
+	}
+}
@@ -0,0 +1,204 @@
+package acme
+
+import (
+	"crypto"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/allape/stepin/model"
+	"github.com/gin-gonic/gin"
+	"github.com/go-jose/go-jose/v3"
+	"gorm.io/gorm"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// https://www.rfc-editor.org/rfc/rfc8555#section-6.2
+
+const (
+	NonceValidity = 30 * time.Minute
+	MaxBodySize   = 1 << 20
+)
+
+var SupportedAlgorithms = []jose.SignatureAlgorithm{
+	jose.RS256, jose.RS384, jose.RS512,
+	jose.PS256, jose.PS384, jose.PS512,
+	jose.ES256, jose.ES384, jose.ES512,
+	jose.EdDSA,
+}
+
+type nonces struct {
+	locker sync.Mutex
+	values map[string]time.Time
+}
+
+func (n *nonces) New() (string, error) {
+	bs := make([]byte, 16)
+	_, err := rand.Read(bs)
+	if err != nil {
+		return "", err
+	}
+	nonce := base64.RawURLEncoding.EncodeToString(bs)
+
+	n.locker.Lock()
+	defer n.locker.Unlock()
+
+	now := time.Now()
+	for value, expires := range n.values {
+		if now.After(expires) {
+			delete(n.values, value)
+		}
+	}
+	n.values[nonce] = now.Add(NonceValidity)
+
+	return nonce, nil
+}
+
+// Use
+// A nonce can be used only once
+func (n *nonces) Use(nonce string) bool {
+	n.locker.Lock()
+	defer n.locker.Unlock()
+
+	expires, ok := n.values[nonce]
+	if !ok {
+		return false
+	}
+	delete(n.values, nonce)
+	return time.Now().Before(expires)
+}
+
+type keySource int
+
+const (
+	withJWK keySource = iota + 1
+	withKID
+	withEither
+)
+
+// request
+// Verified JWS request, Account is nil when it is signed with a JWK
+type request struct {
+	URL     string
+	Payload []byte
+	JWK     *jose.JSONWebKey
+	Account *model.ACMEAccount
+}
+
+// IsPostAsGet
+// https://www.rfc-editor.org/rfc/rfc8555#section-6.3
+func (r *request) IsPostAsGet() bool {
+	return len(r.Payload) == 0
+}
+
+func (s *Server) verify(ctx *gin.Context, source keySource) (*request, *Problem) {
+	body, err := io.ReadAll(io.LimitReader(ctx.Request.Body, MaxBodySize))
+	if err != nil {
+		return nil, malformed("failed to read body: %v", err)
+	}
+
+	jws, err := jose.ParseSigned(string(body))
+	if err != nil {
+		return nil, malformed("invalid jws: %v", err)
+	}
+	if len(jws.Signatures) != 1 {
+		return nil, malformed("exactly one signature is required")
+	}
+
+	header := jws.Signatures[0].Protected
+	if !slices.Contains(SupportedAlgorithms, jose.SignatureAlgorithm(header.Algorithm)) {
+		return nil, NewProblem(http.StatusBadRequest, BadSignatureAlgorithm, "unsupported algorithm: %s", header.Algorithm)
+	}
+
+	if !s.nonces.Use(header.Nonce) {
+		return nil, NewProblem(http.StatusBadRequest, BadNonce, "invalid nonce: %s", header.Nonce)
+	}
+
+	expectedURL := s.url(ctx, strings.TrimPrefix(ctx.Request.URL.Path, s.basePath))
+	url, _ := header.ExtraHeaders["url"].(string)
+	if url != expectedURL {
+		return nil, unauthorized("url in protected header does not match the request: %s", url)
+	}
+
+	req := &request{URL: url}
+
+	switch {
+	case header.JSONWebKey != nil && header.KeyID != "":
+		return nil, malformed("jwk and kid are mutually exclusive")
+	case header.JSONWebKey != nil:
+		if source == withKID {
+			return nil, malformed("kid is required")
+		}
+		if !header.JSONWebKey.Valid() || !header.JSONWebKey.IsPublic() {
+			return nil, malformed("invalid jwk")
+		}
+		req.JWK = header.JSONWebKey
+	case header.KeyID != "":
+		if source == withJWK {
+			return nil, malformed("jwk is required")
+		}
+		req.Account, req.JWK, err = s.findAccount(ctx, header.KeyID)
+		if err != nil {
+			var problem *Problem
+			if errors.As(err, &problem) {
+				return nil, problem
+			}
+			return nil, serverInternal(err)
+		}
+	default:
+		return nil, malformed("jwk or kid is required")
+	}
+
+	req.Payload, err = jws.Verify(req.JWK)
+	if err != nil {
+		return nil, malformed("invalid signature: %v", err)
+	}
+
+	return req, nil
+}
+
+func (s *Server) findAccount(ctx *gin.Context, kid string) (*model.ACMEAccount, *jose.JSONWebKey, error) {
+	prefix := s.url(ctx, "/account/")
+	if !strings.HasPrefix(kid, prefix) {
+		return nil, nil, NewProblem(http.StatusBadRequest, AccountDoesNotExist, "unknown kid: %s", kid)
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(kid, prefix), 10, 64)
+	if err != nil {
+		return nil, nil, NewProblem(http.StatusBadRequest, AccountDoesNotExist, "unknown kid: %s", kid)
+	}
+
+	var account model.ACMEAccount
+	err = s.DB.Model(&account).First(&account, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, NewProblem(http.StatusBadRequest, AccountDoesNotExist, "account %d does not exist", id)
+	} else if err != nil {
+		return nil, nil, err
+	}
+	if account.Status != model.ACMEValid {
+		return nil, nil, unauthorized("account %d is %s", account.ID, account.Status)
+	}
+
+	var key jose.JSONWebKey
+	err = json.Unmarshal([]byte(account.Key), &key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &account, &key, nil
+}
+
+// Thumbprint
+// https://www.rfc-editor.org/rfc/rfc8555#section-8.1
+func Thumbprint(key *jose.JSONWebKey) (string, error) {
+	thumbprint, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(thumbprint), nil
+}
@@ -0,0 +1,91 @@
+package acme
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// https://www.rfc-editor.org/rfc/rfc8555#section-6.7
+
+const ProblemMimeType = "application/problem+json"
+
+type ProblemType string
+
+const (
+	AccountDoesNotExist   ProblemType = "urn:ietf:params:acme:error:accountDoesNotExist"
+	AlreadyRevoked        ProblemType = "urn:ietf:params:acme:error:alreadyRevoked"
+	BadCSR                ProblemType = "urn:ietf:params:acme:error:badCSR"
+	BadNonce              ProblemType = "urn:ietf:params:acme:error:badNonce"
+	BadRevocationReason   ProblemType = "urn:ietf:params:acme:error:badRevocationReason"
+	BadSignatureAlgorithm ProblemType = "urn:ietf:params:acme:error:badSignatureAlgorithm"
+	Connection            ProblemType = "urn:ietf:params:acme:error:connection"
+	DNS                   ProblemType = "urn:ietf:params:acme:error:dns"
+	IncorrectResponse     ProblemType = "urn:ietf:params:acme:error:incorrectResponse"
+	InvalidContact        ProblemType = "urn:ietf:params:acme:error:invalidContact"
+	Malformed             ProblemType = "urn:ietf:params:acme:error:malformed"
+	OrderNotReady         ProblemType = "urn:ietf:params:acme:error:orderNotReady"
+	RejectedIdentifier    ProblemType = "urn:ietf:params:acme:error:rejectedIdentifier"
+	ServerInternal        ProblemType = "urn:ietf:params:acme:error:serverInternal"
+	Unauthorized          ProblemType = "urn:ietf:params:acme:error:unauthorized"
+	UnsupportedContact    ProblemType = "urn:ietf:params:acme:error:unsupportedContact"
+	UnsupportedIdentifier ProblemType = "urn:ietf:params:acme:error:unsupportedIdentifier"
+	UserActionRequired    ProblemType = "urn:ietf:params:acme:error:userActionRequired"
+	NotFound              ProblemType = "about:blank"
+)
+
+type Problem struct {
+	Type   ProblemType `json:"type"`
+	Detail string      `json:"detail"`
+	Status int         `json:"status"`
+}
+
+func (p *Problem) Error() string {
+	return fmt.Sprintf("%s: %s", p.Type, p.Detail)
+}
+
+func NewProblem(status int, problemType ProblemType, format string, args ...any) *Problem {
+	return &Problem{
+		Type:   problemType,
+		Detail: fmt.Sprintf(format, args...),
+		Status: status,
+	}
+}
+
+func malformed(format string, args ...any) *Problem {
+	return NewProblem(http.StatusBadRequest, Malformed, format, args...)
+}
+
+func unauthorized(format string, args ...any) *Problem {
+	return NewProblem(http.StatusForbidden, Unauthorized, format, args...)
+}
+
+func notFound(format string, args ...any) *Problem {
+	return NewProblem(http.StatusNotFound, NotFound, format, args...)
+}
+
+func serverInternal(err error) *Problem {
+	return NewProblem(http.StatusInternalServerError, ServerInternal, "%v", err)
+}
+
+// encodeProblem
+// Problems of challenges and orders are stored as JSON strings
+func encodeProblem(p *Problem) string {
+	if p == nil {
+		return ""
+	}
+	bs, _ := json.Marshal(p)
+	return string(bs)
+}
+
+func decodeProblem(s string) *Problem {
+	if s == "" {
+		return nil
+	}
+	var p Problem
+	err := json.Unmarshal([]byte(s), &p)
+	if err != nil {
+		return &Problem{Type: ServerInternal, Detail: s}
+	}
+	return &p
+}
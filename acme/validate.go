@@ -0,0 +1,153 @@
+package acme
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/allape/stepin/model"
+	"gorm.io/gorm"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ValidationAttempts = 3
+	ValidationInterval = 5 * time.Second
+	ValidationTimeout  = 10 * time.Second
+)
+
+// NewToken
+// https://www.rfc-editor.org/rfc/rfc8555#section-8.1, at least 128 bits of entropy
+func NewToken() (string, error) {
+	bs := make([]byte, 32)
+	_, err := rand.Read(bs)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bs), nil
+}
+
+// validate
+// Validate the challenge in the background, the challenge, its authorization and order are updated with the result
+func (s *Server) validate(challenge model.ACMEChallenge, authorization model.ACMEAuthorization, keyAuthorization string) {
+	var problem *Problem
+	for attempt := 0; attempt < ValidationAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(ValidationInterval)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), ValidationTimeout)
+		switch challenge.Type {
+		case ChallengeHTTP01:
+			problem = s.validateHTTP01(ctx, authorization.Identifier.Value, challenge.Token, keyAuthorization)
+		case ChallengeDNS01:
+			problem = s.validateDNS01(ctx, authorization.Identifier.Value, keyAuthorization)
+		default:
+			problem = malformed("unsupported challenge type: %s", challenge.Type)
+		}
+		cancel()
+
+		if problem == nil {
+			break
+		}
+	}
+
+	now := time.Now()
+	if problem == nil {
+		challenge.Status = model.ACMEValid
+		challenge.Validated = &now
+		authorization.Status = model.ACMEValid
+	} else {
+		l.Warn().Printf("%s challenge %d of %s failed: %v", challenge.Type, challenge.ID, authorization.Identifier.Value, problem)
+		challenge.Status = model.ACMEInvalid
+		challenge.Error = encodeProblem(problem)
+		authorization.Status = model.ACMEInvalid
+	}
+
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Save(&challenge).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&model.ACMEAuthorization{}).
+			Where("id = ? AND status = ?", authorization.ID, model.ACMEPending).
+			Update("status", authorization.Status).Error
+		if err != nil {
+			return err
+		}
+		return refreshOrder(tx, authorization.OrderID)
+	})
+	if err != nil {
+		l.Error().Printf("failed to save result of challenge %d: %v", challenge.ID, err)
+	}
+}
+
+// validateHTTP01
+// https://www.rfc-editor.org/rfc/rfc8555#section-8.3
+func (s *Server) validateHTTP01(ctx context.Context, domain, token, keyAuthorization string) *Problem {
+	host := domain
+	if s.HTTP01Port != 80 {
+		host = net.JoinHostPort(domain, strconv.Itoa(s.HTTP01Port))
+	}
+	url := fmt.Sprintf("http://%s/.well-known/acme-challenge/%s", host, token)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return serverInternal(err)
+	}
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("too many redirects")
+			}
+			return nil
+		},
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return NewProblem(http.StatusBadRequest, Connection, "failed to fetch %s: %v", url, err)
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	if res.StatusCode != http.StatusOK {
+		return NewProblem(http.StatusForbidden, IncorrectResponse, "unexpected status code %d from %s", res.StatusCode, url)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 4096))
+	if err != nil {
+		return NewProblem(http.StatusBadRequest, Connection, "failed to read response from %s: %v", url, err)
+	}
+	if strings.TrimSpace(string(body)) != keyAuthorization {
+		return NewProblem(http.StatusForbidden, IncorrectResponse, "key authorization from %s does not match", url)
+	}
+
+	return nil
+}
+
+// validateDNS01
+// https://www.rfc-editor.org/rfc/rfc8555#section-8.4
+func (s *Server) validateDNS01(ctx context.Context, domain, keyAuthorization string) *Problem {
+	name := "_acme-challenge." + domain
+	records, err := s.LookupTXT(ctx, name)
+	if err != nil {
+		return NewProblem(http.StatusBadRequest, DNS, "failed to lookup TXT records of %s: %v", name, err)
+	}
+
+	digest := sha256.Sum256([]byte(keyAuthorization))
+	if !slices.Contains(records, base64.RawURLEncoding.EncodeToString(digest[:])) {
+		return NewProblem(http.StatusForbidden, IncorrectResponse, "no matching TXT record found for %s", name)
+	}
+
+	return nil
+}
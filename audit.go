@@ -0,0 +1,53 @@
+package main
+
+import (
+	"github.com/allape/gocrud"
+	"github.com/allape/stepin/audit"
+	"github.com/allape/stepin/auth"
+	"github.com/allape/stepin/model"
+	"github.com/allape/stepin/storage"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"time"
+)
+
+func SetupAuditController(group *gin.RouterGroup, db *gorm.DB) error {
+	group = group.Group("audit", auth.Require(model.RoleCAAdmin))
+
+	group.GET("verify", func(context *gin.Context) {
+		result, err := audit.Verify(db)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+		context.JSON(http.StatusOK, gocrud.R[*audit.VerifyResult]{
+			Code: gocrud.RestCoder.OK(),
+			Data: result,
+		})
+	})
+
+	return gocrud.New(group, db, gocrud.Crud[model.AuditLog]{
+		DisableSave:   true,
+		DisableDelete: true,
+		SearchHandlers: gocrud.SearchHandlers{
+			"action":        storage.KeywordEqual("action", nil),
+			"actorID":       storage.KeywordEqual("actor_id", gocrud.NumericValidate),
+			"certID":        storage.KeywordEqual("cert_id", gocrud.NumericValidate),
+			"outcome":       storage.KeywordEqual("outcome", nil),
+			"createdAfter":  storage.KeywordStatement("created_at", gocrud.OperatorGte, parseTime),
+			"createdBefore": storage.KeywordStatement("created_at", gocrud.OperatorLt, parseTime),
+			"sortByID":      storage.SortBy("id"),
+		},
+	})
+}
+
+// parseTime
+// RFC 3339 time for search handlers, nil to ignore the invalid one
+func parseTime(value string) any {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil
+	}
+	return t.UTC()
+}
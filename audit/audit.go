@@ -0,0 +1,159 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/allape/gocrud"
+	"github.com/allape/gogger"
+	"github.com/allape/stepin/model"
+	"gorm.io/gorm"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	CertCreate   = "cert.create"
+	CertSignCSR  = "cert.csr"
+	CertRenew    = "cert.renew"
+	CertRevoke   = "cert.revoke"
+	CertDownload = "cert.download"
+	CertPolicy   = "cert.policy"
+	CertImport   = "cert.import"
+	CertArchive  = "cert.archive"
+	CertDelete   = "cert.delete"
+	CertRestore  = "cert.restore"
+	Backup       = "backup"
+	Restore      = "restore"
+	UserCreate   = "user.create"
+	UserUpdate   = "user.update"
+	UserDelete   = "user.delete"
+	TokenCreate  = "token.create"
+	TokenDelete  = "token.delete"
+
+	TemplateSave   = "template.save"
+	TemplateDelete = "template.delete"
+)
+
+const Redacted = "******"
+
+var l = gogger.New("audit")
+
+var (
+	locker    sync.Mutex
+	errBroken = errors.New("broken chain")
+)
+
+// Hash
+// SHA-256 of all fields except ID and Hash in lower case hex
+func Hash(entry model.AuditLog) string {
+	bs, _ := json.Marshal([]any{
+		entry.CreatedAt.UTC().Format(time.RFC3339Nano),
+		entry.ActorID,
+		entry.Actor,
+		entry.SourceIP,
+		entry.Action,
+		entry.CertID,
+		entry.Parameters,
+		entry.Outcome,
+		entry.Message,
+		entry.PrevHash,
+	})
+	sum := sha256.Sum256(bs)
+	return hex.EncodeToString(sum[:])
+}
+
+// Append
+// Chain the entry to the latest one and store it
+func Append(db *gorm.DB, entry *model.AuditLog) error {
+	locker.Lock()
+	defer locker.Unlock()
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var latest model.AuditLog
+		err := tx.Model(&latest).Order("id DESC").Limit(1).Find(&latest).Error
+		if err != nil {
+			return err
+		}
+
+		entry.ID = 0
+		// the precision of most databases
+		entry.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+		entry.PrevHash = latest.Hash
+		entry.Hash = Hash(*entry)
+
+		return tx.Create(entry).Error
+	})
+}
+
+type VerifyResult struct {
+	Valid    bool      `json:"valid"`
+	Count    int64     `json:"count"`
+	Head     string    `json:"head"`     // hash of the latest entry, keep it somewhere else to detect truncation
+	BrokenID gocrud.ID `json:"brokenID"` // the first entry which does not match the chain
+	Message  string    `json:"message"`
+}
+
+// Verify
+// Walk through the chain from the first entry
+func Verify(db *gorm.DB) (*VerifyResult, error) {
+	result := &VerifyResult{Valid: true}
+
+	var entries []model.AuditLog
+	err := db.Model(&model.AuditLog{}).Order("id ASC").FindInBatches(&entries, 500, func(_ *gorm.DB, _ int) error {
+		for _, entry := range entries {
+			switch {
+			case entry.PrevHash != result.Head:
+				result.Message = fmt.Sprintf("entry %d is not chained to the previous one", entry.ID)
+			case Hash(entry) != entry.Hash:
+				result.Message = fmt.Sprintf("entry %d has been modified", entry.ID)
+			default:
+				result.Count++
+				result.Head = entry.Hash
+				continue
+			}
+			result.Valid = false
+			result.BrokenID = entry.ID
+			return errBroken
+		}
+		return nil
+	}).Error
+	if err != nil && !errors.Is(err, errBroken) {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Redact
+// Replace values of secret-like keys in the decoded JSON
+func Redact(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			if IsSecret(key) {
+				v[key] = Redacted
+			} else {
+				v[key] = Redact(item)
+			}
+		}
+	case []any:
+		for i := range v {
+			v[i] = Redact(v[i])
+		}
+	}
+	return value
+}
+
+func IsSecret(key string) bool {
+	key = strings.ToLower(key)
+	for _, word := range []string{"pass", "secret", "token", "privatekey"} {
+		if strings.Contains(key, word) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package audit_test
+
+import (
+	"github.com/allape/stepin/audit"
+	"github.com/allape/stepin/model"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "audit.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.AuditLog{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 5; i++ {
+		err = audit.Append(db, &model.AuditLog{
+			ActorID:    1,
+			Actor:      "admin",
+			Action:     audit.CertCreate,
+			CertID:     1,
+			Parameters: `{"body":{"name":"root"}}`,
+			Outcome:    model.AuditSuccess,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := audit.Verify(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Valid || result.Count != 5 {
+		t.Fatalf("chain should be valid, got %+v", result)
+	}
+
+	err = db.Model(&model.AuditLog{}).Where("id = ?", 3).UpdateColumn("actor", "eve").Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err = audit.Verify(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Valid || result.BrokenID != 3 {
+		t.Fatalf("modified entry 3 should be detected, got %+v", result)
+	}
+
+	// re-hashing the modified entry does not help, the next one is not chained to it
+	var modified model.AuditLog
+	err = db.First(&modified, 3).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Model(&modified).UpdateColumn("hash", audit.Hash(modified)).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err = audit.Verify(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Valid || result.BrokenID != 4 {
+		t.Fatalf("entry 4 should be broken, got %+v", result)
+	}
+
+	err = db.Delete(&model.AuditLog{}, 3).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err = audit.Verify(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Valid || result.BrokenID != 4 {
+		t.Fatalf("deleted entry 3 should be detected, got %+v", result)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	body := map[string]any{
+		"name":             "root",
+		"pass":             "123456",
+		"parentCaPassword": "456789",
+		"nested":           []any{map[string]any{"secret": "s", "keyType": "EC"}},
+	}
+	audit.Redact(body)
+
+	if body["name"] != "root" || body["pass"] != audit.Redacted || body["parentCaPassword"] != audit.Redacted {
+		t.Fatalf("unexpected redaction: %v", body)
+	}
+	nested := body["nested"].([]any)[0].(map[string]any)
+	if nested["secret"] != audit.Redacted || nested["keyType"] != "EC" {
+		t.Fatalf("unexpected nested redaction: %v", nested)
+	}
+}
@@ -0,0 +1,133 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/allape/gocrud"
+	"github.com/allape/stepin/auth"
+	"github.com/allape/stepin/model"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const maxCapturedSize = 64 * 1024
+
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(bs []byte) (int, error) {
+	if r.body.Len()+len(bs) <= maxCapturedSize {
+		r.body.Write(bs)
+	}
+	return r.ResponseWriter.Write(bs)
+}
+
+func (r *responseRecorder) WriteString(s string) (int, error) {
+	if r.body.Len()+len(s) <= maxCapturedSize {
+		r.body.WriteString(s)
+	}
+	return r.ResponseWriter.WriteString(s)
+}
+
+type response struct {
+	Code    gocrud.Code `json:"c"`
+	Message string      `json:"m"`
+	Data    struct {
+		ID   gocrud.ID `json:"id"`
+		Cert struct {
+			ID gocrud.ID `json:"id"`
+		} `json:"cert"`
+	} `json:"d"`
+}
+
+// Record
+// Append an entry for the request after it is handled, put it before authorization to record denied requests.
+// For cert actions, the target cert is :id of the path, or the id of the cert in the response.
+func Record(db *gorm.DB, action string) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		parameters := map[string]any{}
+		if len(context.Params) > 0 {
+			params := map[string]string{}
+			for _, param := range context.Params {
+				params[param.Key] = param.Value
+			}
+			parameters["params"] = params
+		}
+		if query := context.Request.URL.Query(); len(query) > 0 {
+			parameters["query"] = Redact(toAnyMap(query))
+		}
+		if context.Request.Body != nil {
+			bs, err := io.ReadAll(io.LimitReader(context.Request.Body, maxCapturedSize+1))
+			if err == nil {
+				context.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(bs), context.Request.Body))
+				var body any
+				if len(bs) <= maxCapturedSize && json.Unmarshal(bs, &body) == nil {
+					parameters["body"] = Redact(body)
+				}
+			}
+		}
+
+		recorder := &responseRecorder{ResponseWriter: context.Writer}
+		context.Writer = recorder
+
+		context.Next()
+
+		entry := &model.AuditLog{
+			SourceIP: context.ClientIP(),
+			Action:   action,
+			Outcome:  model.AuditSuccess,
+		}
+
+		if user := auth.CurrentUser(context); user != nil {
+			entry.ActorID = user.ID
+			entry.Actor = user.Username
+		}
+
+		if bs, err := json.Marshal(parameters); err == nil {
+			entry.Parameters = string(bs)
+		}
+
+		var res response
+		isJSON := strings.HasPrefix(recorder.Header().Get("Content-Type"), "application/json")
+		if isJSON {
+			_ = json.Unmarshal(recorder.body.Bytes(), &res)
+		}
+
+		if recorder.Status() >= http.StatusBadRequest || (isJSON && res.Code != gocrud.RestCoder.OK()) {
+			entry.Outcome = model.AuditFailure
+			entry.Message = res.Message
+			if entry.Message == "" {
+				entry.Message = http.StatusText(recorder.Status())
+			}
+		}
+
+		if strings.HasPrefix(action, "cert.") {
+			if id, err := strconv.ParseUint(context.Param("id"), 10, 64); err == nil {
+				entry.CertID = gocrud.ID(id)
+			} else if res.Data.ID != 0 {
+				entry.CertID = res.Data.ID
+			} else {
+				entry.CertID = res.Data.Cert.ID
+			}
+		}
+
+		err := Append(db, entry)
+		if err != nil {
+			l.Error().Printf("failed to append audit log of %s: %v", action, err)
+		}
+	}
+}
+
+func toAnyMap(values map[string][]string) map[string]any {
+	m := make(map[string]any, len(values))
+	for key, value := range values {
+		m[key] = value
+	}
+	return m
+}
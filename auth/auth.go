@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/allape/gocrud"
+	"github.com/allape/stepin/model"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	TokenPrefix = "stepin_"
+	Realm       = "stepin"
+
+	userKey = "stepin_user"
+)
+
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func CheckPassword(hash, password string) bool {
+	return hash != "" && bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// NewToken
+// Generate a random API token, returns the token and the hash of it to store
+func NewToken() (string, string, error) {
+	bs := make([]byte, 32)
+	_, err := rand.Read(bs)
+	if err != nil {
+		return "", "", err
+	}
+	token := TokenPrefix + base64.RawURLEncoding.EncodeToString(bs)
+	return token, HashToken(token), nil
+}
+
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Authenticate
+// Find the user of the request by HTTP basic auth or bearer API token
+func Authenticate(db *gorm.DB, req *http.Request) (*model.User, error) {
+	if username, password, ok := req.BasicAuth(); ok {
+		var user model.User
+		err := db.Model(&user).Where("username = ?", username).First(&user).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidCredentials
+		} else if err != nil {
+			return nil, err
+		}
+		if !CheckPassword(user.PasswordHash, password) {
+			return nil, ErrInvalidCredentials
+		}
+		return &user, nil
+	}
+
+	authorization := req.Header.Get("Authorization")
+	if token, ok := strings.CutPrefix(authorization, "Bearer "); ok {
+		var t model.Token
+		err := db.Model(&t).Where("hash = ?", HashToken(strings.TrimSpace(token))).First(&t).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidCredentials
+		} else if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		if t.ExpiresAt != nil && now.After(*t.ExpiresAt) {
+			return nil, fmt.Errorf("token %d has expired", t.ID)
+		}
+
+		var user model.User
+		err = db.Model(&user).First(&user, t.UserID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidCredentials
+		} else if err != nil {
+			return nil, err
+		}
+
+		err = db.Model(&t).UpdateColumn("last_used_at", now).Error
+		if err != nil {
+			return nil, err
+		}
+
+		return &user, nil
+	}
+
+	return nil, fmt.Errorf("authentication is required")
+}
+
+// Middleware
+// Reject requests without a valid user, the user is available with CurrentUser
+func Middleware(db *gorm.DB) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		user, err := Authenticate(db, context.Request)
+		if err != nil {
+			context.Header("WWW-Authenticate", fmt.Sprintf(`Basic realm="%s"`, Realm))
+			abort(context, http.StatusUnauthorized, err)
+			return
+		}
+		context.Set(userKey, user)
+		context.Next()
+	}
+}
+
+func CurrentUser(context *gin.Context) *model.User {
+	user, _ := context.Get(userKey)
+	u, _ := user.(*model.User)
+	return u
+}
+
+// Require
+// Reject requests if the current user does not have the role
+func Require(role model.Role) gin.HandlerFunc {
+	return RequireFunc(func(*gin.Context) (model.Role, error) {
+		return role, nil
+	})
+}
+
+// RequireFunc
+// Same as Require, but the role depends on the request, e.g. the profile of the cert
+func RequireFunc(roleOf func(context *gin.Context) (model.Role, error)) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		user := CurrentUser(context)
+		if user == nil {
+			abort(context, http.StatusUnauthorized, fmt.Errorf("authentication is required"))
+			return
+		}
+
+		role, err := roleOf(context)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.NotFound(), err)
+			return
+		} else if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+			return
+		}
+
+		if !user.Role.Includes(role) {
+			abort(context, http.StatusForbidden, fmt.Errorf("%s is required", role))
+			return
+		}
+
+		context.Next()
+	}
+}
+
+func abort(context *gin.Context, status int, err error) {
+	context.AbortWithStatusJSON(status, gocrud.R[any]{
+		Code:    gocrud.RestCoder.FromStatus(status),
+		Message: err.Error(),
+	})
+}
@@ -0,0 +1,96 @@
+package auth_test
+
+import (
+	"github.com/allape/stepin/auth"
+	"github.com/allape/stepin/model"
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMiddleware(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "auth.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.User{}, &model.Token{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := auth.HashPassword("viewer123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	viewer := model.User{Username: "viewer", PasswordHash: hash, Role: model.RoleViewer}
+	issuer := model.User{Username: "issuer", Role: model.RoleIssuer}
+	err = db.Create([]*model.User{&viewer, &issuer}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, tokenHash, err := auth.NewToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, expiredHash, err := auth.NewToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	yesterday := time.Now().Add(-24 * time.Hour)
+	err = db.Create([]*model.Token{
+		{UserID: issuer.ID, Hash: tokenHash},
+		{UserID: issuer.ID, Hash: expiredHash, ExpiresAt: &yesterday},
+	}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	group := engine.Group("api", auth.Middleware(db))
+	ok := func(context *gin.Context) {
+		context.String(http.StatusOK, auth.CurrentUser(context).Username)
+	}
+	group.GET("view", ok)
+	group.GET("issue", auth.Require(model.RoleIssuer), ok)
+	group.GET("admin", auth.Require(model.RoleCAAdmin), ok)
+
+	cases := []struct {
+		path   string
+		auth   func(req *http.Request)
+		status int
+	}{
+		{"/api/view", func(req *http.Request) {}, http.StatusUnauthorized},
+		{"/api/view", func(req *http.Request) { req.SetBasicAuth("viewer", "viewer123") }, http.StatusOK},
+		{"/api/view", func(req *http.Request) { req.SetBasicAuth("viewer", "wrong") }, http.StatusUnauthorized},
+		{"/api/view", func(req *http.Request) { req.SetBasicAuth("issuer", "") }, http.StatusUnauthorized}, // token only
+		{"/api/issue", func(req *http.Request) { req.SetBasicAuth("viewer", "viewer123") }, http.StatusForbidden},
+		{"/api/issue", func(req *http.Request) { req.Header.Set("Authorization", "Bearer "+token) }, http.StatusOK},
+		{"/api/issue", func(req *http.Request) { req.Header.Set("Authorization", "Bearer "+expired) }, http.StatusUnauthorized},
+		{"/api/admin", func(req *http.Request) { req.Header.Set("Authorization", "Bearer "+token) }, http.StatusForbidden},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, c.path, nil)
+		c.auth(req)
+		res := httptest.NewRecorder()
+		engine.ServeHTTP(res, req)
+		if res.Code != c.status {
+			t.Errorf("%s %v: expected %d, got %d %s", c.path, req.Header, c.status, res.Code, res.Body.String())
+		}
+	}
+
+	var used model.Token
+	err = db.Model(&used).Where("hash = ?", tokenHash).First(&used).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used.LastUsedAt == nil {
+		t.Error("last used time of the token should be updated")
+	}
+}
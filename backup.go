@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/allape/gocrud"
+	"github.com/allape/stepin/audit"
+	"github.com/allape/stepin/auth"
+	"github.com/allape/stepin/backup"
+	"github.com/allape/stepin/model"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// PassphraseHeader
+// Passphrase of backup archives, in a header to keep it out of URLs and the audit log
+const PassphraseHeader = "X-Backup-Passphrase"
+
+func SetupBackupController(group *gin.RouterGroup, db *gorm.DB) error {
+	group.GET("backup", audit.Record(db, audit.Backup), auth.Require(model.RoleCAAdmin), func(context *gin.Context) {
+		archive, err := backup.Export(db)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		sealed, err := backup.Seal(archive, context.GetHeader(PassphraseHeader))
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+			return
+		}
+
+		dataAttachment(context, sealed, fmt.Sprintf("stepin-%s.backup", archive.CreatedAt.UTC().Format("20060102T150405Z")))
+	})
+
+	group.POST("restore", audit.Record(db, audit.Restore), auth.Require(model.RoleCAAdmin), func(context *gin.Context) {
+		mode := backup.ModeMerge
+		if value := context.Query("mode"); value != "" {
+			mode = backup.Mode(value)
+		}
+
+		dryRun := false
+		if value := context.Query("dryRun"); value != "" {
+			var err error
+			dryRun, err = strconv.ParseBool(value)
+			if err != nil {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), fmt.Errorf("invalid dryRun"))
+				return
+			}
+		}
+
+		data, err := io.ReadAll(http.MaxBytesReader(context.Writer, context.Request.Body, backup.MaxArchiveSize))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.FromStatus(http.StatusRequestEntityTooLarge), fmt.Errorf("archive is larger than %d bytes", tooLarge.Limit))
+				return
+			}
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+			return
+		}
+
+		archive, err := backup.Open(data, context.GetHeader(PassphraseHeader))
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+			return
+		}
+
+		diff, err := backup.Restore(db, archive, mode, dryRun)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+			return
+		}
+
+		if !dryRun {
+			// archives made before statuses existed have none
+			err = RefreshCertStatuses(db)
+			if err != nil {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+				return
+			}
+		}
+
+		context.JSON(http.StatusOK, gocrud.R[*backup.Diff]{
+			Code: gocrud.RestCoder.OK(),
+			Data: diff,
+		})
+	})
+
+	return nil
+}
@@ -0,0 +1,206 @@
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/allape/gogger"
+	"github.com/allape/stepin/model"
+	"golang.org/x/crypto/scrypt"
+	"io"
+	"time"
+)
+
+const (
+	Magic = "STEPIN-BACKUP"
+	// Version of the archives sealed now, 1 has neither CRLs, ACME nor the audit log, which are left as they are when it is restored
+	Version = 2
+
+	MinPassphraseLength = 8
+	// MaxArchiveSize of the archives to restore, in bytes, which is far beyond the certs and the audit log of an internal CA
+	MaxArchiveSize = 256 << 20
+
+	saltSize = 16
+	keySize  = 32
+	// the same as the recommendation of scrypt for interactive logins in 2017
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+var l = gogger.New("backup")
+
+var (
+	ErrInvalidArchive = errors.New("not a stepin backup archive")
+	ErrDecrypt        = errors.New("wrong passphrase or corrupted archive")
+	ErrChecksum       = errors.New("checksum mismatch")
+)
+
+// User
+// model.User with the password hash, which is hidden from JSON
+type User struct {
+	Username     string     `json:"username"`
+	PasswordHash string     `json:"passwordHash"`
+	Role         model.Role `json:"role"`
+}
+
+// CRL
+// model.CRL with the DER, which is hidden from JSON
+type CRL struct {
+	model.CRL
+	DER []byte `json:"der"`
+}
+
+// ACMEAccount
+// model.ACMEAccount with the JWK, which is hidden from JSON
+type ACMEAccount struct {
+	model.ACMEAccount
+	Key string `json:"key"`
+}
+
+// Archive
+// Everything needed to rebuild stepin, Crt and Key of certs are decoded.
+// API tokens and expiry notifications are left out, users issue new tokens after a restoration and missing notifications are sent again.
+type Archive struct {
+	Version            int                       `json:"version"`
+	CreatedAt          time.Time                 `json:"createdAt"`
+	Certs              []model.Cert              `json:"certs"`
+	Templates          []model.Template          `json:"templates"`
+	Users              []User                    `json:"users"`
+	CRLs               []CRL                     `json:"crls"`
+	ACMEAccounts       []ACMEAccount             `json:"acmeAccounts"`
+	ACMEOrders         []model.ACMEOrder         `json:"acmeOrders"`
+	ACMEAuthorizations []model.ACMEAuthorization `json:"acmeAuthorizations"`
+	ACMEChallenges     []model.ACMEChallenge     `json:"acmeChallenges"`
+	AuditLogs          []model.AuditLog          `json:"auditLogs"`
+}
+
+// HasState
+// Whether the archive has CRLs, ACME and the audit log, see Version
+func (a *Archive) HasState() bool {
+	return a.Version >= 2
+}
+
+// Seal
+// Encode the archive into
+//
+//	magic | version | salt | nonce | AES-256-GCM(SHA-256(payload) | payload)
+//
+// where payload is the gzipped JSON of the archive and the key is derived from the passphrase by scrypt.
+func Seal(archive *Archive, passphrase string) ([]byte, error) {
+	if len(passphrase) < MinPassphraseLength {
+		return nil, fmt.Errorf("passphrase should be at least %d characters", MinPassphraseLength)
+	}
+
+	archive.Version = Version
+
+	var payload bytes.Buffer
+	writer := gzip.NewWriter(&payload)
+	err := json.NewEncoder(writer).Encode(archive)
+	if err != nil {
+		return nil, err
+	}
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, len(Magic)+1+saltSize)
+	header = append(header, Magic...)
+	header = append(header, Version)
+	salt := make([]byte, saltSize)
+	_, err = rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+	header = append(header, salt...)
+
+	aead, err := newAEAD(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	checksum := sha256.Sum256(payload.Bytes())
+	plaintext := append(checksum[:], payload.Bytes()...)
+
+	sealed := append(header, nonce...)
+	return aead.Seal(sealed, nonce, plaintext, header), nil
+}
+
+// Open
+// Decrypt the archive sealed by Seal and verify its checksum
+func Open(data []byte, passphrase string) (*Archive, error) {
+	headerSize := len(Magic) + 1 + saltSize
+	if len(data) < headerSize || string(data[:len(Magic)]) != Magic {
+		return nil, ErrInvalidArchive
+	}
+	if version := data[len(Magic)]; version < 1 || version > Version {
+		return nil, fmt.Errorf("unsupported archive version %d", version)
+	}
+	header := data[:headerSize]
+	salt := header[len(Magic)+1:]
+
+	aead, err := newAEAD(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < headerSize+aead.NonceSize() {
+		return nil, ErrInvalidArchive
+	}
+	nonce := data[headerSize : headerSize+aead.NonceSize()]
+
+	plaintext, err := aead.Open(nil, nonce, data[headerSize+aead.NonceSize():], header)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	if len(plaintext) < sha256.Size {
+		return nil, ErrInvalidArchive
+	}
+	payload := plaintext[sha256.Size:]
+	if checksum := sha256.Sum256(payload); !bytes.Equal(checksum[:], plaintext[:sha256.Size]) {
+		return nil, ErrChecksum
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	bs, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	var archive Archive
+	err = json.Unmarshal(bs, &archive)
+	if err != nil {
+		return nil, err
+	}
+	if archive.Version < 1 || archive.Version > Version {
+		return nil, fmt.Errorf("unsupported archive version %d", archive.Version)
+	}
+
+	return &archive, nil
+}
+
+func newAEAD(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, keySize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
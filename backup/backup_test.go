@@ -0,0 +1,356 @@
+package backup_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"github.com/allape/stepin/audit"
+	"github.com/allape/stepin/backup"
+	"github.com/allape/stepin/model"
+	"github.com/allape/stepin/stepin/create"
+	"github.com/allape/stepin/stepin/native"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"path/filepath"
+	"testing"
+)
+
+func openDB(t *testing.T, name string) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), name)), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.AutoMigrate(
+		&model.Cert{}, &model.CRL{}, &model.ExpiryNotification{}, &model.Template{}, &model.User{},
+		&model.ACMEAccount{}, &model.ACMEOrder{}, &model.ACMEAuthorization{}, &model.ACMEChallenge{}, &model.AuditLog{},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func saveCert(t *testing.T, db *gorm.DB, name create.SubjectName, profile create.Profile, crt create.Crt, issuer model.Cert) *model.Cert {
+	cert := &model.Cert{
+		Profile:  profile,
+		Name:     name,
+		Crt:      model.CensoredField(base64.StdEncoding.EncodeToString(crt)),
+		IssuerID: issuer.ID,
+	}
+	err := cert.ParseCrt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cert.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Create(cert).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestRestore(t *testing.T) {
+	_, rootCrt, rootKey, err := native.Backend{}.NewRootCA(create.RootOptions{
+		PrimaryOptions: create.PrimaryOptions{Subject: "root", Password: "123456"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, leafCrt, _, err := native.Backend{}.NewTLS(create.RootlessOptions{
+		PrimaryOptions: create.PrimaryOptions{Subject: "leaf.internal"},
+		RootCaCrt:      rootCrt,
+		RootCaKey:      rootKey,
+		RootPassword:   "123456",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherCrt, _, err := native.Backend{}.NewSelfSigned(create.PrimaryOptions{Subject: "other"}, create.OptionNoPassword{NoPassword: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := openDB(t, "source.db")
+	root := saveCert(t, source, "root", create.RootCA, rootCrt, model.Cert{})
+	saveCert(t, source, "leaf.internal", create.Leaf, leafCrt, *root)
+	err = source.Create(&model.Template{Name: "short", Content: "{}"}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = source.Create(&model.User{Username: "admin", PasswordHash: "hash", Role: model.RoleCAAdmin}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := backup.Export(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := backup.Seal(archive, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = backup.Open(sealed, "wrong passphrase")
+	if !errors.Is(err, backup.ErrDecrypt) {
+		t.Fatalf("expected decryption error, got %v", err)
+	}
+	tampered := append([]byte{}, sealed...)
+	tampered[len(tampered)-1] ^= 1
+	_, err = backup.Open(tampered, "passphrase")
+	if !errors.Is(err, backup.ErrDecrypt) {
+		t.Fatalf("expected decryption error of tampered archive, got %v", err)
+	}
+
+	archive, err = backup.Open(sealed, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the other cert takes ID 1 so IDs of the archive have to be remapped
+	target := openDB(t, "target.db")
+	saveCert(t, target, "other", create.SelfSigned, otherCrt, model.Cert{})
+
+	diff, err := backup.Restore(target, archive, backup.ModeMerge, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	target.Model(&model.Cert{}).Count(&count)
+	if len(diff.Certs.Added) != 2 || count != 1 {
+		t.Fatalf("unexpected dry run: %+v, %d certs", diff.Certs, count)
+	}
+
+	_, err = backup.Restore(target, archive, backup.ModeMerge, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var leaf, restoredRoot model.Cert
+	target.Where("name = ?", "leaf.internal").First(&leaf)
+	target.Where("name = ?", "root").First(&restoredRoot)
+	if leaf.IssuerID == 0 || leaf.IssuerID != restoredRoot.ID {
+		t.Fatalf("issuer of leaf is not remapped: %d, root is %d", leaf.IssuerID, restoredRoot.ID)
+	}
+	err = restoredRoot.Decode()
+	if err != nil || string(restoredRoot.Crt.ToBytes()) != string(rootCrt) {
+		t.Fatalf("crt of root is not restored: %v", err)
+	}
+
+	diff, err = backup.Restore(target, archive, backup.ModeReplace, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target.Model(&model.Cert{}).Count(&count)
+	if len(diff.Certs.Removed) != 1 || len(diff.Certs.Existing) != 2 || count != 2 || len(diff.Users.Existing) != 1 {
+		t.Fatalf("unexpected replacement: %+v, %d certs", diff, count)
+	}
+	var replacedLeaf model.Cert
+	target.Where("name = ?", "leaf.internal").First(&replacedLeaf)
+	if replacedLeaf.IssuerID != root.ID {
+		t.Fatalf("ids of the archive are not kept: %d", replacedLeaf.IssuerID)
+	}
+
+	archive.Certs[0].Fingerprint = "00"
+	_, err = backup.Restore(target, archive, backup.ModeMerge, true)
+	if err == nil {
+		t.Fatal("archive with a wrong fingerprint should fail")
+	}
+}
+
+func TestRestore_EmptyCrt(t *testing.T) {
+	_, rootCrt, _, err := native.Backend{}.NewRootCA(create.RootOptions{
+		PrimaryOptions: create.PrimaryOptions{Subject: "root", Password: "123456"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := openDB(t, "source.db")
+	saveCert(t, source, "root", create.RootCA, rootCrt, model.Cert{})
+	// a row of early versions, which has neither crt nor any parsed column
+	legacy := &model.Cert{Profile: create.Leaf, Name: "legacy.internal"}
+	err = legacy.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = source.Create(legacy).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := backup.Export(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archive.Certs) != 1 || archive.Certs[0].Name != "root" {
+		t.Fatalf("cert without crt should be skipped: %+v", archive.Certs)
+	}
+	sealed, err := backup.Seal(archive, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err = backup.Open(sealed, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := openDB(t, "target.db")
+	diff, err := backup.Restore(target, archive, backup.ModeMerge, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	target.Model(&model.Cert{}).Count(&count)
+	if len(diff.Certs.Added) != 1 || count != 1 {
+		t.Fatalf("unexpected restoration: %+v, %d certs", diff.Certs, count)
+	}
+}
+
+func TestRestore_State(t *testing.T) {
+	_, rootCrt, _, err := native.Backend{}.NewRootCA(create.RootOptions{
+		PrimaryOptions: create.PrimaryOptions{Subject: "root", Password: "123456"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherCrt, _, err := native.Backend{}.NewSelfSigned(create.PrimaryOptions{Subject: "other"}, create.OptionNoPassword{NoPassword: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := openDB(t, "source.db")
+	root := saveCert(t, source, "root", create.RootCA, rootCrt, model.Cert{})
+	for _, record := range []any{
+		&model.CRL{CaID: root.ID, Number: 5, DER: []byte("crl")},
+		&model.ACMEAccount{Key: "{}", Thumbprint: "thumbprint", Status: model.ACMEValid},
+		&model.ACMEOrder{AccountID: 1, Status: model.ACMEValid, CertID: root.ID},
+		&model.ACMEAuthorization{AccountID: 1, OrderID: 1, Status: model.ACMEValid},
+		&model.ACMEChallenge{AuthorizationID: 1, Type: "http-01", Status: model.ACMEValid},
+	} {
+		err = source.Create(record).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, action := range []string{audit.CertCreate, audit.CertRevoke} {
+		err = audit.Append(source, &model.AuditLog{Action: action, Outcome: model.AuditSuccess})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	archive, err := backup.Export(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := backup.Seal(archive, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err = backup.Open(sealed, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !archive.HasState() || len(archive.CRLs) != 1 || string(archive.CRLs[0].DER) != "crl" || archive.ACMEAccounts[0].Key != "{}" {
+		t.Fatalf("state is not archived: %+v", archive)
+	}
+
+	// the other cert and ACME account take ID 1, and the log has an entry of its own
+	target := openDB(t, "target.db")
+	saveCert(t, target, "other", create.SelfSigned, otherCrt, model.Cert{})
+	err = target.Create(&model.ACMEAccount{Key: "{}", Thumbprint: "other"}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = audit.Append(target, &model.AuditLog{Action: audit.Restore, Outcome: model.AuditSuccess})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err := backup.Restore(target, archive, backup.ModeMerge, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.CRLs.Added) != 1 || len(diff.ACMEAccounts.Added) != 1 || len(diff.AuditLogs.Skipped) != 2 {
+		t.Fatalf("unexpected merge: %+v", diff)
+	}
+	var restoredRoot model.Cert
+	target.Where("name = ?", "root").First(&restoredRoot)
+	var crl model.CRL
+	target.Where("ca_id = ?", restoredRoot.ID).First(&crl)
+	if crl.Number != 5 || string(crl.DER) != "crl" {
+		t.Fatalf("crl is not restored: %+v", crl)
+	}
+	var account model.ACMEAccount
+	target.Where("thumbprint = ?", "thumbprint").First(&account)
+	var order model.ACMEOrder
+	target.Where("account_id = ?", account.ID).First(&order)
+	var authorization model.ACMEAuthorization
+	target.Where("order_id = ?", order.ID).First(&authorization)
+	var challenge model.ACMEChallenge
+	target.Where("authorization_id = ?", authorization.ID).First(&challenge)
+	if account.Key != "{}" || order.CertID != restoredRoot.ID || authorization.AccountID != account.ID || challenge.ID == 0 {
+		t.Fatalf("acme is not remapped: %+v %+v %+v %+v", account, order, authorization, challenge)
+	}
+
+	diff, err = backup.Restore(target, archive, backup.ModeReplace, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.CRLs.Added) != 1 || len(diff.ACMEAccounts.Removed) != 1 || len(diff.AuditLogs.Removed) != 1 || len(diff.AuditLogs.Added) != 2 {
+		t.Fatalf("unexpected replacement: %+v", diff)
+	}
+
+	// a newer CRL of the same CA is kept, as CRL numbers must not go back
+	err = target.Model(&model.CRL{}).Where("ca_id = ?", root.ID).UpdateColumn("number", 6).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff, err = backup.Restore(target, archive, backup.ModeReplace, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.CRLs.Skipped) != 1 || len(diff.AuditLogs.Existing) != 2 {
+		t.Fatalf("unexpected second replacement: %+v", diff)
+	}
+	var kept model.CRL
+	target.Where("ca_id = ?", root.ID).First(&kept)
+	if kept.Number != 6 {
+		t.Fatalf("newer crl should be kept: %d", kept.Number)
+	}
+	var count int64
+	target.Model(&model.ACMEAccount{}).Count(&count)
+	if count != 1 {
+		t.Fatalf("acme accounts are not replaced: %d", count)
+	}
+	result, err := audit.Verify(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Valid || result.Count != 2 || result.Head != archive.AuditLogs[1].Hash {
+		t.Fatalf("audit log is not replaced: %+v", result)
+	}
+
+	// continuing the chain
+	err = audit.Append(source, &model.AuditLog{Action: audit.CertDelete, Outcome: model.AuditSuccess})
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err = backup.Export(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff, err = backup.Restore(target, archive, backup.ModeMerge, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err = audit.Verify(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.AuditLogs.Added) != 1 || !result.Valid || result.Count != 3 {
+		t.Fatalf("audit log is not continued: %+v %+v", diff.AuditLogs, result)
+	}
+}
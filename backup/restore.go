@@ -0,0 +1,761 @@
+package backup
+
+import (
+	"fmt"
+	"github.com/allape/gocrud"
+	"github.com/allape/stepin/model"
+	"github.com/allape/stepin/stepin/native"
+	"github.com/allape/stepin/storage"
+	"gorm.io/gorm"
+	"slices"
+	"time"
+)
+
+type Mode string
+
+const (
+	// ModeMerge adds what is missing and keeps the existing records as they are
+	ModeMerge Mode = "merge"
+	// ModeReplace removes the records which are not in the archive and overwrites the others, except users
+	ModeReplace Mode = "replace"
+)
+
+var AllModes = []Mode{ModeMerge, ModeReplace}
+
+// Changes
+// Names of the records a restoration touches
+type Changes struct {
+	Added    []string `json:"added"`
+	Existing []string `json:"existing"` // kept in merge mode, overwritten in replace mode
+	Removed  []string `json:"removed"`  // replace mode only
+	Skipped  []string `json:"skipped"`  // in the archive but not restored, see restoreCRLs and restoreAuditLogs
+}
+
+// Diff
+// What a restoration does, or would do in a dry run.
+// Users are always merged, as replacing them would lock out the one who restores.
+type Diff struct {
+	Mode      Mode      `json:"mode"`
+	DryRun    bool      `json:"dryRun"`
+	CreatedAt time.Time `json:"createdAt"` // of the archive
+	Certs     Changes   `json:"certs"`
+	Templates Changes   `json:"templates"`
+	Users     Changes   `json:"users"`
+
+	CRLs         Changes `json:"crls"`
+	ACMEAccounts Changes `json:"acmeAccounts"` // orders, authorizations and challenges go with their accounts
+	AuditLogs    Changes `json:"auditLogs"`
+}
+
+// Export
+// Collect all certs, templates, users, CRLs, ACME and the audit log into an archive
+func Export(db *gorm.DB) (*Archive, error) {
+	archive := &Archive{
+		Version:   Version,
+		CreatedAt: time.Now(),
+	}
+
+	var certs []model.Cert
+	err := db.Model(&model.Cert{}).Order("id ASC").Find(&certs).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, cert := range certs {
+		err = cert.Decode()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode cert %d: %w", cert.ID, err)
+		}
+		// rows of early versions may have no crt, which can be neither validated nor told apart by fingerprint
+		if cert.Crt == "" {
+			l.Warn().Printf("cert %d has no crt, skipped", cert.ID)
+			continue
+		}
+		archive.Certs = append(archive.Certs, cert)
+	}
+
+	err = db.Model(&model.Template{}).Order("id ASC").Find(&archive.Templates).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var users []model.User
+	err = db.Model(&model.User{}).Order("id ASC").Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		archive.Users = append(archive.Users, User{
+			Username:     user.Username,
+			PasswordHash: user.PasswordHash,
+			Role:         user.Role,
+		})
+	}
+
+	var crls []model.CRL
+	err = db.Model(&model.CRL{}).Order("id ASC").Find(&crls).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, crl := range crls {
+		archive.CRLs = append(archive.CRLs, CRL{CRL: crl, DER: crl.DER})
+	}
+
+	var accounts []model.ACMEAccount
+	err = db.Model(&model.ACMEAccount{}).Order("id ASC").Find(&accounts).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, account := range accounts {
+		archive.ACMEAccounts = append(archive.ACMEAccounts, ACMEAccount{ACMEAccount: account, Key: account.Key})
+	}
+	err = db.Model(&model.ACMEOrder{}).Order("id ASC").Find(&archive.ACMEOrders).Error
+	if err != nil {
+		return nil, err
+	}
+	err = db.Model(&model.ACMEAuthorization{}).Order("id ASC").Find(&archive.ACMEAuthorizations).Error
+	if err != nil {
+		return nil, err
+	}
+	err = db.Model(&model.ACMEChallenge{}).Order("id ASC").Find(&archive.ACMEChallenges).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Model(&model.AuditLog{}).Order("id ASC").Find(&archive.AuditLogs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return archive, nil
+}
+
+// Validate
+// Check if the certs in the archive are intact and nothing is duplicated
+func (a *Archive) Validate() error {
+	fingerprints := make(map[string]bool, len(a.Certs))
+	ids := make(map[gocrud.ID]bool, len(a.Certs))
+	for _, cert := range a.Certs {
+		crt, err := native.ParseCrt(cert.Crt.ToBytes())
+		if err != nil {
+			return fmt.Errorf("cert %d: %w", cert.ID, err)
+		}
+		fingerprint := native.Fingerprint(crt)
+		if cert.Fingerprint != fingerprint {
+			return fmt.Errorf("cert %d: fingerprint mismatch", cert.ID)
+		}
+		if fingerprints[fingerprint] || ids[cert.ID] {
+			return fmt.Errorf("cert %d: duplicated", cert.ID)
+		}
+		fingerprints[fingerprint] = true
+		ids[cert.ID] = true
+	}
+
+	names := make(map[string]bool, len(a.Templates))
+	for _, template := range a.Templates {
+		if template.Name == "" || names[template.Name] {
+			return fmt.Errorf("template %d: empty or duplicated name", template.ID)
+		}
+		names[template.Name] = true
+	}
+
+	usernames := make(map[string]bool, len(a.Users))
+	for _, user := range a.Users {
+		if user.Username == "" || usernames[user.Username] || !slices.Contains(model.AllRoles, user.Role) {
+			return fmt.Errorf("user %s: empty or duplicated username, or invalid role", user.Username)
+		}
+		usernames[user.Username] = true
+	}
+
+	cas := make(map[gocrud.ID]bool, len(a.CRLs))
+	for _, crl := range a.CRLs {
+		if !ids[crl.CaID] || cas[crl.CaID] {
+			return fmt.Errorf("crl %d: unknown or duplicated ca %d", crl.ID, crl.CaID)
+		}
+		cas[crl.CaID] = true
+	}
+
+	accounts := make(map[gocrud.ID]bool, len(a.ACMEAccounts))
+	thumbprints := make(map[string]bool, len(a.ACMEAccounts))
+	for _, account := range a.ACMEAccounts {
+		if account.Thumbprint == "" || thumbprints[account.Thumbprint] || accounts[account.ID] {
+			return fmt.Errorf("acme account %d: empty or duplicated thumbprint, or duplicated", account.ID)
+		}
+		thumbprints[account.Thumbprint] = true
+		accounts[account.ID] = true
+	}
+	orders := make(map[gocrud.ID]bool, len(a.ACMEOrders))
+	for _, order := range a.ACMEOrders {
+		if !accounts[order.AccountID] || (order.CertID != 0 && !ids[order.CertID]) || orders[order.ID] {
+			return fmt.Errorf("acme order %d: unknown account or cert, or duplicated", order.ID)
+		}
+		orders[order.ID] = true
+	}
+	authorizations := make(map[gocrud.ID]bool, len(a.ACMEAuthorizations))
+	for _, authorization := range a.ACMEAuthorizations {
+		if !accounts[authorization.AccountID] || !orders[authorization.OrderID] || authorizations[authorization.ID] {
+			return fmt.Errorf("acme authorization %d: unknown account or order, or duplicated", authorization.ID)
+		}
+		authorizations[authorization.ID] = true
+	}
+	challenges := make(map[gocrud.ID]bool, len(a.ACMEChallenges))
+	for _, challenge := range a.ACMEChallenges {
+		if !authorizations[challenge.AuthorizationID] || challenges[challenge.ID] {
+			return fmt.Errorf("acme challenge %d: unknown authorization, or duplicated", challenge.ID)
+		}
+		challenges[challenge.ID] = true
+	}
+
+	// a broken chain is restored as it is, so that audit.Verify still tells where it is broken
+	hashes := make(map[string]bool, len(a.AuditLogs))
+	for _, entry := range a.AuditLogs {
+		if entry.Hash == "" || hashes[entry.Hash] {
+			return fmt.Errorf("audit log %d: empty or duplicated hash", entry.ID)
+		}
+		hashes[entry.Hash] = true
+	}
+
+	return nil
+}
+
+// Restore
+// Write the archive into the database in one transaction, nothing is written in a dry run
+func Restore(db *gorm.DB, archive *Archive, mode Mode, dryRun bool) (*Diff, error) {
+	if !slices.Contains(AllModes, mode) {
+		return nil, fmt.Errorf("invalid restore mode: %s", mode)
+	}
+
+	err := archive.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &Diff{
+		Mode:      mode,
+		DryRun:    dryRun,
+		CreatedAt: archive.CreatedAt,
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		ids, err := restoreCerts(tx, archive.Certs, mode, dryRun, &diff.Certs)
+		if err != nil {
+			return err
+		}
+		err = restoreTemplates(tx, archive.Templates, mode, dryRun, &diff.Templates)
+		if err != nil {
+			return err
+		}
+		err = restoreUsers(tx, archive.Users, dryRun, &diff.Users)
+		if err != nil {
+			return err
+		}
+
+		if !archive.HasState() {
+			return nil
+		}
+		err = restoreCRLs(tx, archive, ids, mode, dryRun, &diff.CRLs)
+		if err != nil {
+			return err
+		}
+		err = restoreACME(tx, archive, ids, mode, dryRun, &diff.ACMEAccounts)
+		if err != nil {
+			return err
+		}
+		return restoreAuditLogs(tx, archive.AuditLogs, mode, dryRun, &diff.AuditLogs)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return diff, nil
+}
+
+func certLabel(cert model.Cert) string {
+	return fmt.Sprintf("%s (%s)", cert.Name, cert.Fingerprint)
+}
+
+// restoreCerts
+// IDs of the certs in the archive are mapped to the ones in the database, 0 for the ones not added in a dry run
+func restoreCerts(tx *gorm.DB, certs []model.Cert, mode Mode, dryRun bool, changes *Changes) (map[gocrud.ID]gocrud.ID, error) {
+	var existing []model.Cert
+	err := tx.Model(&model.Cert{}).Select("id", "name", "fingerprint").Order("id ASC").Find(&existing).Error
+	if err != nil {
+		return nil, err
+	}
+	existingIDs := make(map[string]gocrud.ID, len(existing))
+	for _, cert := range existing {
+		existingIDs[cert.Fingerprint] = cert.ID
+	}
+
+	ids := make(map[gocrud.ID]gocrud.ID, len(certs))
+	archived := make(map[string]bool, len(certs))
+	for _, cert := range certs {
+		archived[cert.Fingerprint] = true
+		if mode == ModeReplace {
+			ids[cert.ID] = cert.ID
+		}
+		if id, ok := existingIDs[cert.Fingerprint]; ok {
+			if mode == ModeMerge {
+				ids[cert.ID] = id
+			}
+			changes.Existing = append(changes.Existing, certLabel(cert))
+		} else {
+			changes.Added = append(changes.Added, certLabel(cert))
+		}
+	}
+
+	if mode == ModeReplace {
+		for _, cert := range existing {
+			if !archived[cert.Fingerprint] {
+				changes.Removed = append(changes.Removed, certLabel(cert))
+			}
+		}
+		if dryRun {
+			return ids, nil
+		}
+
+		// CRLs and notifications refer to the IDs of certs, the ones of the certs still at the same IDs are kept,
+		// so the numbers of CRLs keep increasing
+		archivedIDs := make(map[gocrud.ID]string, len(certs))
+		for _, cert := range certs {
+			archivedIDs[cert.ID] = cert.Fingerprint
+		}
+		changedIDs := []gocrud.ID{0}
+		for _, cert := range existing {
+			if archivedIDs[cert.ID] != cert.Fingerprint {
+				changedIDs = append(changedIDs, cert.ID)
+			}
+		}
+		err = tx.Unscoped().Where("ca_id IN ?", changedIDs).Delete(&model.CRL{}).Error
+		if err != nil {
+			return nil, err
+		}
+		err = tx.Unscoped().Where("cert_id IN ?", changedIDs).Delete(&model.ExpiryNotification{}).Error
+		if err != nil {
+			return nil, err
+		}
+		err = tx.Unscoped().Where("1 = 1").Delete(&model.Cert{}).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, cert := range certs {
+			err = cert.Encode()
+			if err != nil {
+				return nil, err
+			}
+			err = tx.Create(&cert).Error
+			if err != nil {
+				return nil, err
+			}
+		}
+		return ids, storage.ResetSequences(tx, &model.Cert{})
+	}
+
+	if dryRun {
+		return ids, nil
+	}
+
+	// IDs in the archive are remapped to the ones in the database, by fingerprints
+	var added []gocrud.ID
+	for _, cert := range certs {
+		if _, ok := existingIDs[cert.Fingerprint]; ok {
+			continue
+		}
+		archivedID := cert.ID
+		cert.ID = 0
+		cert.IssuerID = 0
+		cert.SupersedesID = 0
+		err = cert.Encode()
+		if err != nil {
+			return nil, err
+		}
+		err = tx.Create(&cert).Error
+		if err != nil {
+			return nil, err
+		}
+		ids[archivedID] = cert.ID
+		added = append(added, archivedID)
+	}
+	for _, cert := range certs {
+		if !slices.Contains(added, cert.ID) {
+			continue
+		}
+		err = tx.Model(&model.Cert{}).Where("id = ?", ids[cert.ID]).UpdateColumns(map[string]any{
+			"issuer_id":     ids[cert.IssuerID],
+			"supersedes_id": ids[cert.SupersedesID],
+		}).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ids, nil
+}
+
+func restoreTemplates(tx *gorm.DB, templates []model.Template, mode Mode, dryRun bool, changes *Changes) error {
+	var existing []model.Template
+	err := tx.Model(&model.Template{}).Select("id", "name").Find(&existing).Error
+	if err != nil {
+		return err
+	}
+	existingNames := make(map[string]bool, len(existing))
+	for _, template := range existing {
+		existingNames[template.Name] = true
+	}
+
+	archived := make(map[string]bool, len(templates))
+	for _, template := range templates {
+		archived[template.Name] = true
+		if existingNames[template.Name] {
+			changes.Existing = append(changes.Existing, template.Name)
+		} else {
+			changes.Added = append(changes.Added, template.Name)
+		}
+	}
+
+	if mode == ModeReplace {
+		for _, template := range existing {
+			if !archived[template.Name] {
+				changes.Removed = append(changes.Removed, template.Name)
+			}
+		}
+		if dryRun {
+			return nil
+		}
+
+		err = tx.Unscoped().Where("1 = 1").Delete(&model.Template{}).Error
+		if err != nil {
+			return err
+		}
+		for _, template := range templates {
+			err = tx.Create(&template).Error
+			if err != nil {
+				return err
+			}
+		}
+		return storage.ResetSequences(tx, &model.Template{})
+	}
+
+	if dryRun {
+		return nil
+	}
+
+	for _, template := range templates {
+		if existingNames[template.Name] {
+			continue
+		}
+		template.ID = 0
+		err = tx.Create(&template).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func restoreUsers(tx *gorm.DB, users []User, dryRun bool, changes *Changes) error {
+	for _, user := range users {
+		var count int64
+		err := tx.Model(&model.User{}).Where("username = ?", user.Username).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			changes.Existing = append(changes.Existing, user.Username)
+			continue
+		}
+		changes.Added = append(changes.Added, user.Username)
+		if dryRun {
+			continue
+		}
+		err = tx.Create(&model.User{
+			Username:     user.Username,
+			PasswordHash: user.PasswordHash,
+			Role:         user.Role,
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreCRLs
+// CRL numbers must keep increasing, so the CRL of a CA in the archive only overwrites an existing one with a smaller number in replace mode,
+// and is skipped otherwise. The existing ones are kept in merge mode.
+func restoreCRLs(tx *gorm.DB, archive *Archive, ids map[gocrud.ID]gocrud.ID, mode Mode, dryRun bool, changes *Changes) error {
+	cas := make(map[gocrud.ID]model.Cert, len(archive.Certs))
+	for _, cert := range archive.Certs {
+		cas[cert.ID] = cert
+	}
+
+	var existing []model.Cert
+	err := tx.Model(&model.Cert{}).Select("id", "fingerprint").Find(&existing).Error
+	if err != nil {
+		return err
+	}
+	fingerprints := make(map[gocrud.ID]string, len(existing))
+	for _, cert := range existing {
+		fingerprints[cert.ID] = cert.Fingerprint
+	}
+
+	for _, crl := range archive.CRLs {
+		ca := cas[crl.CaID]
+		label := fmt.Sprintf("%s #%d", certLabel(ca), crl.Number)
+		id := ids[crl.CaID]
+
+		// the one of another cert at the same ID in a dry run of replace mode is removed together with the cert
+		var current model.CRL
+		if id != 0 && fingerprints[id] == ca.Fingerprint {
+			err = tx.Model(&model.CRL{}).Where("ca_id = ?", id).Limit(1).Find(&current).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		switch {
+		case current.ID == 0:
+			changes.Added = append(changes.Added, label)
+			if dryRun {
+				continue
+			}
+			record := crl.CRL
+			record.ID = 0
+			record.CaID = id
+			record.DER = crl.DER
+			err = tx.Create(&record).Error
+		case mode == ModeMerge:
+			changes.Existing = append(changes.Existing, label)
+		case crl.Number > current.Number:
+			changes.Existing = append(changes.Existing, label)
+			if dryRun {
+				continue
+			}
+			err = tx.Model(&model.CRL{}).Where("id = ?", current.ID).UpdateColumns(map[string]any{
+				"number":      crl.Number,
+				"this_update": crl.ThisUpdate,
+				"next_update": crl.NextUpdate,
+				"der":         crl.DER,
+			}).Error
+		default:
+			changes.Skipped = append(changes.Skipped, label)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// restoreACME
+// Accounts are matched by thumbprints, the orders, authorizations and challenges of an existing account are kept as they are in merge mode
+func restoreACME(tx *gorm.DB, archive *Archive, ids map[gocrud.ID]gocrud.ID, mode Mode, dryRun bool, changes *Changes) error {
+	var existing []model.ACMEAccount
+	err := tx.Model(&model.ACMEAccount{}).Select("id", "thumbprint").Find(&existing).Error
+	if err != nil {
+		return err
+	}
+	existingIDs := make(map[string]gocrud.ID, len(existing))
+	for _, account := range existing {
+		existingIDs[account.Thumbprint] = account.ID
+	}
+
+	archived := make(map[string]bool, len(archive.ACMEAccounts))
+	for _, account := range archive.ACMEAccounts {
+		archived[account.Thumbprint] = true
+		if _, ok := existingIDs[account.Thumbprint]; ok {
+			changes.Existing = append(changes.Existing, account.Thumbprint)
+		} else {
+			changes.Added = append(changes.Added, account.Thumbprint)
+		}
+	}
+
+	if mode == ModeReplace {
+		for _, account := range existing {
+			if !archived[account.Thumbprint] {
+				changes.Removed = append(changes.Removed, account.Thumbprint)
+			}
+		}
+		if dryRun {
+			return nil
+		}
+
+		models := []any{&model.ACMEChallenge{}, &model.ACMEAuthorization{}, &model.ACMEOrder{}, &model.ACMEAccount{}}
+		for _, m := range models {
+			err = tx.Unscoped().Where("1 = 1").Delete(m).Error
+			if err != nil {
+				return err
+			}
+		}
+		for _, account := range archive.ACMEAccounts {
+			record := account.ACMEAccount
+			record.Key = account.Key
+			err = tx.Create(&record).Error
+			if err != nil {
+				return err
+			}
+		}
+		for _, order := range archive.ACMEOrders {
+			err = tx.Create(&order).Error
+			if err != nil {
+				return err
+			}
+		}
+		for _, authorization := range archive.ACMEAuthorizations {
+			err = tx.Create(&authorization).Error
+			if err != nil {
+				return err
+			}
+		}
+		for _, challenge := range archive.ACMEChallenges {
+			err = tx.Create(&challenge).Error
+			if err != nil {
+				return err
+			}
+		}
+		return storage.ResetSequences(tx, models...)
+	}
+
+	if dryRun {
+		return nil
+	}
+
+	// IDs in the archive are remapped to the ones in the database, only for the added accounts and what belongs to them
+	accountIDs := make(map[gocrud.ID]gocrud.ID, len(archive.ACMEAccounts))
+	for _, account := range archive.ACMEAccounts {
+		if _, ok := existingIDs[account.Thumbprint]; ok {
+			continue
+		}
+		record := account.ACMEAccount
+		record.ID = 0
+		record.Key = account.Key
+		err = tx.Create(&record).Error
+		if err != nil {
+			return err
+		}
+		accountIDs[account.ID] = record.ID
+	}
+	orderIDs := make(map[gocrud.ID]gocrud.ID, len(archive.ACMEOrders))
+	for _, order := range archive.ACMEOrders {
+		accountID, ok := accountIDs[order.AccountID]
+		if !ok {
+			continue
+		}
+		archivedID := order.ID
+		order.ID = 0
+		order.AccountID = accountID
+		order.CertID = ids[order.CertID]
+		err = tx.Create(&order).Error
+		if err != nil {
+			return err
+		}
+		orderIDs[archivedID] = order.ID
+	}
+	authorizationIDs := make(map[gocrud.ID]gocrud.ID, len(archive.ACMEAuthorizations))
+	for _, authorization := range archive.ACMEAuthorizations {
+		orderID, ok := orderIDs[authorization.OrderID]
+		if !ok {
+			continue
+		}
+		archivedID := authorization.ID
+		authorization.ID = 0
+		authorization.AccountID = accountIDs[authorization.AccountID]
+		authorization.OrderID = orderID
+		err = tx.Create(&authorization).Error
+		if err != nil {
+			return err
+		}
+		authorizationIDs[archivedID] = authorization.ID
+	}
+	for _, challenge := range archive.ACMEChallenges {
+		authorizationID, ok := authorizationIDs[challenge.AuthorizationID]
+		if !ok {
+			continue
+		}
+		challenge.ID = 0
+		challenge.AuthorizationID = authorizationID
+		err = tx.Create(&challenge).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func auditLabel(entry model.AuditLog) string {
+	return fmt.Sprintf("#%d %s", entry.ID, entry.Action)
+}
+
+// restoreAuditLogs
+// The audit log is a hash chain, the entries of the archive are appended if the log in the database is the beginning of it,
+// e.g. an empty one. Otherwise, they are skipped in merge mode, and replace the log in the database in replace mode.
+// Entries are restored as they are, so a broken chain is still told by audit.Verify.
+func restoreAuditLogs(tx *gorm.DB, entries []model.AuditLog, mode Mode, dryRun bool, changes *Changes) error {
+	var existing []model.AuditLog
+	err := tx.Model(&model.AuditLog{}).Select("id", "action", "hash").Order("id ASC").Find(&existing).Error
+	if err != nil {
+		return err
+	}
+	existingHashes := make(map[string]bool, len(existing))
+	continued := len(existing) <= len(entries)
+	for i, entry := range existing {
+		existingHashes[entry.Hash] = true
+		if continued && entries[i].Hash != entry.Hash {
+			continued = false
+		}
+	}
+
+	archived := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		archived[entry.Hash] = true
+		switch {
+		case existingHashes[entry.Hash]:
+			changes.Existing = append(changes.Existing, auditLabel(entry))
+		case continued || mode == ModeReplace:
+			changes.Added = append(changes.Added, auditLabel(entry))
+		default:
+			changes.Skipped = append(changes.Skipped, auditLabel(entry))
+		}
+	}
+
+	if continued {
+		if dryRun {
+			return nil
+		}
+		for _, entry := range entries[len(existing):] {
+			entry.ID = 0
+			err = tx.Create(&entry).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if mode == ModeMerge {
+		return nil
+	}
+
+	for _, entry := range existing {
+		if !archived[entry.Hash] {
+			changes.Removed = append(changes.Removed, auditLabel(entry))
+		}
+	}
+	if dryRun {
+		return nil
+	}
+
+	err = tx.Unscoped().Where("1 = 1").Delete(&model.AuditLog{}).Error
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		err = tx.Create(&entry).Error
+		if err != nil {
+			return err
+		}
+	}
+	return storage.ResetSequences(tx, &model.AuditLog{})
+}
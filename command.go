@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"github.com/allape/stepin/env"
+	"github.com/allape/stepin/model"
+	"github.com/allape/stepin/storage"
+	"gorm.io/gorm"
+)
+
+const (
+	CommandRotateFieldKey = "rotate-field-key"
+	CommandMigrateDB      = "migrate-db"
+)
+
+// RunCommand
+// Run a maintenance command instead of the server, e.g. `stepin rotate-field-key`
+func RunCommand(db *gorm.DB, args []string, config *gorm.Config) error {
+	switch args[0] {
+	case CommandRotateFieldKey:
+		count, err := RotateFieldKey(db)
+		if err != nil {
+			return err
+		}
+		l.Info().Printf("%d certs are re-encrypted with field key %q", count, model.CrtKeyring.Current)
+		return nil
+	case CommandMigrateDB:
+		if len(args) < 2 || args[1] == "" {
+			return fmt.Errorf("usage: stepin %s <source dsn>, e.g. stepin %s database/data.db", CommandMigrateDB, CommandMigrateDB)
+		}
+		copied, err := MigrateDB(db, args[1], config)
+		if err != nil {
+			return err
+		}
+		for _, c := range copied {
+			l.Info().Printf("%d rows of %s are copied", c.Rows, c.Table)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown command %s, available: %s, %s", args[0], CommandRotateFieldKey, CommandMigrateDB)
+	}
+}
+
+// MigrateDB
+// Copy everything from the database of the source DSN into the empty db, e.g. from the SQLite file into PostgreSQL.
+// The source is migrated to the current schema first, and both sides should share the same field keys.
+func MigrateDB(db *gorm.DB, source string, config *gorm.Config) ([]storage.Copied, error) {
+	if source == env.DatabaseDSN {
+		return nil, fmt.Errorf("source dsn is the same as the one of stepin")
+	}
+
+	src, err := storage.Open(source, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open source database: %w", err)
+	}
+
+	err = src.AutoMigrate(Models...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to migrate source database: %w", err)
+	}
+
+	return storage.Copy(src, db, Models...)
+}
+
+// RotateFieldKey
+// Re-encrypt the certs encrypted with older field keys with the current one in a transaction,
+// the server keeps reading them with the older keys in the keyring meanwhile.
+func RotateFieldKey(db *gorm.DB) (int, error) {
+	count := 0
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var certs []model.Cert
+		return tx.Model(&model.Cert{}).Select("id", "crt", "key").Order("id ASC").FindInBatches(&certs, 100, func(_ *gorm.DB, _ int) error {
+			for _, cert := range certs {
+				if cert.IsCurrentlyEncoded() {
+					continue
+				}
+				err := cert.Decode()
+				if err != nil {
+					return fmt.Errorf("failed to decode cert %d: %w", cert.ID, err)
+				}
+				err = cert.Encode()
+				if err != nil {
+					return err
+				}
+				err = tx.Model(&model.Cert{}).Where("id = ?", cert.ID).UpdateColumns(map[string]any{
+					"crt": cert.Crt,
+					"key": cert.Key,
+				}).Error
+				if err != nil {
+					return err
+				}
+				count++
+			}
+			return nil
+		}).Error
+	})
+	return count, err
+}
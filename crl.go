@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/allape/gocrud"
+	"github.com/allape/stepin/env"
+	"github.com/allape/stepin/model"
+	"github.com/allape/stepin/stepin/create"
+	"github.com/allape/stepin/stepin/native"
+	"github.com/allape/stepin/stepin/revoke"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const CRLMimeType = "application/pkix-crl"
+
+var crlLocker sync.Mutex
+
+type RevokeCertBody struct {
+	Reason           string          `json:"reason"`
+	ParentCaPassword create.Password `json:"parentCaPassword"`
+}
+
+func CRLURL(caID gocrud.ID) create.URI {
+	return create.URI(fmt.Sprintf("%s/crl/%d", strings.TrimSuffix(env.PublicURL, "/"), caID))
+}
+
+func SetupCRLController(group *gin.RouterGroup, db *gorm.DB) error {
+	group.GET("crl/:caID", func(context *gin.Context) {
+		caID, err := ParamID(context, "caID")
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.NotFound(), err)
+			return
+		}
+
+		var crl model.CRL
+		err = db.Model(&crl).Where("ca_id = ?", caID).First(&crl).Error
+		if err == nil && time.Now().Before(crl.NextUpdate) {
+			context.Data(http.StatusOK, CRLMimeType, crl.DER)
+			return
+		} else if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		var ca model.Cert
+		err = db.Model(&ca).Where("id = ?", caID).First(&ca).Error
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.NotFound(), err)
+			return
+		}
+		if !ca.IsCA() {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.NotFound(), fmt.Errorf("cert %d is not a ca", ca.ID))
+			return
+		}
+
+		err = ca.Decode()
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		regenerated, err := regenerateCRLWithDefaultPassword(db, &ca)
+		if err != nil {
+			if len(crl.DER) > 0 {
+				l.Warn().Printf("failed to regenerate crl of ca %d, serving the stale one: %v", ca.ID, err)
+				context.Data(http.StatusOK, CRLMimeType, crl.DER)
+				return
+			}
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		context.Data(http.StatusOK, CRLMimeType, regenerated.DER)
+	})
+
+	return nil
+}
+
+func regenerateCRLWithDefaultPassword(db *gorm.DB, ca *model.Cert) (*model.CRL, error) {
+	password, err := handleCAPassword(ca, "")
+	if err != nil {
+		return nil, err
+	}
+	return RegenerateCRL(db, ca, password)
+}
+
+// RevokeCert
+// Mark the cert as revoked and regenerate the CRLs of its issuer and the CAs it superseded if the issuer is known
+func RevokeCert(db *gorm.DB, cert *model.Cert, reason revoke.Reason, issuer *model.Cert, issuerPassword create.Password) error {
+	now := time.Now()
+	cert.RevokedAt = &now
+	cert.RevocationReason = reason
+	cert.Status = cert.ComputeStatus(false, now)
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&model.Cert{}).Where("id = ?", cert.ID).Updates(map[string]any{
+			"revoked_at":        cert.RevokedAt,
+			"revocation_reason": cert.RevocationReason,
+			"status":            cert.Status,
+		}).Error
+		if err != nil {
+			return err
+		}
+		if issuer == nil {
+			return nil
+		}
+		_, err = RegenerateCRL(tx, issuer, issuerPassword)
+		if err != nil {
+			return err
+		}
+
+		// certs issued before the issuer was renewed still point to the CRLs of the superseded ones
+		for supersedesID := issuer.SupersedesID; supersedesID != 0; {
+			var superseded model.Cert
+			err = tx.Model(&superseded).First(&superseded, supersedesID).Error
+			if err != nil {
+				return err
+			}
+			supersedesID = superseded.SupersedesID
+			err = superseded.Decode()
+			if err != nil {
+				return err
+			}
+			_, err = RegenerateCRL(tx, &superseded, issuerPassword)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// FindIssuer
+// Find the CA which signed the cert, nil will be returned for self-signed certs or certs from unknown CAs.
+func FindIssuer(db *gorm.DB, crt create.Crt) (*model.Cert, error) {
+	cert, err := native.ParseCrt(crt)
+	if err != nil {
+		return nil, err
+	}
+
+	var cas []model.Cert
+	// renewed CAs share the key with the ones they supersede, the latest one is preferred
+	err = db.Model(&model.Cert{}).Where("profile IN ?", []create.Profile{create.RootCA, create.IntermediateCA}).Order("id DESC").Find(&cas).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range cas {
+		err = cas[i].Decode()
+		if err != nil {
+			return nil, err
+		}
+		ca, err := native.ParseCrt(cas[i].Crt.ToBytes())
+		if err != nil {
+			return nil, err
+		}
+		if !ca.Equal(cert) && revoke.IssuedBy(cert, ca) {
+			return &cas[i], nil
+		}
+	}
+
+	return nil, nil
+}
+
+// RegenerateCRL
+// Sign a new CRL with all revoked certs issued by the decoded CA
+func RegenerateCRL(db *gorm.DB, ca *model.Cert, password create.Password) (*model.CRL, error) {
+	crlLocker.Lock()
+	defer crlLocker.Unlock()
+
+	caCrt, err := native.ParseCrt(ca.Crt.ToBytes())
+	if err != nil {
+		return nil, err
+	}
+
+	var revoked []model.Cert
+	err = db.Model(&model.Cert{}).Where("revoked_at IS NOT NULL").Find(&revoked).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []revoke.Entry
+	for _, cert := range revoked {
+		err = cert.Decode()
+		if err != nil {
+			return nil, err
+		}
+		crt, err := native.ParseCrt(cert.Crt.ToBytes())
+		if err != nil {
+			return nil, err
+		}
+		if crt.Equal(caCrt) || !revoke.IssuedBy(crt, caCrt) {
+			continue
+		}
+		entries = append(entries, revoke.Entry{
+			SerialNumber: crt.SerialNumber,
+			RevokedAt:    *cert.RevokedAt,
+			Reason:       cert.RevocationReason,
+		})
+	}
+
+	var crl model.CRL
+	err = db.Model(&crl).Where("ca_id = ?", ca.ID).First(&crl).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		crl = model.CRL{CaID: ca.ID}
+	} else if err != nil {
+		return nil, err
+	}
+
+	signer, err := CASigner(ca, password)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	crl.Number++
+	crl.ThisUpdate = now
+	crl.NextUpdate = now.Add(time.Duration(env.CRLValidityHours) * time.Hour)
+	crl.DER, err = revoke.NewCRL(
+		ca.Crt.ToBytes(),
+		signer,
+		crl.Number,
+		entries,
+		crl.ThisUpdate,
+		crl.NextUpdate,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Save(&crl).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &crl, nil
+}
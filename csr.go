@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/allape/gocrud"
+	"github.com/allape/stepin/audit"
+	"github.com/allape/stepin/auth"
+	"github.com/allape/stepin/env"
+	"github.com/allape/stepin/model"
+	"github.com/allape/stepin/policy"
+	"github.com/allape/stepin/stepin"
+	"github.com/allape/stepin/stepin/create"
+	"github.com/allape/stepin/stepin/native"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type SignCSRBody struct {
+	CSR              string          `json:"csr"`   // PEM encoded
+	Years            int64           `json:"years"` // calendar years, exclusive with notAfter and duration
+	ParentCaID       uint            `json:"parentCaID"`
+	ParentCaPassword create.Password `json:"parentCaPassword"`
+	TemplateID       gocrud.ID       `json:"templateID"`
+	Set              []create.Set    `json:"set"`
+
+	create.Validity
+}
+
+type SignCSRResult struct {
+	Cert *model.Cert `json:"cert"`
+	Crt  string      `json:"crt"` // PEM encoded, bundled with the issuer
+}
+
+// CSRPolicy
+// Names allowed in uploaded CSRs, from env
+func CSRPolicy() policy.Policy {
+	return policy.Policy{
+		DNSNames:     splitList(env.CSRAllowedDNSNames),
+		IPRanges:     splitList(env.CSRAllowedIPRanges),
+		EmailDomains: splitList(env.CSRAllowedEmailDomains),
+		URIPrefixes:  splitList(env.CSRAllowedURIPrefixes),
+	}
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func SetupCSRController(group *gin.RouterGroup, db *gorm.DB, backend create.Backend) error {
+	csrPolicy := CSRPolicy()
+	err := csrPolicy.Validate()
+	if err != nil {
+		return err
+	}
+
+	group.POST("cert/csr", audit.Record(db, audit.CertSignCSR), auth.Require(model.RoleIssuer), func(context *gin.Context) {
+		var body SignCSRBody
+		err := context.BindJSON(&body)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+			return
+		}
+
+		csr, err := native.ParseCSR(create.CSR(body.CSR))
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+			return
+		}
+		err = csr.CheckSignature()
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), fmt.Errorf("invalid csr signature: %w", err))
+			return
+		}
+
+		names := native.CSRNames(csr)
+		if len(names) == 0 && csr.Subject.CommonName == "" {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), fmt.Errorf("csr has neither common name nor subject alternative names"))
+			return
+		}
+
+		sans := make([]create.SAN, 0, len(names))
+		for _, name := range names {
+			sans = append(sans, create.SAN(name))
+		}
+		err = csrPolicy.Check(create.SubjectName(csr.Subject.CommonName), sans)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.FromStatus(http.StatusForbidden), err)
+			return
+		}
+
+		if body.ParentCaID == 0 {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), fmt.Errorf("parent ca is required to sign a csr"))
+			return
+		}
+
+		var parentCa model.Cert
+		err = db.Model(&parentCa).Scopes(model.Live).First(&parentCa, body.ParentCaID).Error
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.NotFound(), err)
+			return
+		}
+		if !parentCa.IsCA() {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), fmt.Errorf("cert %d is not a ca", parentCa.ID))
+			return
+		}
+
+		err = parentCa.Decode()
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		parentPassword, err := handleCAPassword(&parentCa, body.ParentCaPassword)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+			return
+		}
+
+		notBefore, notAfter, err := ValidityWindow(body.Validity, body.Years, create.Leaf, time.Now())
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+			return
+		}
+
+		templateOptions, disposeTemplate, err := TemplateOptions(db, body.TemplateID, create.Leaf, body.Set)
+		defer func() {
+			_ = disposeTemplate()
+		}()
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+			return
+		}
+
+		cert, crt, err := SignCSR(db, backend, &parentCa, parentPassword, create.CSR(body.CSR), &csrPolicy, notBefore, notAfter, templateOptions...)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, IssuanceErrorCode(err), err)
+			return
+		}
+
+		context.JSON(http.StatusOK, gocrud.R[SignCSRResult]{
+			Code: gocrud.RestCoder.OK(),
+			Data: SignCSRResult{
+				Cert: cert.Strip(),
+				Crt:  string(crt),
+			},
+		})
+	})
+
+	return nil
+}
+
+// SignCSR
+// Sign a leaf certificate for the CSR with the decoded CA and store it without a key,
+// notBefore is now and notAfter is the default validity of leaf if zero, notAfter never goes beyond the one of the CA,
+// options are appended to the default ones, e.g. a template.
+// The issued certificate is checked against namePolicy if not nil, as a template may add names the CSR does not have.
+func SignCSR(
+	db *gorm.DB,
+	backend create.Backend,
+	ca *model.Cert,
+	caPassword create.Password,
+	csr create.CSR,
+	namePolicy *policy.Policy,
+	notBefore, notAfter time.Time,
+	extraOptions ...stepin.CommandOption,
+) (*model.Cert, create.Crt, error) {
+	request, err := native.ParseCSR(csr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	names := native.CSRNames(request)
+	sans := make([]create.SAN, 0, len(names))
+	for _, name := range names {
+		sans = append(sans, create.SAN(name))
+	}
+
+	from := time.Now()
+	if !notBefore.IsZero() {
+		from = notBefore
+	}
+	if notAfter.IsZero() {
+		notAfter = from.Add(DefaultValidity(create.Leaf))
+	}
+	notAfter, err = ClampToIssuer(from, notAfter, ca)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kty, curve, size := native.KeyParams(request.PublicKey)
+	err = ca.Policy.Check(policy.Request{
+		CommonName: create.SubjectName(request.Subject.CommonName),
+		SANs:       sans,
+		Validity:   notAfter.Sub(from),
+		KeyType:    kty,
+		Curve:      curve,
+		Size:       size,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	options := []stepin.CommandOption{
+		commandBinOption(),
+		create.OptionBundle{Bundle: true},
+		create.OptionNotAfter{NotAfter: notAfter},
+	}
+	if !notBefore.IsZero() {
+		options = append(options, create.OptionNotBefore{NotBefore: notBefore})
+	}
+	if env.PublicURL != "" {
+		options = append(options, create.OptionCRLDistributionPoints{
+			CRLDistributionPoints: []create.URI{CRLURL(ca.ID)},
+		}, create.OptionOCSPServer{
+			OCSPServer: []create.URI{OCSPURL(ca.ID)},
+		})
+	}
+
+	options = append(options, CAKMSOptions(ca)...)
+	options = append(options, extraOptions...)
+
+	inspection, crt, err := backend.SignCSR(create.SignOptions{
+		CSR:          csr,
+		RootCaCrt:    ca.Crt.ToBytes(),
+		RootCaKey:    ca.Key.ToBytes(),
+		RootPassword: caPassword,
+	}, options...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = CheckIssued(ca, create.Leaf, crt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if namePolicy != nil {
+		issued, err := native.ParseCrt(crt)
+		if err != nil {
+			return nil, nil, err
+		}
+		request := policy.CertRequest(issued)
+		err = namePolicy.Check(request.CommonName, request.SANs)
+		if err != nil {
+			return nil, nil, &policy.Violation{Reasons: []string{err.Error()}}
+		}
+	}
+
+	name := create.SubjectName(request.Subject.CommonName)
+	if name == "" && len(names) > 0 {
+		name = create.SubjectName(names[0])
+	}
+
+	cert := &model.Cert{
+		Profile:    create.Leaf,
+		Name:       name,
+		SANs:       sans,
+		Crt:        model.CensoredField(base64.StdEncoding.EncodeToString(crt)),
+		Inspection: inspection,
+		IssuerID:   ca.ID,
+	}
+
+	err = SaveCert(db, cert)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cert, crt, nil
+}
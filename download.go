@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"github.com/allape/gocrud"
+	"github.com/allape/stepin/model"
+	"github.com/allape/stepin/stepin/create"
+	"github.com/allape/stepin/stepin/native"
+	"github.com/allape/stepin/stepin/revoke"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"slices"
+)
+
+const maxChainDepth = 10
+
+// DownloadHandler
+// Download the cert with the id in the format of the type.
+// Export password of p12 is the "password" form field, set "legacy" to "true" for clients without AES support.
+func DownloadHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		certType := DownloadType(context.Param("type"))
+		if !slices.Contains(DownloadableTypes, certType) {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), fmt.Errorf("invalid download type"))
+			return
+		}
+
+		exportPassword := create.Password(context.PostForm("password"))
+		if certType == DownloadPKCS12 && exportPassword == "" {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), fmt.Errorf("export password is required for p12"))
+			return
+		}
+
+		id := context.Param("id")
+
+		var cert model.Cert
+		err := db.Model(&cert).Scopes(model.NotDeleted).First(&cert, id).Error
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.NotFound(), err)
+			return
+		}
+
+		err = cert.Decode()
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		if slices.Contains(KeyDownloadTypes, certType) {
+			if cert.Profile == create.RootCA || cert.Profile == create.IntermediateCA {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), fmt.Errorf("root/intermediate ca key is not downloadable"))
+				return
+			}
+			if len(cert.Key.ToBytes()) == 0 {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.NotFound(), fmt.Errorf("key of cert %d is not stored", cert.ID))
+				return
+			}
+		}
+
+		var chain []*x509.Certificate
+		if certType == DownloadPKCS7 || certType == DownloadPKCS12 || certType == DownloadFullChain {
+			chain, err = CertChain(db, &cert)
+			if err != nil {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+				return
+			}
+		}
+
+		var data []byte
+		filename := fmt.Sprintf("%s.%s", cert.Name, certType)
+		switch certType {
+		case DownloadCRT:
+			data = cert.Crt.ToBytes()
+		case DownloadKey:
+			data = cert.Key.ToBytes()
+		case DownloadDER:
+			data, err = native.EncodeDER(cert.Crt.ToBytes())
+		case DownloadPKCS8:
+			data, err = native.EncodePKCS8(cert.Key.ToBytes(), "")
+		case DownloadPKCS7:
+			data, err = native.EncodePKCS7(chain)
+		case DownloadPKCS12:
+			data, err = native.EncodePKCS12(cert.Key.ToBytes(), "", chain, exportPassword, context.PostForm("legacy") == "true")
+		case DownloadFullChain:
+			// the root is already trusted by the clients
+			if len(chain) > 1 && revoke.IssuedBy(chain[len(chain)-1], chain[len(chain)-1]) {
+				chain = chain[:len(chain)-1]
+			}
+			data = native.EncodeCrt(chain...)
+			filename = fmt.Sprintf("%s.fullchain.pem", cert.Name)
+		}
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		dataAttachment(context, data, filename)
+	}
+}
+
+// CertChain
+// The decoded cert followed by its issuers up to the root CA.
+// Issuers not stored in the database are taken from the bundle of the cert.
+func CertChain(db *gorm.DB, cert *model.Cert) ([]*x509.Certificate, error) {
+	bundle, err := native.ParseCrts(cert.Crt.ToBytes())
+	if err != nil {
+		return nil, err
+	}
+
+	chain := []*x509.Certificate{bundle[0]}
+	for len(chain) < maxChainDepth {
+		last := chain[len(chain)-1]
+		if revoke.IssuedBy(last, last) {
+			break
+		}
+
+		issuer, err := FindIssuer(db, native.EncodeCrt(last))
+		if err != nil {
+			return nil, err
+		}
+
+		var issuerCrt *x509.Certificate
+		if issuer != nil {
+			issuerCrt, err = native.ParseCrt(issuer.Crt.ToBytes())
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			for _, crt := range bundle[1:] {
+				if revoke.IssuedBy(last, crt) {
+					issuerCrt = crt
+					break
+				}
+			}
+		}
+
+		if issuerCrt == nil || slices.ContainsFunc(chain, issuerCrt.Equal) {
+			break
+		}
+		chain = append(chain, issuerCrt)
+	}
+
+	return chain, nil
+}
+
+// withParam
+// Set the path param for the handlers shared with a wildcard route
+func withParam(key, value string) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		context.Params = append(context.Params, gin.Param{Key: key, Value: value})
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/allape/gocrud"
+	"github.com/allape/stepin/audit"
+	"github.com/allape/stepin/auth"
+	"github.com/allape/stepin/model"
+	"github.com/allape/stepin/stepin/create"
+	"github.com/allape/stepin/stepin/native"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"strings"
+)
+
+type ImportCertBody struct {
+	Crt        string             `json:"crt"`        // PEM encoded, only the first cert of a bundle is imported
+	PrivateKey string             `json:"privateKey"` // PEM encoded, optional
+	Passphrase create.Password    `json:"passphrase"` // of the encrypted private key
+	Pass       create.Password    `json:"pass"`       // to encrypt the private key of a CA with, the same as creating a CA
+	Name       create.SubjectName `json:"name"`       // defaults to the common name
+}
+
+func SetupCertImportController(group *gin.RouterGroup, db *gorm.DB, backend create.Backend) error {
+	group.POST("cert/import", audit.Record(db, audit.CertImport), auth.Require(model.RoleCAAdmin), func(context *gin.Context) {
+		var body ImportCertBody
+		err := context.BindJSON(&body)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+			return
+		}
+
+		crt, err := native.ParseCrt(create.Crt(body.Crt))
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+			return
+		}
+
+		var existing int64
+		err = db.Model(&model.Cert{}).Where("fingerprint = ?", native.Fingerprint(crt)).Count(&existing).Error
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+		if existing > 0 {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.Conflict(), fmt.Errorf("cert has been imported"))
+			return
+		}
+
+		profile := native.DetectProfile(crt)
+
+		var key create.Key
+		if strings.TrimSpace(body.PrivateKey) != "" {
+			signer, err := native.ParseKey(create.Key(body.PrivateKey), body.Passphrase)
+			if err != nil {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), fmt.Errorf("failed to parse private key: %w", err))
+				return
+			}
+			if !native.KeyMatches(crt, signer) {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), fmt.Errorf("private key does not match the cert"))
+				return
+			}
+
+			// keys of CAs are encrypted the same way as the created ones, the others are not
+			var password create.Password
+			switch profile {
+			case create.RootCA:
+				password, err = handleRootCAPassword(body.Pass)
+			case create.IntermediateCA:
+				password, err = handleIntermediateCAPassword(body.Pass)
+			}
+			if err != nil {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+				return
+			}
+
+			key, err = native.SerializeKey(signer, password)
+			if err != nil {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+				return
+			}
+		}
+
+		encoded := native.EncodeCrt(crt)
+
+		inspection, err := backend.Inspect(encoded, false, commandBinOption())
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		var issuerID gocrud.ID
+		if profile == create.IntermediateCA || profile == create.Leaf {
+			issuer, err := FindIssuer(db, encoded)
+			if err != nil {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+				return
+			}
+			if issuer != nil {
+				issuerID = issuer.ID
+			}
+		}
+
+		names := native.CrtNames(crt)
+		sans := make([]create.SAN, 0, len(names))
+		for _, name := range names {
+			sans = append(sans, create.SAN(name))
+		}
+
+		name := create.SubjectName(strings.TrimSpace(string(body.Name)))
+		if name == "" {
+			name = create.SubjectName(crt.Subject.CommonName)
+		}
+		if name == "" && len(names) > 0 {
+			name = create.SubjectName(names[0])
+		}
+		if name == "" {
+			name = create.SubjectName(crt.Subject.String())
+		}
+
+		cert := &model.Cert{
+			Profile:    profile,
+			Name:       name,
+			SANs:       sans,
+			Crt:        model.CensoredField(base64.StdEncoding.EncodeToString(encoded)),
+			Key:        model.CensoredField(base64.StdEncoding.EncodeToString(key)),
+			Inspection: inspection,
+			IssuerID:   issuerID,
+		}
+
+		err = SaveCert(db, cert)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		if cert.IsCA() {
+			// the certs imported before their CA
+			err = model.BackfillIssuerIDs(db)
+			if err != nil {
+				l.Warn().Printf("failed to link certs to ca %d: %v", cert.ID, err)
+			}
+		}
+
+		context.JSON(http.StatusOK, gocrud.R[*model.Cert]{
+			Code: gocrud.RestCoder.OK(),
+			Data: cert.Strip(),
+		})
+	})
+
+	return nil
+}
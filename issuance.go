@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"github.com/allape/gocrud"
+	"github.com/allape/stepin/audit"
+	"github.com/allape/stepin/auth"
+	"github.com/allape/stepin/model"
+	"github.com/allape/stepin/policy"
+	"github.com/allape/stepin/stepin"
+	"github.com/allape/stepin/stepin/create"
+	"github.com/allape/stepin/stepin/native"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"time"
+)
+
+type SetPolicyBody struct {
+	Policy *policy.Issuance `json:"policy"` // null to remove the policy
+}
+
+func SetupIssuancePolicyController(group *gin.RouterGroup, db *gorm.DB) error {
+	group.POST("cert/:id/policy", audit.Record(db, audit.CertPolicy), auth.Require(model.RoleCAAdmin), func(context *gin.Context) {
+		var body SetPolicyBody
+		err := context.BindJSON(&body)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+			return
+		}
+
+		err = body.Policy.Validate()
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+			return
+		}
+
+		var cert model.Cert
+		err = db.Model(&cert).Scopes(model.NotDeleted).First(&cert, context.Param("id")).Error
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.NotFound(), err)
+			return
+		}
+		if !cert.IsCA() {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), fmt.Errorf("cert %d is not a ca", cert.ID))
+			return
+		}
+
+		cert.Policy = body.Policy
+		update := db.Model(&model.Cert{}).Where("id = ?", cert.ID)
+		if cert.Policy == nil {
+			// the json serializer of gorm does not take nil pointers
+			err = update.UpdateColumn("policy", gorm.Expr("NULL")).Error
+		} else {
+			err = update.Select("policy").Updates(&cert).Error
+		}
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		context.JSON(http.StatusOK, gocrud.R[*model.Cert]{
+			Code: gocrud.RestCoder.OK(),
+			Data: cert.Strip(),
+		})
+	})
+
+	return nil
+}
+
+// IssuanceErrorCode
+// Forbidden for policy violations, internal server error for the others
+func IssuanceErrorCode(err error) gocrud.Code {
+	var violation *policy.Violation
+	if errors.As(err, &violation) {
+		return gocrud.RestCoder.FromStatus(http.StatusForbidden)
+	}
+	return gocrud.RestCoder.InternalServerError()
+}
+
+// DefaultValidity
+// Validity the backends use for the profile when notAfter is absent
+func DefaultValidity(profile create.Profile) time.Duration {
+	if profile == create.RootCA || profile == create.IntermediateCA {
+		return native.DefaultCAValidity
+	}
+	return native.DefaultLeafValidity
+}
+
+// ValidityWindow
+// Resolve the validity window of a cert to create at now, years are calendar years kept for compatibility and exclusive with notAfter and duration,
+// the default validity of the profile counts from notBefore if no end is given
+func ValidityWindow(validity create.Validity, years int64, profile create.Profile, now time.Time) (time.Time, time.Time, error) {
+	if years < 0 {
+		return time.Time{}, time.Time{}, fmt.Errorf("years should not be negative")
+	}
+	if years > 0 {
+		if validity.NotAfter != nil || validity.Duration != "" {
+			return time.Time{}, time.Time{}, fmt.Errorf("years are exclusive with notAfter and duration")
+		}
+		validity.Duration = create.Duration(fmt.Sprintf("P%dY", years))
+	}
+
+	notBefore, notAfter, err := validity.Window(now)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if notAfter.IsZero() {
+		notAfter = notBefore.Add(DefaultValidity(profile))
+		if !notAfter.After(now) {
+			return time.Time{}, time.Time{}, fmt.Errorf("notAfter by default validity %s from notBefore is in the past", DefaultValidity(profile))
+		}
+	}
+
+	return notBefore, notAfter, nil
+}
+
+// ValidityOptions
+// Options of a resolved validity window
+func ValidityOptions(notBefore, notAfter time.Time) []stepin.CommandOption {
+	return []stepin.CommandOption{
+		create.OptionNotBefore{NotBefore: notBefore},
+		create.OptionNotAfter{NotAfter: notAfter},
+	}
+}
+
+// ClampToIssuer
+// Cut notAfter to the one of the issuer, refused if nothing is left after notBefore
+func ClampToIssuer(notBefore, notAfter time.Time, issuer *model.Cert) (time.Time, error) {
+	notAfter = create.ClampNotAfter(notAfter, issuer.NotAfter)
+	if !notAfter.After(notBefore) {
+		return time.Time{}, fmt.Errorf("issuer %d expires at %s, before the notBefore %s", issuer.ID, issuer.NotAfter.Format(time.RFC3339), notBefore.Format(time.RFC3339))
+	}
+	return notAfter, nil
+}
+
+// CASigner
+// Signer of the decoded CA, by the key in its token if any
+func CASigner(ca *model.Cert, password create.Password) (crypto.Signer, error) {
+	return native.CASigner(ca.Key.ToBytes(), password, ca.KMS)
+}
+
+// CAKMSOptions
+// OptionCAKMS of the CA if its key is held in a token, nothing otherwise
+func CAKMSOptions(ca *model.Cert) []stepin.CommandOption {
+	if ca.KMS == "" {
+		return nil
+	}
+	return []stepin.CommandOption{create.OptionCAKMS{CAKMS: ca.KMS}}
+}
+
+// CheckIssued
+// Check the cert generated by the issuer against its policy once more before it is stored,
+// the template and its variables may have changed the names and the validity checked in the request,
+// the common name is only checked for leaf certs the same as the request
+func CheckIssued(issuer *model.Cert, profile create.Profile, crt create.Crt) error {
+	if issuer.Policy == nil {
+		return nil
+	}
+
+	cert, err := native.ParseCrt(crt)
+	if err != nil {
+		return err
+	}
+
+	request := policy.CertRequest(cert)
+	if profile != create.Leaf {
+		request.CommonName = ""
+	}
+
+	return issuer.Policy.Check(request)
+}
+
+// KeyRequest
+// Fill the key of the policy request with the defaults of step-cli for the empty ones
+func KeyRequest(request policy.Request, spec create.KeySpec) policy.Request {
+	spec = spec.WithDefaults()
+	request.KeyType = spec.KeyType
+	request.Curve = spec.Curve
+	request.Size = spec.Size
+	return request
+}
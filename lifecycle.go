@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/allape/gocrud"
+	"github.com/allape/stepin/audit"
+	"github.com/allape/stepin/auth"
+	"github.com/allape/stepin/model"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"time"
+)
+
+// CertStatusRefreshInterval
+// Certs expire as time goes by, statuses are refreshed in this interval besides on changes
+const CertStatusRefreshInterval = time.Hour
+
+// lifecycleColumns
+// Columns loaded to walk through the hierarchy and compute statuses
+var lifecycleColumns = []string{"id", "name", "profile", "issuer_id", "supersedes_id", "not_after", "revoked_at", "status", "archived_at", "deleted_at"}
+
+// RefreshCertStatuses
+// Compute and store the statuses of the certs of ids, or all certs if no id is given
+func RefreshCertStatuses(db *gorm.DB, ids ...gocrud.ID) error {
+	query := db.Model(&model.Cert{}).Select(lifecycleColumns)
+	successors := db.Model(&model.Cert{}).Scopes(model.NotDeleted).Where("supersedes_id <> 0")
+	if len(ids) > 0 {
+		query = query.Where("id IN ?", ids)
+		successors = successors.Where("supersedes_id IN ?", ids)
+	}
+
+	var supersededIDs []gocrud.ID
+	err := successors.Pluck("supersedes_id", &supersededIDs).Error
+	if err != nil {
+		return err
+	}
+	superseded := make(map[gocrud.ID]bool, len(supersededIDs))
+	for _, id := range supersededIDs {
+		superseded[id] = true
+	}
+
+	var certs []model.Cert
+	err = query.Find(&certs).Error
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, cert := range certs {
+		status := cert.ComputeStatus(superseded[cert.ID], now)
+		if status == cert.Status {
+			continue
+		}
+		err = db.Model(&model.Cert{}).Where("id = ?", cert.ID).UpdateColumn("status", status).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetupCertStatusRefresher
+// Refresh the statuses of all certs now, then periodically in the background
+func SetupCertStatusRefresher(ctx context.Context, db *gorm.DB) error {
+	err := RefreshCertStatuses(db)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(CertStatusRefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			err := RefreshCertStatuses(db)
+			if err != nil {
+				l.Warn().Printf("failed to refresh cert statuses: %v", err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// CertDescendants
+// All certs issued by the cert directly or indirectly, deleted ones included, with lifecycleColumns only
+func CertDescendants(db *gorm.DB, id gocrud.ID) ([]model.Cert, error) {
+	var certs []model.Cert
+	err := db.Model(&model.Cert{}).Select(lifecycleColumns).Order("id ASC").Find(&certs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	children := make(map[gocrud.ID][]model.Cert, len(certs))
+	for _, cert := range certs {
+		if cert.IssuerID != cert.ID {
+			children[cert.IssuerID] = append(children[cert.IssuerID], cert)
+		}
+	}
+
+	var descendants []model.Cert
+	visited := map[gocrud.ID]bool{id: true}
+	queue := []gocrud.ID{id}
+	for len(queue) > 0 {
+		for _, child := range children[queue[0]] {
+			if visited[child.ID] {
+				continue
+			}
+			visited[child.ID] = true
+			descendants = append(descendants, child)
+			queue = append(queue, child.ID)
+		}
+		queue = queue[1:]
+	}
+
+	return descendants, nil
+}
+
+// withPredecessors
+// IDs of the certs and the ones they renewed, whose superseded statuses depend on them
+func withPredecessors(certs []model.Cert) []gocrud.ID {
+	ids := make([]gocrud.ID, 0, len(certs)*2)
+	for _, cert := range certs {
+		ids = append(ids, cert.ID)
+		if cert.SupersedesID != 0 {
+			ids = append(ids, cert.SupersedesID)
+		}
+	}
+	return ids
+}
+
+func certIDs(certs []model.Cert) []gocrud.ID {
+	ids := make([]gocrud.ID, len(certs))
+	for i, cert := range certs {
+		ids[i] = cert.ID
+	}
+	return ids
+}
+
+// updateLifecycle
+// Set the column of the certs, refresh their statuses and respond with them
+func updateLifecycle(context *gin.Context, db *gorm.DB, certs []model.Cert, column string, value any) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&model.Cert{}).Where("id IN ?", certIDs(certs)).UpdateColumn(column, value).Error
+		if err != nil {
+			return err
+		}
+		return RefreshCertStatuses(tx, withPredecessors(certs)...)
+	})
+	if err != nil {
+		gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+		return
+	}
+
+	var updated []model.Cert
+	err = db.Model(&model.Cert{}).Omit("crt", "key", "inspection").Where("id IN ?", certIDs(certs)).Order("id ASC").Find(&updated).Error
+	if err != nil {
+		gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+		return
+	}
+
+	context.JSON(http.StatusOK, gocrud.R[[]model.Cert]{
+		Code: gocrud.RestCoder.OK(),
+		Data: updated,
+	})
+}
+
+// removeCert
+// Archive or soft delete the cert of :id by setting the column to now,
+// refused if any descendant is not removed yet, unless `cascade=true` is set to remove them together
+func removeCert(db *gorm.DB, column string) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		cascade := context.Query("cascade") == "true"
+
+		var cert model.Cert
+		err := db.Model(&cert).Select(lifecycleColumns).First(&cert, context.Param("id")).Error
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.NotFound(), err)
+			return
+		}
+		if cert.DeletedAt != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), fmt.Errorf("cert %d has been deleted", cert.ID))
+			return
+		}
+		if column == "archived_at" && cert.ArchivedAt != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), fmt.Errorf("cert %d has been archived", cert.ID))
+			return
+		}
+
+		descendants, err := CertDescendants(db, cert.ID)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		now := time.Now()
+		certs, err := model.CertsToRemove(cert, descendants, column == "archived_at", cascade, now)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.Conflict(), err)
+			return
+		}
+
+		// the same moment for the ones removed together, so they can be restored together
+		updateLifecycle(context, db, certs, column, now.UTC().Truncate(time.Microsecond))
+	}
+}
+
+func SetupCertLifecycleController(group *gin.RouterGroup, db *gorm.DB) error {
+	group.POST("cert/:id/archive", audit.Record(db, audit.CertArchive), auth.RequireFunc(CertRole(db)), removeCert(db, "archived_at"))
+	group.DELETE("cert/:id", audit.Record(db, audit.CertDelete), auth.RequireFunc(CertRole(db)), removeCert(db, "deleted_at"))
+
+	// undo the deletion, or the archiving if not deleted,
+	// `cascade=true` restores the descendants removed together with the cert as well
+	group.POST("cert/:id/restore", audit.Record(db, audit.CertRestore), auth.RequireFunc(CertRole(db)), func(context *gin.Context) {
+		cascade := context.Query("cascade") == "true"
+
+		var cert model.Cert
+		err := db.Model(&cert).Select(lifecycleColumns).First(&cert, context.Param("id")).Error
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.NotFound(), err)
+			return
+		}
+
+		column, removedAt := "deleted_at", cert.DeletedAt
+		if removedAt == nil {
+			column, removedAt = "archived_at", cert.ArchivedAt
+		}
+		if removedAt == nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), fmt.Errorf("cert %d is neither deleted nor archived", cert.ID))
+			return
+		}
+
+		if column == "deleted_at" && cert.IssuerID != 0 && cert.IssuerID != cert.ID {
+			var issuer model.Cert
+			err = db.Model(&issuer).Select("id", "deleted_at").First(&issuer, cert.IssuerID).Error
+			if err == nil && issuer.DeletedAt != nil {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), fmt.Errorf("issuer %d of cert %d is deleted, restore it first", issuer.ID, cert.ID))
+				return
+			}
+		}
+
+		certs := []model.Cert{cert}
+		if cascade {
+			descendants, err := CertDescendants(db, cert.ID)
+			if err != nil {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+				return
+			}
+			for _, descendant := range descendants {
+				at := descendant.DeletedAt
+				if column == "archived_at" {
+					at = descendant.ArchivedAt
+				}
+				if at != nil && at.Equal(*removedAt) {
+					certs = append(certs, descendant)
+				}
+			}
+		}
+
+		updateLifecycle(context, db, certs, column, gorm.Expr("NULL"))
+	})
+
+	return nil
+}
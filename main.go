@@ -1,26 +1,32 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
-	"encoding/json"
 	"fmt"
 	"github.com/allape/gocrud"
 	"github.com/allape/gogger"
 	"github.com/allape/stepin/asset"
+	"github.com/allape/stepin/audit"
+	"github.com/allape/stepin/auth"
 	"github.com/allape/stepin/env"
 	"github.com/allape/stepin/model"
+	"github.com/allape/stepin/policy"
 	"github.com/allape/stepin/stepin"
 	"github.com/allape/stepin/stepin/create"
+	"github.com/allape/stepin/stepin/native"
+	"github.com/allape/stepin/stepin/revoke"
+	"github.com/allape/stepin/storage"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
-	"io"
 	"net/http"
 	"net/url"
 	"os"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
 	"unicode"
@@ -28,6 +34,22 @@ import (
 
 var l = gogger.New("main")
 
+// Models
+// All tables of stepin
+var Models = []any{
+	&model.Cert{},
+	&model.CRL{},
+	&model.ACMEAccount{},
+	&model.ACMEOrder{},
+	&model.ACMEAuthorization{},
+	&model.ACMEChallenge{},
+	&model.ExpiryNotification{},
+	&model.User{},
+	&model.Token{},
+	&model.AuditLog{},
+	&model.Template{},
+}
+
 func main() {
 	err := gogger.InitFromEnv()
 	if err != nil {
@@ -39,68 +61,135 @@ func main() {
 		LogLevel:      logger.Info,
 		Colorful:      true,
 	})
-	db, err := gorm.Open(sqlite.Open(env.DatabaseFilename), &gorm.Config{
+	db, err := storage.Open(env.DatabaseDSN, &gorm.Config{
 		Logger: dl,
 	})
 	if err != nil {
 		l.Error().Fatalf("failed to create database: %v", err)
 	}
 
-	err = db.AutoMigrate(&model.Cert{})
+	err = db.AutoMigrate(Models...)
 	if err != nil {
 		l.Error().Fatalf("failed to auto migrate database: %v", err)
 	}
 
+	if len(os.Args) > 1 {
+		err = RunCommand(db, os.Args[1:], &gorm.Config{Logger: dl})
+		if err != nil {
+			l.Error().Fatalf("failed to run %s: %v", os.Args[1], err)
+		}
+		return
+	}
+
+	backend, err := NewBackend(create.BackendName(env.Backend))
+	if err != nil {
+		l.Error().Fatalf("failed to create backend: %v", err)
+	}
+
 	engine := gin.Default()
 
 	if env.HttpCors {
-		engine.Use(cors.Default())
+		config := cors.DefaultConfig()
+		config.AllowAllOrigins = true
+		config.AddAllowHeaders("Authorization")
+		engine.Use(cors.New(config))
 	}
 
-	apiGroup := engine.Group("api")
+	err = BootstrapAdmin(db)
+	if err != nil {
+		l.Error().Fatalf("failed to bootstrap admin: %v", err)
+	}
 
-	apiGroup.PATCH("recovery", func(context *gin.Context) {
-		file, err := os.Open("./cert.json")
-		if err != nil {
-			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
-			return
-		}
+	apiGroup := engine.Group("api", auth.Middleware(db))
 
-		bs, err := io.ReadAll(file)
-		if err != nil {
-			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
-			return
-		}
+	err = model.BackfillParsedColumns(db)
+	if err != nil {
+		l.Error().Fatalf("failed to backfill parsed columns: %v", err)
+	}
 
-		var certs []model.Cert
-		err = json.Unmarshal(bs, &certs)
-		if err != nil {
-			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
-			return
-		}
+	err = model.BackfillIssuerIDs(db)
+	if err != nil {
+		l.Error().Fatalf("failed to backfill issuer ids: %v", err)
+	}
 
-		for i := 0; i < len(certs); i++ {
-			err = certs[i].Encode()
-			if err != nil {
-				gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
-				return
-			}
-		}
+	err = SetupCertController(apiGroup, db, backend)
+	if err != nil {
+		l.Error().Fatalf("failed to setup cert controller: %v", err)
+	}
 
-		err = db.Save(&certs).Error
-		if err != nil {
-			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
-			return
-		}
+	err = SetupUserController(apiGroup, db)
+	if err != nil {
+		l.Error().Fatalf("failed to setup user controller: %v", err)
+	}
 
-		context.JSON(http.StatusOK, gocrud.R[any]{
-			Code: gocrud.RestCoder.OK(),
-		})
-	})
+	err = SetupAuditController(apiGroup, db)
+	if err != nil {
+		l.Error().Fatalf("failed to setup audit controller: %v", err)
+	}
 
-	err = SetupCertController(apiGroup, db)
+	err = SetupCSRController(apiGroup, db, backend)
 	if err != nil {
-		l.Error().Fatalf("failed to setup cert controller: %v", err)
+		l.Error().Fatalf("failed to setup csr controller: %v", err)
+	}
+
+	err = SetupRenewController(apiGroup, db, backend)
+	if err != nil {
+		l.Error().Fatalf("failed to setup renew controller: %v", err)
+	}
+
+	err = SetupIssuancePolicyController(apiGroup, db)
+	if err != nil {
+		l.Error().Fatalf("failed to setup issuance policy controller: %v", err)
+	}
+
+	err = SetupTemplateController(apiGroup, db)
+	if err != nil {
+		l.Error().Fatalf("failed to setup template controller: %v", err)
+	}
+
+	err = SetupCertImportController(apiGroup, db, backend)
+	if err != nil {
+		l.Error().Fatalf("failed to setup cert import controller: %v", err)
+	}
+
+	err = SetupBackupController(apiGroup, db)
+	if err != nil {
+		l.Error().Fatalf("failed to setup backup controller: %v", err)
+	}
+
+	err = SetupCertTreeController(apiGroup, db)
+	if err != nil {
+		l.Error().Fatalf("failed to setup cert tree controller: %v", err)
+	}
+
+	err = SetupCertLifecycleController(apiGroup, db)
+	if err != nil {
+		l.Error().Fatalf("failed to setup cert lifecycle controller: %v", err)
+	}
+
+	err = SetupCertStatusRefresher(context.Background(), db)
+	if err != nil {
+		l.Error().Fatalf("failed to setup cert status refresher: %v", err)
+	}
+
+	err = SetupExpiryNotifier(context.Background(), db)
+	if err != nil {
+		l.Error().Fatalf("failed to setup expiry notifier: %v", err)
+	}
+
+	err = SetupCRLController(&engine.RouterGroup, db)
+	if err != nil {
+		l.Error().Fatalf("failed to setup crl controller: %v", err)
+	}
+
+	err = SetupOCSPController(&engine.RouterGroup, db)
+	if err != nil {
+		l.Error().Fatalf("failed to setup ocsp controller: %v", err)
+	}
+
+	err = SetupACMEController(&engine.RouterGroup, db, backend)
+	if err != nil {
+		l.Error().Fatalf("failed to setup acme controller: %v", err)
 	}
 
 	uiGroup := engine.Group("ui")
@@ -128,10 +217,24 @@ func main() {
 type PutCertBody struct {
 	Name             create.SubjectName `json:"name"`
 	Pass             create.Password    `json:"pass"`
-	Years            int64              `json:"years"`
+	Years            int64              `json:"years"` // calendar years, exclusive with notAfter and duration
 	KeyType          create.KeyType     `json:"keyType"`
+	Curve            create.Curve       `json:"curve"` // for EC and OKP keys, e.g. P-384
+	Size             create.BitSize     `json:"size"`  // for RSA keys, e.g. 4096
 	ParentCaID       uint               `json:"parentCaID"`
 	ParentCaPassword create.Password    `json:"parentCaPassword"`
+	TemplateID       gocrud.ID          `json:"templateID"`
+	Set              []create.Set       `json:"set"` // variables of the template, the same as `--set`
+
+	// for root-ca and intermediate-ca only
+	NameConstraints create.NameConstraints `json:"nameConstraints"`
+	MaxPathLen      *int                   `json:"maxPathLen"` // -1 for unlimited, defaults to the one of the template
+	KMS             create.URI             `json:"kms"`        // URI of an existing key in a PKCS#11 token to use instead of a new one, see create.ValidateKMSURI
+
+	SANs create.SubjectAlternativeNames `json:"sans"`
+
+	// notBefore, notAfter and duration, never beyond the notAfter of the parent ca
+	create.Validity
 }
 
 type DownloadType string
@@ -139,10 +242,71 @@ type DownloadType string
 var (
 	DownloadCRT       DownloadType = "crt"
 	DownloadKey       DownloadType = "key"
-	DownloadableTypes              = []DownloadType{DownloadCRT, DownloadKey}
+	DownloadDER       DownloadType = "der"
+	DownloadPKCS8     DownloadType = "p8"
+	DownloadPKCS7     DownloadType = "p7b"
+	DownloadPKCS12    DownloadType = "p12"
+	DownloadFullChain DownloadType = "fullchain"
+	DownloadableTypes              = []DownloadType{
+		DownloadCRT,
+		DownloadKey,
+		DownloadDER,
+		DownloadPKCS8,
+		DownloadPKCS7,
+		DownloadPKCS12,
+		DownloadFullChain,
+	}
+	KeyDownloadTypes = []DownloadType{DownloadKey, DownloadPKCS8, DownloadPKCS12}
 )
 
-func SetupCertController(group *gin.RouterGroup, db *gorm.DB) error {
+func NewBackend(name create.BackendName) (create.Backend, error) {
+	switch name {
+	case create.BackendStepCLI:
+		return create.StepCLI{}, nil
+	case create.BackendNative:
+		return native.Backend{}, nil
+	default:
+		return nil, fmt.Errorf("unknown backend: %s", name)
+	}
+}
+
+func commandBinOption() stepin.OptionCommandBin {
+	option := stepin.OptionCommandBin{
+		CommandBin: "step",
+	}
+	if env.Bin != "" {
+		option.CommandBin = env.Bin
+	}
+	return option
+}
+
+// SaveCert
+// Fill columns parsed from the certificate, then encrypt and create the cert, Crt and Key should not be encrypted
+func SaveCert(db *gorm.DB, cert *model.Cert) error {
+	err := cert.ParseCrt()
+	if err != nil {
+		return err
+	}
+
+	cert.Status = cert.ComputeStatus(false, time.Now())
+
+	err = cert.Encode()
+	if err != nil {
+		return err
+	}
+
+	err = db.Model(&model.Cert{}).Create(cert).Error
+	if err != nil {
+		return err
+	}
+
+	if cert.SupersedesID != 0 {
+		return RefreshCertStatuses(db, cert.SupersedesID)
+	}
+	return nil
+}
+
+func SetupCertController(group *gin.RouterGroup, db *gorm.DB, backend create.Backend) error {
 	group = group.Group("cert")
 	err := gocrud.New(group, db, gocrud.Crud[model.Cert]{
 		EnableGetAll:  true,
@@ -151,6 +315,27 @@ func SetupCertController(group *gin.RouterGroup, db *gorm.DB) error {
 		DisableDelete: true,
 		DisableSave:   true,
 		DisableGetOne: true,
+		SearchHandlers: gocrud.SearchHandlers{
+			"profile":        storage.KeywordEqual("profile", nil),
+			"name":           storage.KeywordLike("name", nil),
+			"serialNumber":   storage.KeywordEqual("serial_number", nil),
+			"fingerprint":    storage.KeywordEqual("fingerprint", nil),
+			"expiringInDays": ExpiringInDays("not_after"),
+			"sortByNotAfter": storage.SortBy("not_after"),
+			"status":         storage.KeywordEqual("status", nil),
+			"keyType":        storage.KeywordEqual("key_type", nil),
+		},
+		// deleted certs are listed with `deleted=true` only, and archived ones with `status=archived` only otherwise
+		WillGetAll: func(context *gin.Context, db *gorm.DB) *gorm.DB {
+			if context.Query("deleted") == "true" {
+				return db.Where("deleted_at IS NOT NULL")
+			}
+			db = db.Scopes(model.NotDeleted)
+			if context.Query("status") == "" {
+				db = db.Where("archived_at IS NULL")
+			}
+			return db
+		},
 		DidGetAll: func(record []model.Cert, ctx *gin.Context, repo *gorm.DB) {
 			for i := range record {
 				record[i].Strip()
@@ -161,7 +346,7 @@ func SetupCertController(group *gin.RouterGroup, db *gorm.DB) error {
 		return err
 	}
 
-	group.PUT(":profile", func(context *gin.Context) {
+	group.PUT(":profile", audit.Record(db, audit.CertCreate), auth.RequireFunc(profileRole), func(context *gin.Context) {
 		profile, err := handleCertProfile(create.Profile(context.Param("profile")))
 		if err != nil {
 			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
@@ -179,66 +364,154 @@ func SetupCertController(group *gin.RouterGroup, db *gorm.DB) error {
 
 		if body.Name == "" {
 			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), fmt.Errorf("name is required"))
+			return
 		}
 
-		commandBinOption := stepin.OptionCommandBin{
-			CommandBin: "step",
+		body.SANs = body.SANs.Trim()
+		err = body.SANs.Validate()
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+			return
 		}
-		commandBin := env.Bin
-		if commandBin != "" {
-			commandBinOption.CommandBin = commandBin
+		if profile == create.Leaf || profile == create.SelfSigned {
+			body.SANs = body.SANs.WithSubject(body.Name)
 		}
+		sans := body.SANs.ToSANs()
 
 		options := []stepin.CommandOption{
-			commandBinOption,
+			commandBinOption(),
 		}
 
-		if body.KeyType != "" {
-			if slices.Contains(create.AllKeyTypes, body.KeyType) {
-				options = append(options, create.OptionKeyType{
-					KTY: body.KeyType,
-				})
-			} else {
-				gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), fmt.Errorf("invalid key type"))
+		keySpec := create.KeySpec{
+			KeyType: body.KeyType,
+			Curve:   body.Curve,
+			Size:    body.Size,
+		}
+		if body.KMS != "" {
+			if profile != create.RootCA && profile != create.IntermediateCA {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), fmt.Errorf("kms is for ca only"))
+				return
+			}
+			if keySpec != (create.KeySpec{}) {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), fmt.Errorf("key type, curve and size are the ones of the key in kms"))
+				return
+			}
+			signer, err := native.KMSSigner(body.KMS)
+			if err != nil {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
 				return
 			}
+			keySpec.KeyType, keySpec.Curve, keySpec.Size = native.KeyParams(signer.Public())
+			options = append(options, create.OptionKMS{KMS: body.KMS})
+		} else {
+			err = keySpec.Validate()
+			if err != nil {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+				return
+			}
+			options = append(options, keySpec.Options()...)
+		}
+
+		if len(sans) > 0 {
+			options = append(options, create.OptionSAN{
+				SAN: sans,
+			})
+		}
+
+		if env.PublicURL != "" && body.ParentCaID != 0 && (profile == create.IntermediateCA || profile == create.Leaf) {
+			options = append(options, create.OptionCRLDistributionPoints{
+				CRLDistributionPoints: []create.URI{CRLURL(gocrud.ID(body.ParentCaID))},
+			}, create.OptionOCSPServer{
+				OCSPServer: []create.URI{OCSPURL(gocrud.ID(body.ParentCaID))},
+			})
 		}
 
-		if body.Years > 0 {
-			options = append(options, create.OptionNotAfter{
-				NotAfter: time.Now().Add(time.Duration(body.Years*365*24) * time.Hour),
+		if !body.NameConstraints.IsEmpty() || body.MaxPathLen != nil {
+			if profile != create.RootCA && profile != create.IntermediateCA {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), fmt.Errorf("name constraints and max path length are for ca only"))
+				return
+			}
+
+			body.NameConstraints = body.NameConstraints.Normalize()
+			err = body.NameConstraints.Validate()
+			if err != nil {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+				return
+			}
+			options = append(options, create.OptionNameConstraints{
+				NameConstraints: body.NameConstraints,
 			})
+
+			if body.MaxPathLen != nil {
+				if *body.MaxPathLen < -1 {
+					gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), fmt.Errorf("invalid max path length"))
+					return
+				}
+				options = append(options, create.OptionMaxPathLen{
+					MaxPathLen: *body.MaxPathLen,
+				})
+			}
+		}
+
+		notBefore, notAfter, err := ValidityWindow(body.Validity, body.Years, profile, time.Now())
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+			return
+		}
+
+		// checked against the policy of the parent ca
+		issuance := KeyRequest(policy.Request{
+			SANs:     sans,
+			Validity: notAfter.Sub(notBefore),
+		}, keySpec)
+		if profile == create.Leaf {
+			issuance.CommonName = body.Name
+		}
+
+		templateOptions, disposeTemplate, err := TemplateOptions(db, body.TemplateID, profile, body.Set)
+		defer func() {
+			_ = disposeTemplate()
+		}()
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+			return
 		}
+		options = append(options, templateOptions...)
 
 		var (
 			inspection stepin.Inspection
 			crt        create.Crt
 			key        create.Key
+			issuerID   gocrud.ID
 		)
 
 		switch profile {
 		case create.RootCA:
-			body.Pass, err = handleRootCAPassword(body.Pass)
-			if err != nil {
-				gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
-				return
+			if body.KMS == "" {
+				body.Pass, err = handleRootCAPassword(body.Pass)
+				if err != nil {
+					gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+					return
+				}
 			}
 
-			inspection, crt, key, err = create.NewRootCA(create.RootOptions{
+			inspection, crt, key, err = backend.NewRootCA(create.RootOptions{
 				PrimaryOptions: create.PrimaryOptions{
 					Subject:  body.Name,
 					Password: body.Pass,
 				},
-			}, options...)
+			}, append(options, ValidityOptions(notBefore, notAfter)...)...)
 			if err != nil {
 				gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
 				return
 			}
 		case create.IntermediateCA:
-			body.Pass, err = handleIntermediateCAPassword(body.Pass)
-			if err != nil {
-				gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
-				return
+			if body.KMS == "" {
+				body.Pass, err = handleIntermediateCAPassword(body.Pass)
+				if err != nil {
+					gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+					return
+				}
 			}
 			if body.ParentCaID == 0 {
 				gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), fmt.Errorf("parent ca is required for intermediate cert"))
@@ -246,7 +519,7 @@ func SetupCertController(group *gin.RouterGroup, db *gorm.DB) error {
 			}
 
 			var parentCa model.Cert
-			err = db.Model(&parentCa).First(&parentCa, body.ParentCaID).Error
+			err = db.Model(&parentCa).Scopes(model.Live).First(&parentCa, body.ParentCaID).Error
 			if err != nil {
 				gocrud.MakeErrorResponse(context, gocrud.RestCoder.NotFound(), err)
 				return
@@ -258,13 +531,26 @@ func SetupCertController(group *gin.RouterGroup, db *gorm.DB) error {
 				return
 			}
 
-			rootPassword, err := handleRootCAPassword(body.ParentCaPassword)
+			notAfter, err = ClampToIssuer(notBefore, notAfter, &parentCa)
+			if err != nil {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+				return
+			}
+			issuance.Validity = notAfter.Sub(notBefore)
+
+			err = parentCa.Policy.Check(issuance)
+			if err != nil {
+				gocrud.MakeErrorResponse(context, IssuanceErrorCode(err), err)
+				return
+			}
+
+			rootPassword, err := handleCAPassword(&parentCa, body.ParentCaPassword)
 			if err != nil {
 				gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
 				return
 			}
 
-			inspection, crt, key, err = create.NewIntermediateCA(create.RootlessOptions{
+			inspection, crt, key, err = backend.NewIntermediateCA(create.RootlessOptions{
 				PrimaryOptions: create.PrimaryOptions{
 					Subject:  body.Name,
 					Password: body.Pass,
@@ -272,11 +558,19 @@ func SetupCertController(group *gin.RouterGroup, db *gorm.DB) error {
 				RootCaCrt:    parentCa.Crt.ToBytes(),
 				RootCaKey:    parentCa.Key.ToBytes(),
 				RootPassword: rootPassword,
-			}, options...)
+			}, append(append(options, ValidityOptions(notBefore, notAfter)...), CAKMSOptions(&parentCa)...)...)
 			if err != nil {
 				gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
 				return
 			}
+
+			// discarded if the template brought in what the policy does not allow
+			err = CheckIssued(&parentCa, create.IntermediateCA, crt)
+			if err != nil {
+				gocrud.MakeErrorResponse(context, IssuanceErrorCode(err), err)
+				return
+			}
+			issuerID = parentCa.ID
 		case create.Leaf:
 			if body.ParentCaID == 0 {
 				gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), fmt.Errorf("parent ca is required for leaf cert"))
@@ -284,7 +578,7 @@ func SetupCertController(group *gin.RouterGroup, db *gorm.DB) error {
 			}
 
 			var parentCa model.Cert
-			err = db.Model(&parentCa).First(&parentCa, body.ParentCaID).Error
+			err = db.Model(&parentCa).Scopes(model.Live).First(&parentCa, body.ParentCaID).Error
 			if err != nil {
 				gocrud.MakeErrorResponse(context, gocrud.RestCoder.NotFound(), err)
 				return
@@ -296,18 +590,26 @@ func SetupCertController(group *gin.RouterGroup, db *gorm.DB) error {
 				return
 			}
 
-			var parentPassword create.Password
-			if parentCa.Profile == create.RootCA {
-				parentPassword, err = handleRootCAPassword(body.ParentCaPassword)
-			} else {
-				parentPassword, err = handleIntermediateCAPassword(body.ParentCaPassword)
+			notAfter, err = ClampToIssuer(notBefore, notAfter, &parentCa)
+			if err != nil {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+				return
+			}
+			issuance.Validity = notAfter.Sub(notBefore)
+
+			err = parentCa.Policy.Check(issuance)
+			if err != nil {
+				gocrud.MakeErrorResponse(context, IssuanceErrorCode(err), err)
+				return
 			}
+
+			parentPassword, err := handleCAPassword(&parentCa, body.ParentCaPassword)
 			if err != nil {
 				gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
 				return
 			}
 
-			inspection, crt, key, err = create.NewTLS(create.RootlessOptions{
+			inspection, crt, key, err = backend.NewTLS(create.RootlessOptions{
 				PrimaryOptions: create.PrimaryOptions{
 					Subject: body.Name,
 					// no password on leaf
@@ -316,28 +618,45 @@ func SetupCertController(group *gin.RouterGroup, db *gorm.DB) error {
 				RootCaCrt:    parentCa.Crt.ToBytes(),
 				RootCaKey:    parentCa.Key.ToBytes(),
 				RootPassword: parentPassword,
-			}, options...)
+			}, append(append(options, ValidityOptions(notBefore, notAfter)...), CAKMSOptions(&parentCa)...)...)
 			if err != nil {
 				gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
 				return
 			}
+
+			// discarded if the template brought in what the policy does not allow
+			err = CheckIssued(&parentCa, create.Leaf, crt)
+			if err != nil {
+				gocrud.MakeErrorResponse(context, IssuanceErrorCode(err), err)
+				return
+			}
+			issuerID = parentCa.ID
+		case create.SelfSigned:
+			inspection, crt, key, err = backend.NewSelfSigned(create.PrimaryOptions{
+				Subject: body.Name,
+				// no password on self-signed, the same as leaf
+			}, append(options, append(ValidityOptions(notBefore, notAfter), create.OptionNoPassword{NoPassword: true})...)...)
+			if err != nil {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+				return
+			}
+		default:
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), fmt.Errorf("unsupported certificate profile %s", profile))
+			return
 		}
 
 		cert := &model.Cert{
 			Profile:    profile,
 			Name:       body.Name,
+			SANs:       sans,
 			Crt:        model.CensoredField(base64.StdEncoding.EncodeToString(crt)),
 			Key:        model.CensoredField(base64.StdEncoding.EncodeToString(key)),
+			KMS:        body.KMS,
 			Inspection: inspection,
+			IssuerID:   issuerID,
 		}
 
-		err = cert.Encode()
-		if err != nil {
-			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
-			return
-		}
-
-		err = db.Model(&model.Cert{}).Create(cert).Error
+		err = SaveCert(db, cert)
 		if err != nil {
 			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
 			return
@@ -349,46 +668,130 @@ func SetupCertController(group *gin.RouterGroup, db *gorm.DB) error {
 		})
 	})
 
-	group.GET(":type/:id", func(context *gin.Context) {
-		certType := context.Param("type")
-		if !slices.Contains(DownloadableTypes, DownloadType(certType)) {
-			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), fmt.Errorf("invalid download type"))
+	group.POST(":id/revoke", audit.Record(db, audit.CertRevoke), auth.RequireFunc(CertRole(db)), func(context *gin.Context) {
+		var body RevokeCertBody
+		err := context.BindJSON(&body)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
 			return
 		}
 
-		id := context.Param("id")
+		reason, err := revoke.ParseReason(body.Reason)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+			return
+		}
 
 		var cert model.Cert
-		err := db.Model(&cert).First(&cert, id).Error
+		err = db.Model(&cert).Scopes(model.NotDeleted).First(&cert, context.Param("id")).Error
 		if err != nil {
 			gocrud.MakeErrorResponse(context, gocrud.RestCoder.NotFound(), err)
 			return
 		}
 
+		if cert.RevokedAt != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), fmt.Errorf("cert %d has been revoked", cert.ID))
+			return
+		}
+
 		err = cert.Decode()
 		if err != nil {
 			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
 			return
 		}
 
-		var data []byte
-		switch DownloadType(certType) {
-		case DownloadCRT:
-			data = cert.Crt.ToBytes()
-		case DownloadKey:
-			if cert.Profile == create.RootCA || cert.Profile == create.IntermediateCA {
-				gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), fmt.Errorf("root/intermediate ca key is not downloadable"))
+		issuer, err := FindIssuer(db, cert.Crt.ToBytes())
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		var issuerPassword create.Password
+		if issuer != nil {
+			issuerPassword, err = handleCAPassword(issuer, body.ParentCaPassword)
+			if err != nil {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
 				return
 			}
-			data = cert.Key.ToBytes()
 		}
 
-		dataAttachment(context, data, fmt.Sprintf("%s.%s", cert.Name, certType))
+		err = RevokeCert(db, &cert, reason, issuer, issuerPassword)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		context.JSON(http.StatusOK, gocrud.R[*model.Cert]{
+			Code: gocrud.RestCoder.OK(),
+			Data: cert.Strip(),
+		})
 	})
 
+	download := []gin.HandlerFunc{
+		audit.Record(db, audit.CertDownload),
+		auth.RequireFunc(downloadRole),
+		DownloadHandler(db),
+	}
+	group.GET(":type/:id", download...)
+	// export password of p12 is in the form
+	group.POST(string(DownloadPKCS12)+"/:id", append([]gin.HandlerFunc{withParam("type", string(DownloadPKCS12))}, download...)...)
+
 	return nil
 }
 
+// ExpiringInDays
+// Certs which are still valid but expire within N days
+func ExpiringInDays(name string) gocrud.SearchHandler {
+	return func(db *gorm.DB, values []string, _ url.Values) *gorm.DB {
+		if ok, value := gocrud.ValuableArray(values); ok {
+			days, err := strconv.Atoi(value)
+			if err != nil || days < 0 {
+				return db
+			}
+			now := time.Now()
+			db = db.Where(clause.Expr{
+				SQL:  "? BETWEEN ? AND ?",
+				Vars: []any{clause.Column{Name: name}, now, now.AddDate(0, 0, days)},
+			})
+		}
+		return db
+	}
+}
+
+// profileRole
+// CAs are managed by CA admins
+func profileRole(context *gin.Context) (model.Role, error) {
+	switch create.Profile(context.Param("profile")) {
+	case create.RootCA, create.IntermediateCA:
+		return model.RoleCAAdmin, nil
+	default:
+		return model.RoleIssuer, nil
+	}
+}
+
+// CertRole
+// Role to manage the cert of :id
+func CertRole(db *gorm.DB) func(context *gin.Context) (model.Role, error) {
+	return func(context *gin.Context) (model.Role, error) {
+		var cert model.Cert
+		err := db.Model(&cert).Select("id", "profile").First(&cert, context.Param("id")).Error
+		if err != nil {
+			return "", err
+		}
+		if cert.IsCA() {
+			return model.RoleCAAdmin, nil
+		}
+		return model.RoleIssuer, nil
+	}
+}
+
+func downloadRole(context *gin.Context) (model.Role, error) {
+	if slices.Contains(KeyDownloadTypes, DownloadType(context.Param("type"))) {
+		return model.RoleIssuer, nil
+	}
+	return model.RoleViewer, nil
+}
+
 func handleCertProfile(profile create.Profile) (create.Profile, error) {
 	if profile == "" || !slices.Contains(create.AllProfiles, profile) {
 		return "", fmt.Errorf("invalid certificate profile")
@@ -396,6 +799,17 @@ func handleCertProfile(profile create.Profile) (create.Profile, error) {
 	return profile, nil
 }
 
+func handleCAPassword(ca *model.Cert, password create.Password) (create.Password, error) {
+	if ca.KMS != "" {
+		// the token is unlocked by the pin in the uri
+		return "", nil
+	}
+	if ca.Profile == create.RootCA {
+		return handleRootCAPassword(password)
+	}
+	return handleIntermediateCAPassword(password)
+}
+
 func handleRootCAPassword(password create.Password) (create.Password, error) {
 	if password != "" {
 		return password, nil
@@ -444,3 +858,13 @@ func isASCII(s string) bool {
 	}
 	return true
 }
+
+// ParamID
+// ID in the path parameter of key, gorm takes a string condition as raw SQL, so it must never be passed to it as it is
+func ParamID(context *gin.Context, key string) (gocrud.ID, error) {
+	id, err := strconv.ParseUint(context.Param(key), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %s", key, context.Param(key))
+	}
+	return gocrud.ID(id), nil
+}
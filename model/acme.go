@@ -0,0 +1,70 @@
+package model
+
+import (
+	"github.com/allape/gocrud"
+	"time"
+)
+
+// https://www.rfc-editor.org/rfc/rfc8555#section-7.1.6
+
+type ACMEStatus string
+
+const (
+	ACMEPending     ACMEStatus = "pending"
+	ACMEReady       ACMEStatus = "ready"
+	ACMEProcessing  ACMEStatus = "processing"
+	ACMEValid       ACMEStatus = "valid"
+	ACMEInvalid     ACMEStatus = "invalid"
+	ACMEDeactivated ACMEStatus = "deactivated"
+	ACMEExpired     ACMEStatus = "expired"
+	ACMERevoked     ACMEStatus = "revoked"
+)
+
+type ACMEIdentifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// ACMEAccount
+// Key is the JWK of the account in JSON
+type ACMEAccount struct {
+	gocrud.Base
+	Status     ACMEStatus `json:"status"`
+	Key        string     `json:"-"`
+	Thumbprint string     `json:"thumbprint" gorm:"uniqueIndex;size:64"`
+	Contact    []string   `json:"contact" gorm:"serializer:json"`
+}
+
+// ACMEOrder
+// CertID is the model.Cert issued after finalization
+type ACMEOrder struct {
+	gocrud.Base
+	AccountID   gocrud.ID        `json:"accountID" gorm:"index"`
+	Status      ACMEStatus       `json:"status"`
+	Expires     time.Time        `json:"expires"`
+	Identifiers []ACMEIdentifier `json:"identifiers" gorm:"serializer:json"`
+	NotBefore   *time.Time       `json:"notBefore"`
+	NotAfter    *time.Time       `json:"notAfter"`
+	Error       string           `json:"error"`
+	CertID      gocrud.ID        `json:"certID" gorm:"index"`
+}
+
+type ACMEAuthorization struct {
+	gocrud.Base
+	AccountID  gocrud.ID      `json:"accountID" gorm:"index"`
+	OrderID    gocrud.ID      `json:"orderID" gorm:"index"`
+	Status     ACMEStatus     `json:"status"`
+	Expires    time.Time      `json:"expires"`
+	Identifier ACMEIdentifier `json:"identifier" gorm:"serializer:json"`
+	Wildcard   bool           `json:"wildcard"`
+}
+
+type ACMEChallenge struct {
+	gocrud.Base
+	AuthorizationID gocrud.ID  `json:"authorizationID" gorm:"index"`
+	Type            string     `json:"type"`
+	Status          ACMEStatus `json:"status"`
+	Token           string     `json:"token"`
+	Validated       *time.Time `json:"validated"`
+	Error           string     `json:"error"`
+}
@@ -0,0 +1,30 @@
+package model
+
+import (
+	"github.com/allape/gocrud"
+	"time"
+)
+
+type AuditOutcome string
+
+const (
+	AuditSuccess AuditOutcome = "success"
+	AuditFailure AuditOutcome = "failure"
+)
+
+// AuditLog
+// Append-only, Hash is the SHA-256 of the entry chained with PrevHash, see audit.Hash
+type AuditLog struct {
+	ID         gocrud.ID    `json:"id" gorm:"primaryKey"`
+	CreatedAt  time.Time    `json:"createdAt" gorm:"index"`
+	ActorID    gocrud.ID    `json:"actorID" gorm:"index"` // 0 if the action is not taken by a user, e.g. ACME
+	Actor      string       `json:"actor"`
+	SourceIP   string       `json:"sourceIP"`
+	Action     string       `json:"action" gorm:"index"`
+	CertID     gocrud.ID    `json:"certID" gorm:"index"`
+	Parameters string       `json:"parameters"` // JSON with secrets redacted
+	Outcome    AuditOutcome `json:"outcome"`
+	Message    string       `json:"message"` // error message of failure
+	PrevHash   string       `json:"prevHash"`
+	Hash       string       `json:"hash" gorm:"uniqueIndex;size:64"`
+}
@@ -2,12 +2,16 @@ package model
 
 import (
 	"encoding/base64"
-	censored "github.com/allape/gocensored"
 	"github.com/allape/gocrud"
 	"github.com/allape/gogger"
 	"github.com/allape/stepin/env"
+	"github.com/allape/stepin/policy"
 	"github.com/allape/stepin/stepin"
 	"github.com/allape/stepin/stepin/create"
+	"github.com/allape/stepin/stepin/native"
+	"github.com/allape/stepin/stepin/revoke"
+	"math/big"
+	"time"
 )
 
 var (
@@ -17,26 +21,24 @@ var (
 
 var l = gogger.New("model.item")
 var (
-	CrtCensor *censored.Censor
-	KeyCensor *censored.Censor
+	CrtKeyring *Keyring
+	KeyKeyring *Keyring
 )
 
 func init() {
-	var err error
-	CrtCensor, err = censored.NewDefaultCensor(&censored.Config{
-		TagName:  "crtcensored",
-		Password: append([]byte(env.DatabasePassword), CrtSalt...),
-	})
+	keys, err := ParseFieldKeys(env.DatabaseFieldKeyring)
 	if err != nil {
-		l.Error().Fatalf("failed to create censor: %v", err)
+		l.Error().Fatalf("failed to parse field keyring: %v", err)
 	}
 
-	KeyCensor, err = censored.NewDefaultCensor(&censored.Config{
-		TagName:  "keycensored",
-		Password: append([]byte(env.DatabasePassword), KeySalt...),
-	})
+	CrtKeyring, err = NewKeyring("crtcensored", CrtSalt, env.DatabasePassword, keys)
 	if err != nil {
-		l.Error().Fatalf("failed to create censor: %v", err)
+		l.Error().Fatalf("failed to create keyring: %v", err)
+	}
+
+	KeyKeyring, err = NewKeyring("keycensored", KeySalt, env.DatabasePassword, keys)
+	if err != nil {
+		l.Error().Fatalf("failed to create keyring: %v", err)
 	}
 }
 
@@ -51,18 +53,91 @@ type Cert struct {
 	gocrud.Base
 	Profile    create.Profile     `json:"profile"`
 	Name       create.SubjectName `json:"name"`
+	SANs       []create.SAN       `json:"sans" gorm:"serializer:json"`
 	Crt        CensoredField      `json:"crt" crtcensored:"saltyaes.base64"`
 	Key        CensoredField      `json:"key" keycensored:"saltyaes.base64"`
+	KMS        create.URI         `json:"kms" gorm:"size:1024"` // URI of the key in a PKCS#11 token if the key is held there, Key is empty then
 	Inspection stepin.Inspection  `json:"inspection"`
+
+	// region parsed from Crt
+
+	SerialNumber string         `json:"serialNumber" gorm:"index"` // lower case hex
+	Issuer       string         `json:"issuer"`
+	NotBefore    time.Time      `json:"notBefore"`
+	NotAfter     time.Time      `json:"notAfter" gorm:"index"`
+	KeyAlgorithm string         `json:"keyAlgorithm"` // e.g. EC P-256, RSA 2048, OKP Ed25519
+	KeyType      create.KeyType `json:"keyType"`
+	Curve        create.Curve   `json:"curve"`       // empty for RSA keys
+	KeySize      create.BitSize `json:"keySize"`     // of the curve for EC keys, 0 for OKP keys
+	Fingerprint  string         `json:"fingerprint"` // lower case hex of SHA-256 of DER
+
+	NameConstraints *create.NameConstraints `json:"nameConstraints" gorm:"serializer:json"` // nil if absent
+	MaxPathLen      *int                    `json:"maxPathLen"`                             // nil for non-CA certs, -1 for unlimited
+
+	// endregion parsed from Crt
+
+	IssuerID     gocrud.ID `json:"issuerID" gorm:"index"`     // the CA which signed this one, 0 for root CAs, self-signed certs and certs from unknown CAs
+	SupersedesID gocrud.ID `json:"supersedesID" gorm:"index"` // the cert renewed by this one
+
+	Policy *policy.Issuance `json:"policy" gorm:"serializer:json"` // restrictions on the certs issued by this CA
+
+	RevokedAt        *time.Time    `json:"revokedAt"`
+	RevocationReason revoke.Reason `json:"revocationReason"`
+
+	Status     CertStatus `json:"status" gorm:"index;size:16"` // see ComputeStatus, refreshed on changes and periodically for expiry
+	ArchivedAt *time.Time `json:"archivedAt"`
+}
+
+func SerialNumber(serialNumber *big.Int) string {
+	return serialNumber.Text(16)
+}
+
+// ParseCrt
+// Fill columns from the certificate, Crt should not be encrypted
+func (c *Cert) ParseCrt() error {
+	crt, err := native.ParseCrt(c.Crt.ToBytes())
+	if err != nil {
+		return err
+	}
+	c.SerialNumber = SerialNumber(crt.SerialNumber)
+	c.Issuer = crt.Issuer.String()
+	c.NotBefore = crt.NotBefore
+	c.NotAfter = crt.NotAfter
+	c.KeyAlgorithm = native.KeyAlgorithm(crt.PublicKey)
+	c.KeyType, c.Curve, c.KeySize = native.KeyParams(crt.PublicKey)
+	c.Fingerprint = native.Fingerprint(crt)
+	c.NameConstraints = native.NameConstraints(crt)
+	c.MaxPathLen = native.MaxPathLen(crt)
+	return nil
+}
+
+// ParsedColumns
+// Columns filled by ParseCrt
+var ParsedColumns = []string{
+	"serial_number",
+	"issuer",
+	"not_before",
+	"not_after",
+	"key_algorithm",
+	"key_type",
+	"curve",
+	"key_size",
+	"fingerprint",
+	"name_constraints",
+	"max_path_len",
+}
+
+func (c *Cert) IsCA() bool {
+	return c.Profile == create.RootCA || c.Profile == create.IntermediateCA
 }
 
 func (c *Cert) Encode() error {
-	err := CrtCensor.Encencor(c)
+	err := CrtKeyring.Encencor(c, &c.Crt)
 	if err != nil {
 		return err
 	}
 
-	err = KeyCensor.Encencor(c)
+	err = KeyKeyring.Encencor(c, &c.Key)
 	if err != nil {
 		return err
 	}
@@ -71,12 +146,12 @@ func (c *Cert) Encode() error {
 }
 
 func (c *Cert) Decode() error {
-	err := CrtCensor.Decensor(c)
+	err := CrtKeyring.Decensor(c, &c.Crt)
 	if err != nil {
 		return err
 	}
 
-	err = KeyCensor.Decensor(c)
+	err = KeyKeyring.Decensor(c, &c.Key)
 	if err != nil {
 		return err
 	}
@@ -84,6 +159,12 @@ func (c *Cert) Decode() error {
 	return nil
 }
 
+// IsCurrentlyEncoded
+// Check if both fields of the encoded cert are encrypted with the current keys
+func (c *Cert) IsCurrentlyEncoded() bool {
+	return KeyIDOf(string(c.Crt)) == CrtKeyring.Current && KeyIDOf(string(c.Key)) == KeyKeyring.Current
+}
+
 func (c *Cert) Strip() *Cert {
 
 	c.Crt = ""
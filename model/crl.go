@@ -0,0 +1,17 @@
+package model
+
+import (
+	"github.com/allape/gocrud"
+	"time"
+)
+
+// CRL
+// The latest CRL of a CA, DER encoded
+type CRL struct {
+	gocrud.Base
+	CaID       gocrud.ID `json:"caID" gorm:"uniqueIndex"`
+	Number     int64     `json:"number"`
+	ThisUpdate time.Time `json:"thisUpdate"`
+	NextUpdate time.Time `json:"nextUpdate"`
+	DER        []byte    `json:"-"`
+}
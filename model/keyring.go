@@ -0,0 +1,110 @@
+package model
+
+import (
+	"fmt"
+	censored "github.com/allape/gocensored"
+	"regexp"
+	"strings"
+)
+
+// KeyID
+// ID of a field password in the keyring, empty for the legacy password, whose values are not prefixed
+type KeyID string
+
+const keyIDSeparator = ":" // not a character of base64
+
+var keyIDRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// FieldKey
+// A versioned password to encrypt fields with
+type FieldKey struct {
+	ID       KeyID
+	Password string
+}
+
+// ParseFieldKeys
+// Parse comma separated `id:password` pairs
+func ParseFieldKeys(s string) ([]FieldKey, error) {
+	var keys []FieldKey
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		id, password, ok := strings.Cut(item, keyIDSeparator)
+		if !ok || !keyIDRegexp.MatchString(id) || password == "" {
+			return nil, fmt.Errorf("invalid field key, it should be id:password with an id of letters, digits, _ and -")
+		}
+		keys = append(keys, FieldKey{ID: KeyID(id), Password: password})
+	}
+	return keys, nil
+}
+
+// Keyring
+// Censors of one tag with versioned passwords, the last key encrypts and all keys decrypt.
+// Encrypted values are prefixed with `id:`, values without a prefix belong to the legacy password.
+type Keyring struct {
+	Current KeyID
+	censors map[KeyID]*censored.Censor
+}
+
+func NewKeyring(tagName string, salt []byte, legacyPassword string, keys []FieldKey) (*Keyring, error) {
+	keyring := &Keyring{
+		censors: make(map[KeyID]*censored.Censor, len(keys)+1),
+	}
+
+	all := append([]FieldKey{{Password: legacyPassword}}, keys...)
+	for _, key := range all {
+		if _, ok := keyring.censors[key.ID]; ok {
+			return nil, fmt.Errorf("duplicated field key %s", key.ID)
+		}
+		censor, err := censored.NewDefaultCensor(&censored.Config{
+			TagName:  tagName,
+			Password: append([]byte(key.Password), salt...),
+		})
+		if err != nil {
+			return nil, err
+		}
+		keyring.censors[key.ID] = censor
+		keyring.Current = key.ID
+	}
+
+	return keyring, nil
+}
+
+// KeyIDOf
+// ID of the key which encrypted the value
+func KeyIDOf(value string) KeyID {
+	id, _, ok := strings.Cut(value, keyIDSeparator)
+	if !ok {
+		return ""
+	}
+	return KeyID(id)
+}
+
+// Encencor
+// Encrypt the record with the current key, field is the only field of the record with the tag of this keyring
+func (k *Keyring) Encencor(record any, field *CensoredField) error {
+	err := k.censors[k.Current].Encencor(record)
+	if err != nil {
+		return err
+	}
+	if k.Current != "" {
+		*field = CensoredField(string(k.Current) + keyIDSeparator + string(*field))
+	}
+	return nil
+}
+
+// Decensor
+// Decrypt the record with the key the field was encrypted with
+func (k *Keyring) Decensor(record any, field *CensoredField) error {
+	id := KeyIDOf(string(*field))
+	censor, ok := k.censors[id]
+	if !ok {
+		return fmt.Errorf("field key %s not found in the keyring", id)
+	}
+	if id != "" {
+		*field = (*field)[len(id)+len(keyIDSeparator):]
+	}
+	return censor.Decensor(record)
+}
@@ -0,0 +1,63 @@
+package model
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestKeyring(t *testing.T) {
+	keys, err := ParseFieldKeys("2024:old, 2025:new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ParseFieldKeys("no-password")
+	if err == nil {
+		t.Fatal("key without password should fail")
+	}
+
+	legacy, err := NewKeyring("crtcensored", CrtSalt, "legacy", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	older, err := NewKeyring("crtcensored", CrtSalt, "legacy", keys[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := NewKeyring("crtcensored", CrtSalt, "legacy", keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.Current != "2025" {
+		t.Fatalf("the last key should be the current one, got %s", current.Current)
+	}
+
+	plain := CensoredField(base64.StdEncoding.EncodeToString([]byte("crt")))
+	for _, keyring := range []*Keyring{legacy, older, current} {
+		cert := &Cert{Crt: plain}
+		err = keyring.Encencor(cert, &cert.Crt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if KeyIDOf(string(cert.Crt)) != keyring.Current {
+			t.Fatalf("expected key %s, got %s", keyring.Current, cert.Crt)
+		}
+
+		err = current.Decensor(cert, &cert.Crt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cert.Crt != plain {
+			t.Fatalf("unexpected decrypted value %s", cert.Crt)
+		}
+	}
+
+	cert := &Cert{Crt: plain}
+	err = current.Encencor(cert, &cert.Crt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = older.Decensor(cert, &cert.Crt)
+	if err == nil {
+		t.Fatal("value of a key absent from the keyring should fail")
+	}
+}
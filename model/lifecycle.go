@@ -0,0 +1,83 @@
+package model
+
+import (
+	"fmt"
+	"github.com/allape/gocrud"
+	"gorm.io/gorm"
+	"time"
+)
+
+type CertStatus string
+
+const (
+	StatusActive     CertStatus = "active"
+	StatusExpired    CertStatus = "expired"
+	StatusRevoked    CertStatus = "revoked"
+	StatusSuperseded CertStatus = "superseded" // renewed by another cert which is not deleted
+	StatusArchived   CertStatus = "archived"
+)
+
+var AllStatuses = []CertStatus{StatusActive, StatusExpired, StatusRevoked, StatusSuperseded, StatusArchived}
+
+// ComputeStatus
+// Archived, revoked, superseded and expired in order of precedence, active otherwise.
+// Deletion is not a status, a deleted cert keeps the one it had.
+func (c *Cert) ComputeStatus(superseded bool, now time.Time) CertStatus {
+	switch {
+	case c.ArchivedAt != nil:
+		return StatusArchived
+	case c.RevokedAt != nil:
+		return StatusRevoked
+	case superseded:
+		return StatusSuperseded
+	case !c.NotAfter.After(now):
+		return StatusExpired
+	default:
+		return StatusActive
+	}
+}
+
+// NotDeleted
+// Scope of the certs which are not soft deleted
+func NotDeleted(db *gorm.DB) *gorm.DB {
+	return db.Where("deleted_at IS NULL")
+}
+
+// Live
+// Scope of the certs which are neither deleted nor archived, only live CAs issue certs
+func Live(db *gorm.DB) *gorm.DB {
+	return NotDeleted(db).Where("archived_at IS NULL")
+}
+
+// DescendantsError
+// A cert is not removed alone while it has descendants which are not removed, they would be left under a removed issuer
+type DescendantsError struct {
+	ID          gocrud.ID
+	Descendants int
+	Active      int
+}
+
+func (e *DescendantsError) Error() string {
+	return fmt.Sprintf("cert %d still has %d descendants (%d active), remove them first or set cascade=true", e.ID, e.Descendants, e.Active)
+}
+
+// CertsToRemove
+// The cert and its descendants to archive, or to delete if archive is false, skipping the ones already removed the same way.
+// Without cascade, the cert is only removed if none is left, otherwise the error is a *DescendantsError.
+func CertsToRemove(cert Cert, descendants []Cert, archive, cascade bool, now time.Time) ([]Cert, error) {
+	certs := []Cert{cert}
+	active := 0
+	for _, descendant := range descendants {
+		if descendant.DeletedAt != nil || (archive && descendant.ArchivedAt != nil) {
+			continue
+		}
+		if descendant.Status == StatusActive && descendant.NotAfter.After(now) {
+			active++
+		}
+		certs = append(certs, descendant)
+	}
+	if len(certs) > 1 && !cascade {
+		return nil, &DescendantsError{ID: cert.ID, Descendants: len(certs) - 1, Active: active}
+	}
+	return certs, nil
+}
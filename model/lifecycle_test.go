@@ -0,0 +1,85 @@
+package model
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCert_ComputeStatus(t *testing.T) {
+	now := time.Now()
+	yesterday := now.AddDate(0, 0, -1)
+	tomorrow := now.AddDate(0, 0, 1)
+
+	for _, c := range []struct {
+		cert       Cert
+		superseded bool
+		status     CertStatus
+	}{
+		{Cert{NotAfter: tomorrow}, false, StatusActive},
+		{Cert{NotAfter: now}, false, StatusExpired},
+		{Cert{NotAfter: yesterday}, true, StatusSuperseded},
+		{Cert{NotAfter: tomorrow, RevokedAt: &yesterday}, true, StatusRevoked},
+		{Cert{NotAfter: yesterday, RevokedAt: &yesterday, ArchivedAt: &now}, true, StatusArchived},
+	} {
+		if status := c.cert.ComputeStatus(c.superseded, now); status != c.status {
+			t.Fatalf("expected %s, got %s", c.status, status)
+		}
+	}
+}
+
+func TestCertsToRemove(t *testing.T) {
+	now := time.Now()
+	yesterday := now.AddDate(0, 0, -1)
+	tomorrow := now.AddDate(0, 0, 1)
+
+	ca := Cert{NotAfter: tomorrow, Status: StatusActive}
+	ca.ID = 1
+	expired := Cert{IssuerID: 1, NotAfter: yesterday, Status: StatusExpired}
+	expired.ID = 2
+	revoked := Cert{IssuerID: 1, NotAfter: tomorrow, RevokedAt: &yesterday, Status: StatusRevoked}
+	revoked.ID = 3
+	active := Cert{IssuerID: 1, NotAfter: tomorrow, Status: StatusActive}
+	active.ID = 4
+	deleted := Cert{IssuerID: 1, NotAfter: tomorrow, Status: StatusActive}
+	deleted.ID = 5
+	deleted.DeletedAt = &yesterday
+	archived := Cert{IssuerID: 1, NotAfter: tomorrow, Status: StatusArchived, ArchivedAt: &yesterday}
+	archived.ID = 6
+
+	for _, c := range []struct {
+		descendants []Cert
+		archive     bool
+		cascade     bool
+		ids         []int // nil for a conflict
+		active      int
+	}{
+		{nil, false, false, []int{1}, 0},
+		{[]Cert{deleted}, false, false, []int{1}, 0},
+		{[]Cert{archived}, true, false, []int{1}, 0},
+		{[]Cert{active}, false, false, nil, 1},
+		{[]Cert{expired, revoked}, false, false, nil, 0},
+		{[]Cert{archived}, false, false, nil, 0},
+		{[]Cert{expired, revoked, active, deleted}, true, true, []int{1, 2, 3, 4}, 0},
+	} {
+		certs, err := CertsToRemove(ca, c.descendants, c.archive, c.cascade, now)
+		if c.ids == nil {
+			var descendantsError *DescendantsError
+			if !errors.As(err, &descendantsError) || descendantsError.Active != c.active || certs != nil {
+				t.Fatalf("removing %d alone should conflict with %d active, got %v", len(c.descendants), c.active, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]int, 0, len(certs))
+		for _, cert := range certs {
+			ids = append(ids, int(cert.ID))
+		}
+		if !slices.Equal(ids, c.ids) {
+			t.Fatalf("expected %v to be removed, got %v", c.ids, ids)
+		}
+	}
+}
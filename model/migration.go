@@ -0,0 +1,115 @@
+package model
+
+import (
+	"bytes"
+	"crypto/x509"
+	"github.com/allape/gocrud"
+	"github.com/allape/stepin/stepin/create"
+	"github.com/allape/stepin/stepin/native"
+	"github.com/allape/stepin/stepin/revoke"
+	"gorm.io/gorm"
+)
+
+// BackfillParsedColumns
+// Fill columns parsed from the certificate for the certs created before those columns existed
+func BackfillParsedColumns(db *gorm.DB) error {
+	var certs []Cert
+	err := db.Model(&Cert{}).Where(
+		"fingerprint IS NULL OR fingerprint = '' OR key_type IS NULL OR key_type = '' OR (profile IN ? AND max_path_len IS NULL)",
+		[]create.Profile{create.RootCA, create.IntermediateCA},
+	).Find(&certs).Error
+	if err != nil {
+		return err
+	}
+
+	for _, cert := range certs {
+		err = cert.Decode()
+		if err != nil {
+			return err
+		}
+		err = cert.ParseCrt()
+		if err != nil {
+			l.Warn().Printf("failed to parse cert %d: %v", cert.ID, err)
+			continue
+		}
+		err = db.Model(&Cert{}).Where("id = ?", cert.ID).Select(ParsedColumns).UpdateColumns(&cert).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// BackfillIssuerIDs
+// Link the certs created before issuerID existed to their CAs,
+// by matching the authority key identifier and the issuer of each cert with the subject key identifier and the subject of CAs.
+// issuer_id of those certs is NULL, as AutoMigrate adds the column without a default to the existing rows.
+func BackfillIssuerIDs(db *gorm.DB) error {
+	var certs []Cert
+	err := db.Model(&Cert{}).Where("(issuer_id IS NULL OR issuer_id = 0) AND profile IN ?", []create.Profile{create.IntermediateCA, create.Leaf}).Find(&certs).Error
+	if err != nil {
+		return err
+	}
+	if len(certs) == 0 {
+		return nil
+	}
+
+	var cas []Cert
+	err = db.Model(&Cert{}).Where("profile IN ?", []create.Profile{create.RootCA, create.IntermediateCA}).Order("id ASC").Find(&cas).Error
+	if err != nil {
+		return err
+	}
+
+	caCrts := make([]*x509.Certificate, len(cas))
+	for i := range cas {
+		err = cas[i].Decode()
+		if err != nil {
+			return err
+		}
+		caCrts[i], err = native.ParseCrt(cas[i].Crt.ToBytes())
+		if err != nil {
+			l.Warn().Printf("failed to parse ca %d: %v", cas[i].ID, err)
+		}
+	}
+
+	for _, cert := range certs {
+		err = cert.Decode()
+		if err != nil {
+			return err
+		}
+		crt, err := native.ParseCrt(cert.Crt.ToBytes())
+		if err != nil {
+			l.Warn().Printf("failed to parse cert %d: %v", cert.ID, err)
+			continue
+		}
+
+		var issuerID gocrud.ID
+		for i, ca := range caCrts {
+			if ca == nil || cas[i].ID == cert.ID || !bytes.Equal(ca.RawSubject, crt.RawIssuer) {
+				continue
+			}
+			if len(crt.AuthorityKeyId) > 0 {
+				if !bytes.Equal(ca.SubjectKeyId, crt.AuthorityKeyId) {
+					continue
+				}
+			} else if !revoke.IssuedBy(crt, ca) {
+				continue
+			}
+			// renewed CAs share the key, the latest one created before the cert signed it
+			if issuerID == 0 || cas[i].ID < cert.ID {
+				issuerID = cas[i].ID
+			}
+		}
+		if issuerID == 0 {
+			continue
+		}
+
+		err = db.Model(&Cert{}).Where("id = ?", cert.ID).UpdateColumn("issuer_id", issuerID).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
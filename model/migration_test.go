@@ -0,0 +1,103 @@
+package model
+
+import (
+	"encoding/base64"
+	"github.com/allape/gocrud"
+	"github.com/allape/stepin/stepin"
+	"github.com/allape/stepin/stepin/create"
+	"github.com/allape/stepin/stepin/native"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"path/filepath"
+	"testing"
+)
+
+// baselineCert
+// Cert as it was before any parsed column existed
+type baselineCert struct {
+	gocrud.Base
+	Profile    create.Profile     `json:"profile"`
+	Name       create.SubjectName `json:"name"`
+	Crt        CensoredField      `json:"crt"`
+	Key        CensoredField      `json:"key"`
+	Inspection stepin.Inspection  `json:"inspection"`
+}
+
+func (baselineCert) TableName() string {
+	return "certs"
+}
+
+func TestBackfillIssuerIDs(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "baseline.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.AutoMigrate(&baselineCert{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backend := native.Backend{}
+	_, rootCrt, rootKey, err := backend.NewRootCA(create.RootOptions{
+		PrimaryOptions: create.PrimaryOptions{Subject: "root", Password: "123456"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, leafCrt, _, err := backend.NewTLS(create.RootlessOptions{
+		PrimaryOptions: create.PrimaryOptions{Subject: "leaf.internal"},
+		RootCaCrt:      rootCrt,
+		RootCaKey:      rootKey,
+		RootPassword:   "123456",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := map[create.Profile]gocrud.ID{}
+	for _, row := range []struct {
+		profile create.Profile
+		crt     create.Crt
+	}{{create.RootCA, rootCrt}, {create.Leaf, leafCrt}} {
+		profile := row.profile
+		cert := Cert{Crt: CensoredField(base64.StdEncoding.EncodeToString(row.crt))}
+		err = cert.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		baseline := baselineCert{Profile: profile, Name: create.SubjectName(profile), Crt: cert.Crt, Key: cert.Key}
+		err = db.Create(&baseline).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[profile] = baseline.ID
+	}
+
+	err = db.AutoMigrate(&Cert{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nulls int64
+	db.Model(&Cert{}).Where("issuer_id IS NULL").Count(&nulls)
+	if nulls != 2 {
+		t.Fatalf("issuer_id should be NULL on the existing rows, got %d", nulls)
+	}
+
+	err = BackfillParsedColumns(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = BackfillIssuerIDs(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var leaf Cert
+	err = db.Model(&leaf).Where("id = ?", ids[create.Leaf]).First(&leaf).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf.IssuerID != ids[create.RootCA] || leaf.Fingerprint == "" {
+		t.Fatalf("leaf is not backfilled: issuer %d, fingerprint %q", leaf.IssuerID, leaf.Fingerprint)
+	}
+}
@@ -0,0 +1,13 @@
+package model
+
+import (
+	"github.com/allape/gocrud"
+)
+
+// ExpiryNotification
+// Sent when the cert crossed the threshold, one per cert and threshold
+type ExpiryNotification struct {
+	gocrud.Base
+	CertID    gocrud.ID `json:"certID" gorm:"uniqueIndex:idx_expiry_notification"`
+	Threshold int       `json:"threshold" gorm:"uniqueIndex:idx_expiry_notification"` // in days
+}
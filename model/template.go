@@ -0,0 +1,17 @@
+package model
+
+import (
+	"github.com/allape/gocrud"
+	"github.com/allape/stepin/stepin/create"
+)
+
+// Template
+// Certificate template of step-cli, see https://smallstep.com/docs/step-ca/templates/#x509-templates
+type Template struct {
+	gocrud.Base
+	Name        string         `json:"name" gorm:"uniqueIndex;size:191"`
+	Profile     create.Profile `json:"profile"` // empty for all profiles
+	Description string         `json:"description"`
+	Content     string         `json:"content"`
+	SampleData  map[string]any `json:"sampleData" gorm:"serializer:json"` // variables to render the content while validating, the same as `--set`
+}
@@ -0,0 +1,48 @@
+package model
+
+import (
+	"github.com/allape/gocrud"
+	"slices"
+	"time"
+)
+
+type Role string
+
+const (
+	RoleViewer  Role = "viewer"   // list certs and download certificates
+	RoleIssuer  Role = "issuer"   // create, renew and revoke leaf certs, download their keys
+	RoleCAAdmin Role = "ca-admin" // everything, including CAs and users
+)
+
+var AllRoles = []Role{
+	RoleViewer,
+	RoleIssuer,
+	RoleCAAdmin,
+}
+
+// Includes
+// Roles are ordered, a role includes all roles before it in AllRoles
+func (r Role) Includes(role Role) bool {
+	index := slices.Index(AllRoles, role)
+	return index != -1 && index <= slices.Index(AllRoles, r)
+}
+
+// User
+// PasswordHash is bcrypt hashed, empty to allow API tokens only
+type User struct {
+	gocrud.Base
+	Username     string `json:"username" gorm:"uniqueIndex;size:191"`
+	PasswordHash string `json:"-"`
+	Role         Role   `json:"role"`
+}
+
+// Token
+// API token of a user, only the SHA-256 of the token is stored
+type Token struct {
+	gocrud.Base
+	UserID     gocrud.ID  `json:"userID" gorm:"index"`
+	Name       string     `json:"name"`
+	Hash       string     `json:"-" gorm:"uniqueIndex;size:64"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/allape/stepin/env"
+	"github.com/allape/stepin/notify"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// SetupExpiryNotifier
+// Start scanning expiring certs in the background if any webhook or SMTP server is configured
+func SetupExpiryNotifier(ctx context.Context, db *gorm.DB) error {
+	var thresholds []int
+	for _, item := range splitList(env.NotifyThresholdDays) {
+		days, err := strconv.Atoi(item)
+		if err != nil || days <= 0 {
+			return fmt.Errorf("invalid notify threshold: %s", item)
+		}
+		thresholds = append(thresholds, days)
+	}
+
+	if env.NotifyIntervalMinutes <= 0 {
+		return fmt.Errorf("invalid notify interval: %d", env.NotifyIntervalMinutes)
+	}
+
+	var notifiers []notify.Notifier
+	for _, url := range splitList(env.NotifyWebhookURLs) {
+		notifiers = append(notifiers, notify.Webhook{
+			URL:    url,
+			Client: &http.Client{Timeout: 30 * time.Second},
+		})
+	}
+	if env.NotifySMTPAddress != "" {
+		to := splitList(env.NotifySMTPTo)
+		if len(to) == 0 {
+			return fmt.Errorf("recipients of notification mails are required")
+		}
+		notifiers = append(notifiers, notify.SMTP{
+			Address:  env.NotifySMTPAddress,
+			Username: env.NotifySMTPUsername,
+			Password: env.NotifySMTPPassword,
+			From:     env.NotifySMTPFrom,
+			To:       to,
+		})
+	}
+
+	if len(thresholds) == 0 || len(notifiers) == 0 {
+		return nil
+	}
+
+	scheduler := &notify.Scheduler{
+		DB:         db,
+		Thresholds: thresholds,
+		Interval:   time.Duration(env.NotifyIntervalMinutes) * time.Minute,
+		Notifiers:  notifiers,
+	}
+	go scheduler.Run(ctx)
+
+	return nil
+}
@@ -0,0 +1,147 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/allape/gocrud"
+	"github.com/allape/gogger"
+	"github.com/allape/stepin/model"
+	"github.com/allape/stepin/stepin/create"
+	"gorm.io/gorm"
+	"slices"
+	"time"
+)
+
+var l = gogger.New("notify")
+
+type Notification struct {
+	CertID       gocrud.ID          `json:"certID"`
+	Profile      create.Profile     `json:"profile"`
+	Name         create.SubjectName `json:"name"`
+	SerialNumber string             `json:"serialNumber"`
+	Issuer       string             `json:"issuer"`
+	NotAfter     time.Time          `json:"notAfter"`
+	DaysLeft     int                `json:"daysLeft"`
+	Threshold    int                `json:"threshold"`
+}
+
+func (n Notification) String() string {
+	return fmt.Sprintf("%s %s (%d) expires in %d days at %s", n.Profile, n.Name, n.CertID, n.DaysLeft, n.NotAfter.Format(time.RFC3339))
+}
+
+type Notifier interface {
+	Notify(ctx context.Context, notification Notification) error
+}
+
+// Scheduler
+// Scan certs periodically, a notification is sent once for each threshold a cert crossed.
+// Revoked certs and certs which have been renewed are ignored.
+type Scheduler struct {
+	DB         *gorm.DB
+	Thresholds []int // in days
+	Interval   time.Duration
+	Notifiers  []Notifier
+}
+
+// Run
+// Scan until ctx is done
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		err := s.Scan(ctx, time.Now())
+		if err != nil {
+			l.Warn().Printf("failed to scan expiring certs: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Scan
+// Notify certs which expire within the largest threshold, only the smallest threshold crossed is notified
+func (s *Scheduler) Scan(ctx context.Context, now time.Time) error {
+	if len(s.Thresholds) == 0 || len(s.Notifiers) == 0 {
+		return nil
+	}
+
+	thresholds := slices.Clone(s.Thresholds)
+	slices.Sort(thresholds)
+
+	var certs []model.Cert
+	err := s.DB.Model(&model.Cert{}).
+		Scopes(model.Live).
+		Where("revoked_at IS NULL").
+		Where("not_after BETWEEN ? AND ?", now, now.AddDate(0, 0, thresholds[len(thresholds)-1])).
+		// NOT IN is never true if any of them is NULL, which it is on the rows from before supersedes_id existed
+		Where("id NOT IN (?)", s.DB.Model(&model.Cert{}).Select("supersedes_id").Where("supersedes_id IS NOT NULL AND supersedes_id <> 0")).
+		Find(&certs).Error
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, cert := range certs {
+		threshold := -1
+		for _, t := range thresholds {
+			if !cert.NotAfter.After(now.AddDate(0, 0, t)) {
+				threshold = t
+				break
+			}
+		}
+		if threshold < 0 {
+			continue
+		}
+
+		var count int64
+		err = s.DB.Model(&model.ExpiryNotification{}).Where("cert_id = ? AND threshold = ?", cert.ID, threshold).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+
+		notification := Notification{
+			CertID:       cert.ID,
+			Profile:      cert.Profile,
+			Name:         cert.Name,
+			SerialNumber: cert.SerialNumber,
+			Issuer:       cert.Issuer,
+			NotAfter:     cert.NotAfter,
+			DaysLeft:     int(cert.NotAfter.Sub(now).Hours() / 24),
+			Threshold:    threshold,
+		}
+
+		err = s.notify(ctx, notification)
+		if err != nil {
+			// not recorded, retry on next scan
+			errs = append(errs, fmt.Errorf("cert %d: %w", cert.ID, err))
+			continue
+		}
+
+		err = s.DB.Create(&model.ExpiryNotification{CertID: cert.ID, Threshold: threshold}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (s *Scheduler) notify(ctx context.Context, notification Notification) error {
+	var errs []error
+	for _, notifier := range s.Notifiers {
+		err := notifier.Notify(ctx, notification)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,219 @@
+package notify_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"github.com/allape/gocrud"
+	"github.com/allape/stepin/model"
+	"github.com/allape/stepin/notify"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSMTP
+// Accept any mail and keep the DATA of it
+type fakeSMTP struct {
+	listener net.Listener
+	locker   sync.Mutex
+	mails    []string
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSMTP{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTP) serve(conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+	reply := func(line string) {
+		_, _ = conn.Write([]byte(line + "\r\n"))
+	}
+
+	reply("220 fake smtp")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.Fields(line + " ")[0])
+		switch command {
+		case "EHLO", "HELO":
+			reply("250 fake")
+		case "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				line, err = reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			s.locker.Lock()
+			s.mails = append(s.mails, data.String())
+			s.locker.Unlock()
+			reply("250 ok")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func (s *fakeSMTP) Mails() []string {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	return append([]string{}, s.mails...)
+}
+
+func TestScheduler_Scan(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "notify.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.Cert{}, &model.ExpiryNotification{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	day := 24 * time.Hour
+	revokedAt := now.Add(-day)
+	certs := []model.Cert{
+		{Name: "in 20 days", NotAfter: now.Add(20 * day)},
+		{Name: "in 5 days", NotAfter: now.Add(5 * day)},
+		{Name: "in 60 days", NotAfter: now.Add(60 * day)},
+		{Name: "expired", NotAfter: now.Add(-day)},
+		{Name: "revoked", NotAfter: now.Add(3 * day), RevokedAt: &revokedAt},
+		{Name: "renewed", NotAfter: now.Add(2 * day)},
+	}
+	err = db.Create(&certs).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Create(&model.Cert{Name: "renewal", NotAfter: now.Add(365 * day), SupersedesID: certs[5].ID}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	// as the rows created before supersedes_id existed
+	err = db.Model(&model.Cert{}).Where("id IN ?", []gocrud.ID{certs[2].ID, certs[3].ID}).UpdateColumn("supersedes_id", gorm.Expr("NULL")).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		locker        sync.Mutex
+		notifications []notify.Notification
+		failing       = true
+	)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		locker.Lock()
+		defer locker.Unlock()
+		if failing {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var notification notify.Notification
+		err := json.NewDecoder(r.Body).Decode(&notification)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		notifications = append(notifications, notification)
+	}))
+	defer webhook.Close()
+
+	mailer := newFakeSMTP(t)
+	defer func() {
+		_ = mailer.listener.Close()
+	}()
+
+	scheduler := &notify.Scheduler{
+		DB:         db,
+		Thresholds: []int{30, 7, 1},
+		Interval:   time.Hour,
+		Notifiers: []notify.Notifier{
+			notify.Webhook{URL: webhook.URL},
+			notify.SMTP{
+				Address: mailer.listener.Addr().String(),
+				From:    "stepin@stepin.internal",
+				To:      []string{"admin@stepin.internal"},
+			},
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// nothing is recorded if the delivery failed
+	if scheduler.Scan(ctx, now) == nil {
+		t.Fatal("scan should fail with a failing webhook")
+	}
+
+	locker.Lock()
+	failing = false
+	locker.Unlock()
+
+	for i := 0; i < 2; i++ {
+		err = scheduler.Scan(ctx, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(notifications) != 2 {
+		t.Fatalf("expected 2 notifications, got %v", notifications)
+	}
+	for _, n := range notifications {
+		if (n.Name == "in 20 days" && n.Threshold != 30) || (n.Name == "in 5 days" && n.Threshold != 7) {
+			t.Fatalf("unexpected threshold of %s: %d", n.Name, n.Threshold)
+		}
+	}
+
+	// 5 days left for the one in 20 days, the one in 5 days has expired
+	err = scheduler.Scan(ctx, now.Add(15*day))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notifications) != 3 || notifications[2].Name != "in 20 days" || notifications[2].Threshold != 7 {
+		t.Fatalf("expected the 7 days notification of cert in 20 days, got %v", notifications)
+	}
+
+	mails := mailer.Mails()
+	if len(mails) != 5 { // 2 of the failed scan, 3 of the rest
+		t.Fatalf("expected 5 mails, got %d", len(mails))
+	}
+	if !strings.Contains(mails[len(mails)-1], "Subject: [stepin] in 20 days expires in 5 days") {
+		t.Fatalf("unexpected mail: %s", mails[len(mails)-1])
+	}
+}
@@ -0,0 +1,99 @@
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SMTP
+// Send the notification as a plain text mail, STARTTLS is used if the server supports it
+type SMTP struct {
+	Address  string // host:port
+	Username string // no authentication if empty
+	Password string
+	From     string
+	To       []string
+}
+
+func (s SMTP) Notify(ctx context.Context, notification Notification) error {
+	host, _, err := net.SplitHostPort(s.Address)
+	if err != nil {
+		return err
+	}
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", s.Address)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		err = client.StartTLS(&tls.Config{ServerName: host})
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.Username != "" {
+		err = client.Auth(smtp.PlainAuth("", s.Username, s.Password, host))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = client.Mail(s.From)
+	if err != nil {
+		return err
+	}
+	for _, to := range s.To {
+		err = client.Rcpt(to)
+		if err != nil {
+			return err
+		}
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(s.message(notification))
+	if err != nil {
+		return err
+	}
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+func (s SMTP) message(notification Notification) []byte {
+	var b strings.Builder
+	_, _ = fmt.Fprintf(&b, "From: %s\r\n", s.From)
+	_, _ = fmt.Fprintf(&b, "To: %s\r\n", strings.Join(s.To, ", "))
+	_, _ = fmt.Fprintf(&b, "Subject: [stepin] %s expires in %d days\r\n", notification.Name, notification.DaysLeft)
+	_, _ = fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	_, _ = fmt.Fprintf(&b, "%s\r\n", notification)
+	_, _ = fmt.Fprintf(&b, "Serial Number: %s\r\n", notification.SerialNumber)
+	_, _ = fmt.Fprintf(&b, "Issuer: %s\r\n", notification.Issuer)
+	return []byte(b.String())
+}
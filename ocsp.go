@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"github.com/allape/gocrud"
+	"github.com/allape/stepin/env"
+	"github.com/allape/stepin/model"
+	"github.com/allape/stepin/stepin/create"
+	"github.com/allape/stepin/stepin/native"
+	"github.com/allape/stepin/stepin/revoke"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/ocsp"
+	"gorm.io/gorm"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func OCSPURL(caID gocrud.ID) create.URI {
+	return create.URI(fmt.Sprintf("%s/ocsp/%d", strings.TrimSuffix(env.PublicURL, "/"), caID))
+}
+
+func SetupOCSPController(group *gin.RouterGroup, db *gorm.DB) error {
+	group.POST("ocsp/:caID", func(context *gin.Context) {
+		der, err := io.ReadAll(context.Request.Body)
+		if err != nil {
+			context.Data(http.StatusOK, revoke.OCSPResponseMimeType, ocsp.MalformedRequestErrorResponse)
+			return
+		}
+		respondOCSP(context, db, der)
+	})
+
+	// https://www.rfc-editor.org/rfc/rfc6960#appendix-A.1
+	group.GET("ocsp/:caID/*request", func(context *gin.Context) {
+		der, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(context.Param("request"), "/"))
+		if err != nil {
+			context.Data(http.StatusOK, revoke.OCSPResponseMimeType, ocsp.MalformedRequestErrorResponse)
+			return
+		}
+		respondOCSP(context, db, der)
+	})
+
+	return nil
+}
+
+func respondOCSP(context *gin.Context, db *gorm.DB, der []byte) {
+	caID, err := ParamID(context, "caID")
+	if err != nil {
+		context.Data(http.StatusOK, revoke.OCSPResponseMimeType, ocsp.UnauthorizedErrorResponse)
+		return
+	}
+
+	var ca model.Cert
+	err = db.Model(&ca).Where("id = ?", caID).First(&ca).Error
+	if err != nil || !ca.IsCA() {
+		context.Data(http.StatusOK, revoke.OCSPResponseMimeType, ocsp.UnauthorizedErrorResponse)
+		return
+	}
+
+	err = ca.Decode()
+	if err != nil {
+		l.Error().Printf("failed to decode ca %d: %v", ca.ID, err)
+		context.Data(http.StatusOK, revoke.OCSPResponseMimeType, ocsp.InternalErrorErrorResponse)
+		return
+	}
+
+	caCrt, err := native.ParseCrt(ca.Crt.ToBytes())
+	if err != nil {
+		l.Error().Printf("failed to parse ca %d: %v", ca.ID, err)
+		context.Data(http.StatusOK, revoke.OCSPResponseMimeType, ocsp.InternalErrorErrorResponse)
+		return
+	}
+
+	request, err := revoke.ParseOCSPRequest(der, caCrt)
+	if err != nil {
+		context.Data(http.StatusOK, revoke.OCSPResponseMimeType, ocsp.MalformedRequestErrorResponse)
+		return
+	}
+
+	status, entry, err := lookupOCSPStatus(db, caCrt, request)
+	if err != nil {
+		l.Error().Printf("failed to lookup status of %x: %v", request.SerialNumber, err)
+		context.Data(http.StatusOK, revoke.OCSPResponseMimeType, ocsp.InternalErrorErrorResponse)
+		return
+	}
+
+	password, err := handleCAPassword(&ca, "")
+	if err != nil {
+		l.Error().Printf("failed to get password of ca %d: %v", ca.ID, err)
+		context.Data(http.StatusOK, revoke.OCSPResponseMimeType, ocsp.InternalErrorErrorResponse)
+		return
+	}
+
+	signer, err := CASigner(&ca, password)
+	if err != nil {
+		l.Error().Printf("failed to load key of ca %d: %v", ca.ID, err)
+		context.Data(http.StatusOK, revoke.OCSPResponseMimeType, ocsp.InternalErrorErrorResponse)
+		return
+	}
+
+	now := time.Now()
+	response, err := revoke.NewOCSPResponse(
+		ca.Crt.ToBytes(),
+		signer,
+		request,
+		status,
+		entry,
+		now,
+		now.Add(time.Duration(env.OCSPValidityHours)*time.Hour),
+	)
+	if err != nil {
+		l.Error().Printf("failed to sign ocsp response with ca %d: %v", ca.ID, err)
+		context.Data(http.StatusOK, revoke.OCSPResponseMimeType, ocsp.InternalErrorErrorResponse)
+		return
+	}
+
+	context.Data(http.StatusOK, revoke.OCSPResponseMimeType, response)
+}
+
+func lookupOCSPStatus(db *gorm.DB, ca *x509.Certificate, request *ocsp.Request) (int, *revoke.Entry, error) {
+	var certs []model.Cert
+	err := db.Model(&model.Cert{}).Where("serial_number = ?", model.SerialNumber(request.SerialNumber)).Find(&certs).Error
+	if err != nil {
+		return ocsp.Unknown, nil, err
+	}
+
+	for _, cert := range certs {
+		err = cert.Decode()
+		if err != nil {
+			return ocsp.Unknown, nil, err
+		}
+		crt, err := native.ParseCrt(cert.Crt.ToBytes())
+		if err != nil {
+			return ocsp.Unknown, nil, err
+		}
+		if !revoke.IssuedBy(crt, ca) {
+			continue
+		}
+		if cert.RevokedAt == nil {
+			return ocsp.Good, nil, nil
+		}
+		return ocsp.Revoked, &revoke.Entry{
+			SerialNumber: crt.SerialNumber,
+			RevokedAt:    *cert.RevokedAt,
+			Reason:       cert.RevocationReason,
+		}, nil
+	}
+
+	return ocsp.Unknown, nil, nil
+}
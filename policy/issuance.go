@@ -0,0 +1,209 @@
+package policy
+
+import (
+	"crypto/x509"
+	"fmt"
+	"github.com/allape/stepin/stepin/create"
+	"github.com/allape/stepin/stepin/native"
+	"math"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Issuance
+// Restrictions on the certs a CA issues, empty fields are not restricted except wildcards.
+//
+// A name is allowed if it matches Allowed (or Allowed is nil) and does not match Denied,
+// DNS names with a wildcard are denied unless AllowWildcards is set.
+type Issuance struct {
+	Allowed         *Policy          `json:"allowed"`
+	Denied          Policy           `json:"denied"`
+	AllowWildcards  bool             `json:"allowWildcards"`
+	MaxValidityDays int              `json:"maxValidityDays"`
+	KeyTypes        []create.KeyType `json:"keyTypes"`
+	Curves          []create.Curve   `json:"curves"` // for EC and OKP keys
+	MinRSASize      create.BitSize   `json:"minRSASize"`
+	RequireSANs     bool             `json:"requireSANs"`  // at least one SAN, the common name alone is not enough
+	RequiredSANs    []string         `json:"requiredSANs"` // each pattern must be matched by a SAN of the request, see matchSAN
+}
+
+// Request
+// The cert to be issued, CommonName is checked as a name unless it is empty
+type Request struct {
+	CommonName create.SubjectName
+	SANs       []create.SAN
+	Validity   time.Duration
+	KeyType    create.KeyType
+	Curve      create.Curve
+	Size       create.BitSize // for RSA keys
+}
+
+// CertRequest
+// The request of a cert already generated, as the template and its variables may have changed the names and the validity in the request,
+// the SANs are in the order of DNS names, IP addresses, email addresses and URIs
+func CertRequest(crt *x509.Certificate) Request {
+	request := Request{
+		CommonName: create.SubjectName(crt.Subject.CommonName),
+		Validity:   crt.NotAfter.Sub(crt.NotBefore),
+	}
+	for _, name := range crt.DNSNames {
+		request.SANs = append(request.SANs, create.SAN(name))
+	}
+	for _, ip := range crt.IPAddresses {
+		request.SANs = append(request.SANs, create.SAN(ip.String()))
+	}
+	for _, email := range crt.EmailAddresses {
+		request.SANs = append(request.SANs, create.SAN(email))
+	}
+	for _, u := range crt.URIs {
+		request.SANs = append(request.SANs, create.SAN(u.String()))
+	}
+	request.KeyType, request.Curve, request.Size = native.KeyParams(crt.PublicKey)
+	return request
+}
+
+// Violation
+// Reasons why a request is rejected by an Issuance
+type Violation struct {
+	Reasons []string
+}
+
+func (v *Violation) Error() string {
+	return "policy violation: " + strings.Join(v.Reasons, "; ")
+}
+
+// Validate
+// Check if the patterns and the key restrictions are well-formed
+func (p *Issuance) Validate() error {
+	if p == nil {
+		return nil
+	}
+	if p.Allowed != nil {
+		err := p.Allowed.Validate()
+		if err != nil {
+			return fmt.Errorf("allowed: %w", err)
+		}
+	}
+	err := p.Denied.Validate()
+	if err != nil {
+		return fmt.Errorf("denied: %w", err)
+	}
+	if p.MaxValidityDays < 0 {
+		return fmt.Errorf("max validity days should not be negative")
+	}
+	for _, pattern := range p.RequiredSANs {
+		if !validSANPattern(pattern) {
+			return fmt.Errorf("invalid required san: %s", pattern)
+		}
+	}
+	for _, kty := range p.KeyTypes {
+		if !slices.Contains(create.AllKeyTypes, kty) {
+			return fmt.Errorf("invalid key type: %s", kty)
+		}
+	}
+	for _, curve := range p.Curves {
+		if !slices.Contains(create.AllCurves, curve) {
+			return fmt.Errorf("invalid curve: %s", curve)
+		}
+	}
+	return nil
+}
+
+// Check
+// Check the request against all restrictions, a nil Issuance allows everything.
+// The returned error is a *Violation with all the reasons if the request is rejected.
+func (p *Issuance) Check(request Request) error {
+	if p == nil {
+		return nil
+	}
+
+	var reasons []string
+
+	names := make([]string, 0, len(request.SANs)+1)
+	for _, san := range request.SANs {
+		names = append(names, string(san))
+	}
+	if request.CommonName != "" && !slices.Contains(names, string(request.CommonName)) {
+		names = append(names, string(request.CommonName))
+	}
+
+	if p.RequireSANs && len(request.SANs) == 0 {
+		reasons = append(reasons, "subject alternative names are required")
+	}
+	for _, pattern := range p.RequiredSANs {
+		if !slices.ContainsFunc(request.SANs, func(san create.SAN) bool {
+			return matchSAN(pattern, string(san))
+		}) {
+			reasons = append(reasons, fmt.Sprintf("required san %s is missing", pattern))
+		}
+	}
+
+	for _, name := range names {
+		if !p.AllowWildcards && strings.Contains(name, "*") {
+			reasons = append(reasons, fmt.Sprintf("wildcard name %s is not allowed", name))
+			continue
+		}
+		if p.Allowed != nil {
+			err := p.Allowed.check(name)
+			if err != nil {
+				reasons = append(reasons, err.Error())
+				continue
+			}
+		}
+		if p.Denied.check(name) == nil {
+			reasons = append(reasons, fmt.Sprintf("name %s is denied", name))
+		}
+	}
+
+	if p.MaxValidityDays > 0 && request.Validity > time.Duration(p.MaxValidityDays)*24*time.Hour {
+		reasons = append(reasons, fmt.Sprintf("validity of %.0f days exceeds %d days", math.Ceil(request.Validity.Hours()/24), p.MaxValidityDays))
+	}
+
+	if len(p.KeyTypes) > 0 && !slices.Contains(p.KeyTypes, request.KeyType) {
+		reasons = append(reasons, fmt.Sprintf("key type %s is not allowed", request.KeyType))
+	}
+	switch request.KeyType {
+	case create.EC, create.OKP:
+		if len(p.Curves) > 0 && !slices.Contains(p.Curves, request.Curve) {
+			reasons = append(reasons, fmt.Sprintf("curve %s is not allowed", request.Curve))
+		}
+	case create.RSA:
+		if request.Size < p.MinRSASize {
+			reasons = append(reasons, fmt.Sprintf("rsa key of %d bits is smaller than %d bits", request.Size, p.MinRSASize))
+		}
+	}
+
+	if len(reasons) > 0 {
+		return &Violation{Reasons: reasons}
+	}
+
+	return nil
+}
+
+// validSANPattern
+// A domain pattern as in Policy, a CIDR, or an exact IP address, email address or URI
+func validSANPattern(pattern string) bool {
+	if _, _, err := net.ParseCIDR(pattern); err == nil {
+		return true
+	}
+	if pattern == "*" || create.IsIPAddress(pattern) || create.IsEmailAddress(pattern) || create.IsURI(pattern) {
+		return true
+	}
+	name := strings.TrimPrefix(pattern, "*.")
+	return !strings.Contains(name, "*") && create.IsDNSName(name)
+}
+
+// matchSAN
+// A CIDR matches the IP addresses in it, a domain pattern matches DNS names as in Policy,
+// anything else matches the same SAN ignoring case
+func matchSAN(pattern, san string) bool {
+	if _, ipNet, err := net.ParseCIDR(pattern); err == nil {
+		return create.IsIPAddress(san) && ipNet.Contains(net.ParseIP(san))
+	}
+	if create.IsDNSName(san) && !create.IsIPAddress(san) && (pattern == "*" || strings.HasPrefix(pattern, "*.")) {
+		return matchDomain([]string{pattern}, san)
+	}
+	return strings.EqualFold(pattern, san)
+}
@@ -0,0 +1,115 @@
+package policy
+
+import (
+	"fmt"
+	"github.com/allape/stepin/stepin/create"
+	"net"
+	"slices"
+	"strings"
+)
+
+// Policy
+// Names a CA is allowed to sign, a name type with no patterns is not allowed at all.
+//
+// DNS and email domain patterns are either an exact name, `*` for any name,
+// or `*.example.internal` for any subdomain of example.internal at any depth.
+type Policy struct {
+	DNSNames     []string `json:"dnsNames"`
+	IPRanges     []string `json:"ipRanges"` // CIDRs, e.g. 10.0.0.0/8
+	EmailDomains []string `json:"emailDomains"`
+	URIPrefixes  []string `json:"uriPrefixes"`
+}
+
+// Validate
+// Check if all patterns are well-formed
+func (p Policy) Validate() error {
+	for _, pattern := range append(append([]string{}, p.DNSNames...), p.EmailDomains...) {
+		name := strings.TrimPrefix(pattern, "*.")
+		if pattern != "*" && (strings.Contains(name, "*") || !create.IsDNSName(name)) {
+			return fmt.Errorf("invalid domain pattern: %s", pattern)
+		}
+	}
+	for _, cidr := range p.IPRanges {
+		_, _, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid ip range %s: %w", cidr, err)
+		}
+	}
+	for _, prefix := range p.URIPrefixes {
+		if !create.IsURI(prefix) {
+			return fmt.Errorf("invalid uri prefix: %s", prefix)
+		}
+	}
+	return nil
+}
+
+// Check
+// Check the common name and SANs against the policy, the common name is checked as a SAN unless it is already one of them
+func (p Policy) Check(commonName create.SubjectName, sans []create.SAN) error {
+	names := make([]string, 0, len(sans)+1)
+	for _, san := range sans {
+		names = append(names, string(san))
+	}
+	if commonName != "" && !slices.Contains(names, string(commonName)) {
+		names = append(names, string(commonName))
+	}
+
+	for _, name := range names {
+		err := p.check(name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p Policy) check(name string) error {
+	switch {
+	case create.IsIPAddress(name):
+		ip := net.ParseIP(name)
+		for _, cidr := range p.IPRanges {
+			_, ipNet, err := net.ParseCIDR(cidr)
+			if err == nil && ipNet.Contains(ip) {
+				return nil
+			}
+		}
+		return fmt.Errorf("ip address %s is not allowed", name)
+	case create.IsEmailAddress(name):
+		domain := name[strings.LastIndex(name, "@")+1:]
+		if matchDomain(p.EmailDomains, domain) {
+			return nil
+		}
+		return fmt.Errorf("email address %s is not allowed", name)
+	case create.IsDNSName(name):
+		if matchDomain(p.DNSNames, name) {
+			return nil
+		}
+		return fmt.Errorf("dns name %s is not allowed", name)
+	case create.IsURI(name):
+		for _, prefix := range p.URIPrefixes {
+			if strings.HasPrefix(name, prefix) {
+				return nil
+			}
+		}
+		return fmt.Errorf("uri %s is not allowed", name)
+	default:
+		return fmt.Errorf("name %s is not allowed", name)
+	}
+}
+
+// matchDomain
+// A wildcard name like *.a.example.internal matches *.example.internal, but not a.example.internal
+func matchDomain(patterns []string, name string) bool {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	for _, pattern := range patterns {
+		pattern = strings.TrimSuffix(strings.ToLower(pattern), ".")
+		if pattern == "*" || pattern == name {
+			return true
+		}
+		if strings.HasPrefix(pattern, "*.") && strings.HasSuffix(name, pattern[1:]) {
+			return true
+		}
+	}
+	return false
+}
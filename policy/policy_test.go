@@ -0,0 +1,220 @@
+package policy
+
+import (
+	"errors"
+	"github.com/allape/stepin/stepin/create"
+	"github.com/allape/stepin/stepin/native"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPolicy_Check(t *testing.T) {
+	p := Policy{
+		DNSNames:     []string{"*.team-a.internal", "team-a.internal"},
+		IPRanges:     []string{"10.20.0.0/16"},
+		EmailDomains: []string{"team-a.internal"},
+		URIPrefixes:  []string{"spiffe://team-a.internal/"},
+	}
+	err := p.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		commonName create.SubjectName
+		sans       []create.SAN
+		allowed    bool
+	}{
+		{"team-a.internal", nil, true},
+		{"", []create.SAN{"www.team-a.internal", "a.b.team-a.internal", "*.team-a.internal"}, true},
+		{"", []create.SAN{"10.20.1.1", "admin@team-a.internal", "spiffe://team-a.internal/web"}, true},
+		{"www.team-a.internal", []create.SAN{"www.team-a.internal"}, true},
+		{"www.team-b.internal", []create.SAN{"www.team-a.internal"}, false},
+		{"", []create.SAN{"team-a.internal.evil"}, false},
+		{"", []create.SAN{"evilteam-a.internal"}, false},
+		{"", []create.SAN{"10.21.0.1"}, false},
+		{"", []create.SAN{"admin@team-b.internal"}, false},
+		{"", []create.SAN{"spiffe://team-b.internal/web"}, false},
+		{"Team A", nil, false},
+	}
+	for _, c := range cases {
+		err := p.Check(c.commonName, c.sans)
+		if c.allowed && err != nil {
+			t.Errorf("%q %v should be allowed: %v", c.commonName, c.sans, err)
+		} else if !c.allowed && err == nil {
+			t.Errorf("%q %v should not be allowed", c.commonName, c.sans)
+		}
+	}
+
+	if (Policy{}).Check("localhost", nil) == nil {
+		t.Error("empty policy should allow nothing")
+	}
+	if (Policy{DNSNames: []string{"*"}}).Check("localhost", nil) != nil {
+		t.Error("* should allow any dns name")
+	}
+	if (Policy{DNSNames: []string{"*.*.internal"}}).Validate() == nil {
+		t.Error("nested wildcard should be invalid")
+	}
+}
+
+func TestIssuance_Check(t *testing.T) {
+	p := &Issuance{
+		Allowed:         &Policy{DNSNames: []string{"*.team-a.internal"}, IPRanges: []string{"10.20.0.0/16"}},
+		Denied:          Policy{DNSNames: []string{"*.admin.team-a.internal"}, IPRanges: []string{"10.20.0.0/24"}},
+		MaxValidityDays: 90,
+		KeyTypes:        []create.KeyType{create.EC, create.RSA},
+		Curves:          []create.Curve{create.P256},
+		MinRSASize:      3072,
+		RequireSANs:     true,
+	}
+	err := p.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := Request{
+		CommonName: "www.team-a.internal",
+		SANs:       []create.SAN{"www.team-a.internal", "10.20.1.1"},
+		Validity:   30 * 24 * time.Hour,
+		KeyType:    create.EC,
+		Curve:      create.P256,
+	}
+	err = p.Check(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []func(r *Request){
+		func(r *Request) { r.SANs = nil },
+		func(r *Request) { r.SANs = append(r.SANs, "*.team-a.internal") },
+		func(r *Request) { r.SANs = append(r.SANs, "www.team-b.internal") },
+		func(r *Request) { r.SANs = append(r.SANs, "x.admin.team-a.internal") },
+		func(r *Request) { r.SANs = append(r.SANs, "10.20.0.1") },
+		func(r *Request) { r.CommonName = "www.team-b.internal" },
+		func(r *Request) { r.Validity = 91 * 24 * time.Hour },
+		func(r *Request) { r.KeyType = create.OKP; r.Curve = create.Ed25519 },
+		func(r *Request) { r.Curve = create.P384 },
+		func(r *Request) { r.KeyType = create.RSA; r.Size = 2048 },
+	}
+	for i, modify := range cases {
+		request := valid
+		request.SANs = append([]create.SAN{}, valid.SANs...)
+		modify(&request)
+		err = p.Check(request)
+		var violation *Violation
+		if !errors.As(err, &violation) {
+			t.Errorf("case %d should be a violation, got %v", i, err)
+		}
+	}
+
+	p.AllowWildcards = true
+	request := valid
+	request.SANs = []create.SAN{"*.team-a.internal"}
+	err = p.Check(request)
+	if err != nil {
+		t.Errorf("wildcard should be allowed: %v", err)
+	}
+
+	p.RequiredSANs = []string{"*.team-a.internal", "10.20.1.0/24", "www.team-a.internal"}
+	err = p.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Check(valid)
+	if err != nil {
+		t.Errorf("required sans should be satisfied: %v", err)
+	}
+	request = valid
+	request.SANs = []create.SAN{"api.team-a.internal"}
+	err = p.Check(request)
+	var violation *Violation
+	if !errors.As(err, &violation) || len(violation.Reasons) != 2 ||
+		violation.Reasons[0] != "required san 10.20.1.0/24 is missing" ||
+		violation.Reasons[1] != "required san www.team-a.internal is missing" {
+		t.Errorf("missing required sans should be named, got %v", err)
+	}
+	if (&Issuance{RequiredSANs: []string{"*.*.internal"}}).Validate() == nil {
+		t.Error("nested wildcard should be an invalid required san")
+	}
+
+	if (*Issuance)(nil).Check(Request{CommonName: "anything"}) != nil {
+		t.Error("nil issuance should allow everything")
+	}
+}
+
+func TestCertRequest(t *testing.T) {
+	backend := native.Backend{}
+
+	_, caCrt, caKey, err := backend.NewRootCA(create.RootOptions{
+		PrimaryOptions: create.PrimaryOptions{
+			Subject:  "team-a",
+			Password: "123456",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the body asks for www only, the template adds a name from its variables
+	template := filepath.Join(t.TempDir(), "leaf.tpl")
+	err = os.WriteFile(template, []byte(`{
+	"subject": {{ toJson .Subject }},
+	"dnsNames": ["www.team-a.internal", {{ toJson .Insecure.User.extra }}],
+	"keyUsage": ["digitalSignature"],
+	"extKeyUsage": ["serverAuth"]
+}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Issuance{
+		Allowed: &Policy{DNSNames: []string{"*.team-a.internal"}},
+		Denied:  Policy{DNSNames: []string{"*.admin.team-a.internal"}},
+	}
+	body := Request{
+		CommonName: "www.team-a.internal",
+		SANs:       []create.SAN{"www.team-a.internal"},
+		KeyType:    create.EC,
+		Curve:      create.P256,
+	}
+	err = p.Check(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, crt, _, err := backend.NewTLS(create.RootlessOptions{
+		PrimaryOptions: create.PrimaryOptions{
+			Subject: "www.team-a.internal",
+		},
+		RootCaCrt:    caCrt,
+		RootCaKey:    caKey,
+		RootPassword: "123456",
+	}, create.OptionSAN{SAN: body.SANs}, create.OptionTemplate{Template: create.FilePath(template)}, create.OptionSet{Set: []create.Set{
+		{Key: "extra", Value: "x.admin.team-a.internal"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := native.ParseCrt(crt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := CertRequest(leaf)
+	if !slices.Equal(request.SANs, []create.SAN{"www.team-a.internal", "x.admin.team-a.internal"}) ||
+		request.CommonName != "www.team-a.internal" || request.KeyType != create.EC || request.Curve != create.P256 {
+		t.Fatalf("unexpected request of the cert: %+v", request)
+	}
+	if request.Validity != leaf.NotAfter.Sub(leaf.NotBefore) {
+		t.Fatalf("unexpected validity: %s", request.Validity)
+	}
+
+	err = p.Check(request)
+	var violation *Violation
+	if !errors.As(err, &violation) || violation.Reasons[0] != "name x.admin.team-a.internal is denied" {
+		t.Fatalf("name injected by the template should be denied, got %v", err)
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/allape/gocrud"
+	"github.com/allape/stepin/audit"
+	"github.com/allape/stepin/auth"
+	"github.com/allape/stepin/env"
+	"github.com/allape/stepin/model"
+	"github.com/allape/stepin/policy"
+	"github.com/allape/stepin/stepin"
+	"github.com/allape/stepin/stepin/create"
+	"github.com/allape/stepin/stepin/native"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type RenewCertBody struct {
+	RotateKey        bool            `json:"rotateKey"` // generate a new key pair instead of reusing the current one, leaf only
+	Years            int64           `json:"years"`     // calendar years, exclusive with notAfter and duration
+	Pass             create.Password `json:"pass"`      // password of the ca key
+	ParentCaPassword create.Password `json:"parentCaPassword"`
+
+	// the same validity period as the renewed cert if neither years, notAfter nor duration is given
+	create.Validity
+}
+
+func SetupRenewController(group *gin.RouterGroup, db *gorm.DB, backend create.Backend) error {
+	group.POST("cert/:id/renew", audit.Record(db, audit.CertRenew), auth.RequireFunc(CertRole(db)), func(context *gin.Context) {
+		var body RenewCertBody
+		err := context.BindJSON(&body)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+			return
+		}
+
+		var cert model.Cert
+		err = db.Model(&cert).Scopes(model.Live).First(&cert, context.Param("id")).Error
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.NotFound(), err)
+			return
+		}
+
+		var successor model.Cert
+		err = db.Model(&successor).Scopes(model.NotDeleted).Where("supersedes_id = ?", cert.ID).First(&successor).Error
+		if err == nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.Conflict(), fmt.Errorf("cert %d has been renewed by cert %d", cert.ID, successor.ID))
+			return
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		if cert.RevokedAt != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), fmt.Errorf("cert %d has been revoked", cert.ID))
+			return
+		}
+
+		switch cert.Profile {
+		case create.RootCA, create.IntermediateCA:
+			if body.RotateKey {
+				// children are signed by the current key, they would no longer chain to the renewed ca
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), fmt.Errorf("key of a ca can not be rotated on renewal"))
+				return
+			}
+		case create.Leaf, create.SelfSigned:
+		default:
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), fmt.Errorf("renewal of %s is not supported", cert.Profile))
+			return
+		}
+
+		err = cert.Decode()
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		if len(cert.Key.ToBytes()) == 0 && cert.KMS == "" {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), fmt.Errorf("key of cert %d is not stored, sign a new csr instead", cert.ID))
+			return
+		}
+
+		var (
+			issuer         *model.Cert
+			issuerPassword create.Password
+		)
+		if cert.Profile != create.RootCA && cert.Profile != create.SelfSigned {
+			issuer, err = FindIssuer(db, cert.Crt.ToBytes())
+			if err != nil {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+				return
+			}
+			if issuer == nil {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), fmt.Errorf("issuer of cert %d is not found", cert.ID))
+				return
+			}
+			issuerPassword, err = handleCAPassword(issuer, body.ParentCaPassword)
+			if err != nil {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+				return
+			}
+		}
+
+		var password create.Password
+		if cert.IsCA() {
+			password, err = handleCAPassword(&cert, body.Pass)
+			if err != nil {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+				return
+			}
+		}
+
+		if body.Years == 0 && body.NotAfter == nil && body.Duration == "" {
+			body.Duration = create.Duration(cert.NotAfter.Sub(cert.NotBefore).String())
+		}
+		notBefore, notAfter, err := ValidityWindow(body.Validity, body.Years, cert.Profile, time.Now())
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+			return
+		}
+
+		renewed, err := RenewCert(db, backend, &cert, password, body.RotateKey, notBefore, notAfter, issuer, issuerPassword)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, IssuanceErrorCode(err), err)
+			return
+		}
+
+		context.JSON(http.StatusOK, gocrud.R[*model.Cert]{
+			Code: gocrud.RestCoder.OK(),
+			Data: renewed.Strip(),
+		})
+	})
+
+	return nil
+}
+
+// RenewCert
+// Re-issue the decoded cert with the same profile, subject and SANs by the decoded issuer, issuer is nil for root CAs and self-signed certs.
+// The key pair is reused unless rotateKey is set, the new cert is stored with a link to the renewed one.
+// notAfter never goes beyond the one of the issuer.
+func RenewCert(
+	db *gorm.DB,
+	backend create.Backend,
+	cert *model.Cert,
+	password create.Password,
+	rotateKey bool,
+	notBefore, notAfter time.Time,
+	issuer *model.Cert,
+	issuerPassword create.Password,
+) (*model.Cert, error) {
+	if issuer != nil {
+		var err error
+		notAfter, err = ClampToIssuer(notBefore, notAfter, issuer)
+		if err != nil {
+			return nil, err
+		}
+
+		crt, err := native.ParseCrt(cert.Crt.ToBytes())
+		if err != nil {
+			return nil, err
+		}
+		// a rotated key has the same algorithm
+		kty, curve, size := native.KeyParams(crt.PublicKey)
+		request := policy.Request{
+			SANs:     cert.SANs,
+			Validity: notAfter.Sub(notBefore),
+			KeyType:  kty,
+			Curve:    curve,
+			Size:     size,
+		}
+		if !cert.IsCA() {
+			request.CommonName = cert.Name
+		}
+		err = issuer.Policy.Check(request)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	options := []stepin.CommandOption{
+		commandBinOption(),
+	}
+	options = append(options, ValidityOptions(notBefore, notAfter)...)
+
+	if len(cert.SANs) > 0 {
+		options = append(options, create.OptionSAN{
+			SAN: cert.SANs,
+		})
+	}
+
+	if cert.IsCA() {
+		// a renewed CA signs for no more than the renewed one
+		options = append(options, create.ConstraintOptions(cert.NameConstraints, cert.MaxPathLen)...)
+	}
+
+	if rotateKey {
+		keyOptions, err := keyTypeOptions(cert.KeyAlgorithm)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, keyOptions...)
+	} else if cert.KMS != "" {
+		options = append(options, create.OptionKMS{KMS: cert.KMS})
+	} else {
+		keyFile, disposeKeyFile, err := stepin.NewTmpFile("stepin_key_*.key", cert.Key.ToBytes())
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = disposeKeyFile()
+		}()
+		_ = keyFile.Close()
+		options = append(options, create.OptionKey{Key: create.KeyFile(keyFile.Name())})
+	}
+
+	if issuer != nil && env.PublicURL != "" {
+		options = append(options, create.OptionCRLDistributionPoints{
+			CRLDistributionPoints: []create.URI{CRLURL(issuer.ID)},
+		}, create.OptionOCSPServer{
+			OCSPServer: []create.URI{OCSPURL(issuer.ID)},
+		})
+	}
+
+	var (
+		inspection stepin.Inspection
+		crt        create.Crt
+		key        create.Key
+		err        error
+	)
+
+	switch cert.Profile {
+	case create.RootCA:
+		inspection, crt, key, err = backend.NewRootCA(create.RootOptions{
+			PrimaryOptions: create.PrimaryOptions{
+				Subject:  cert.Name,
+				Password: password,
+			},
+		}, options...)
+	case create.SelfSigned:
+		inspection, crt, key, err = backend.NewSelfSigned(create.PrimaryOptions{
+			Subject: cert.Name,
+		}, append(options, create.OptionNoPassword{NoPassword: true})...)
+	case create.IntermediateCA, create.Leaf:
+		if issuer == nil {
+			return nil, fmt.Errorf("issuer is required to renew %s", cert.Profile)
+		}
+		opt := create.RootlessOptions{
+			PrimaryOptions: create.PrimaryOptions{
+				Subject:  cert.Name,
+				Password: password,
+			},
+			RootCaCrt:    issuer.Crt.ToBytes(),
+			RootCaKey:    issuer.Key.ToBytes(),
+			RootPassword: issuerPassword,
+		}
+		options = append(options, CAKMSOptions(issuer)...)
+		if cert.Profile == create.IntermediateCA {
+			inspection, crt, key, err = backend.NewIntermediateCA(opt, options...)
+		} else {
+			inspection, crt, key, err = backend.NewTLS(opt, options...)
+		}
+	default:
+		return nil, fmt.Errorf("renewal of %s is not supported", cert.Profile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !rotateKey {
+		key = cert.Key.ToBytes()
+	}
+
+	renewed := &model.Cert{
+		Profile:      cert.Profile,
+		Name:         cert.Name,
+		SANs:         cert.SANs,
+		Crt:          model.CensoredField(base64.StdEncoding.EncodeToString(crt)),
+		Key:          model.CensoredField(base64.StdEncoding.EncodeToString(key)),
+		KMS:          cert.KMS,
+		Inspection:   inspection,
+		SupersedesID: cert.ID,
+		Policy:       cert.Policy,
+	}
+	if issuer != nil {
+		renewed.IssuerID = issuer.ID
+	}
+
+	err = SaveCert(db, renewed)
+	if err != nil {
+		return nil, err
+	}
+
+	return renewed, nil
+}
+
+// keyTypeOptions
+// Options to generate a key pair of the same algorithm, see native.KeyAlgorithm
+func keyTypeOptions(algorithm string) ([]stepin.CommandOption, error) {
+	fields := strings.Fields(algorithm)
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("unknown key algorithm: %s", algorithm)
+	}
+
+	switch create.KeyType(fields[0]) {
+	case create.EC, create.OKP:
+		return []stepin.CommandOption{
+			create.OptionKeyType{KTY: create.KeyType(fields[0])},
+			create.OptionCurve{Curve: create.Curve(fields[1])},
+		}, nil
+	case create.RSA:
+		size, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("unknown key algorithm: %s", algorithm)
+		}
+		return []stepin.CommandOption{
+			create.OptionKeyType{KTY: create.RSA},
+			create.OptionSize{Size: create.BitSize(size)},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown key algorithm: %s", algorithm)
+	}
+}
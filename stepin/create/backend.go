@@ -0,0 +1,74 @@
+package create
+
+import (
+	"github.com/allape/stepin/stepin"
+	"github.com/allape/stepin/stepin/inspect"
+)
+
+type BackendName string
+
+const (
+	BackendStepCLI BackendName = "step-cli"
+	BackendNative  BackendName = "native"
+)
+
+var AllBackendNames = []BackendName{
+	BackendStepCLI,
+	BackendNative,
+}
+
+// Backend
+// Certificate engine used by the server, all options are the same as the ones accepted by step-cli,
+// so that handlers do not need to know which engine is running behind.
+type Backend interface {
+	NewRootCA(opt RootOptions, options ...stepin.CommandOption) (stepin.Inspection, Crt, Key, error)
+	NewSelfSigned(opt PrimaryOptions, options ...stepin.CommandOption) (stepin.Inspection, Crt, Key, error)
+	NewIntermediateCA(opt RootlessOptions, options ...stepin.CommandOption) (stepin.Inspection, Crt, Key, error)
+	NewLeaf(opt RootlessOptions, options ...stepin.CommandOption) (stepin.Inspection, Crt, Key, error)
+	NewTLS(opt RootlessOptions, options ...stepin.CommandOption) (stepin.Inspection, Crt, Key, error)
+	SignCSR(opt SignOptions, options ...stepin.CommandOption) (stepin.Inspection, Crt, error)
+	Inspect(crt Crt, short bool, options ...stepin.CommandOption) (stepin.Inspection, error)
+}
+
+var _ Backend = (*StepCLI)(nil)
+
+// StepCLI
+// Backend which shells out to the `step` binary
+type StepCLI struct{}
+
+func (StepCLI) NewRootCA(opt RootOptions, options ...stepin.CommandOption) (stepin.Inspection, Crt, Key, error) {
+	return NewRootCA(opt, options...)
+}
+
+func (StepCLI) NewSelfSigned(opt PrimaryOptions, options ...stepin.CommandOption) (stepin.Inspection, Crt, Key, error) {
+	return NewSelfSigned(opt, options...)
+}
+
+func (StepCLI) NewIntermediateCA(opt RootlessOptions, options ...stepin.CommandOption) (stepin.Inspection, Crt, Key, error) {
+	return NewIntermediateCA(opt, options...)
+}
+
+func (StepCLI) NewLeaf(opt RootlessOptions, options ...stepin.CommandOption) (stepin.Inspection, Crt, Key, error) {
+	return NewLeaf(opt, options...)
+}
+
+func (StepCLI) NewTLS(opt RootlessOptions, options ...stepin.CommandOption) (stepin.Inspection, Crt, Key, error) {
+	return NewTLS(opt, options...)
+}
+
+func (StepCLI) SignCSR(opt SignOptions, options ...stepin.CommandOption) (stepin.Inspection, Crt, error) {
+	return SignCSR(opt, options...)
+}
+
+func (StepCLI) Inspect(crt Crt, short bool, options ...stepin.CommandOption) (stepin.Inspection, error) {
+	crtFile, disposeCrtFile, err := stepin.NewTmpFile("stepin_inspect_*.crt", crt)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = disposeCrtFile()
+	}()
+	_ = crtFile.Close()
+
+	return inspect.Inspect(crtFile.Name(), short, options...)
+}
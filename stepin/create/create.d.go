@@ -79,11 +79,12 @@ type OptionSubject struct {
 }
 
 func (o OptionSubject) Apply(commander *stepin.Commander) (*stepin.Commander, error) {
-	commander.Arguments = append([]string{
-		string(o.Subject),
-		string(o.CrtFile),
-		string(o.KeyFile),
-	}, commander.Arguments...)
+	arguments := []string{string(o.Subject), string(o.CrtFile)}
+	if o.KeyFile != "" {
+		// absent for keys held in a KMS
+		arguments = append(arguments, string(o.KeyFile))
+	}
+	commander.Arguments = append(arguments, commander.Arguments...)
 
 	return commander, nil
 }
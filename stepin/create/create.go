@@ -46,6 +46,35 @@ type PrimaryOptions struct {
 }
 
 func NewRaw(opt PrimaryOptions, options ...stepin.CommandOption) (stepin.Inspection, Crt, Key, error) {
+	err := ValidateKeyOptions(options)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	options, disposeTemplateFile, err := withInjectedTemplate(options)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if disposeTemplateFile != nil {
+		defer func() {
+			_ = disposeTemplateFile()
+		}()
+	}
+
+	kms, caKMS := KMSOf(options)
+	for _, u := range []URI{kms, caKMS} {
+		if u == "" {
+			continue
+		}
+		err = ValidateKMSURI(u)
+		if err != nil {
+			return "", nil, nil, err
+		}
+	}
+	if kms != "" {
+		return newInKMS(opt.Subject, kms, options...)
+	}
+
 	subject := opt.Subject
 	password := opt.Password
 	passFilePath := PasswordFile("")
@@ -104,6 +133,37 @@ func NewRaw(opt PrimaryOptions, options ...stepin.CommandOption) (stepin.Inspect
 	return inspection, crt, key, nil
 }
 
+// newInKMS
+// Create a certificate of the key at kms, nothing but the certificate is written, so no key is returned
+func newInKMS(subject SubjectName, kms URI, options ...stepin.CommandOption) (stepin.Inspection, Crt, Key, error) {
+	certFile, disposeCertFile, err := stepin.NewTmpFile("stepin_cert_*.crt", nil)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	defer func() {
+		_ = disposeCertFile()
+	}()
+	_ = certFile.Close()
+
+	inspection, err := New(OptionSubject{
+		Subject: subject,
+		CrtFile: CrtFile(certFile.Name()),
+	}, append(
+		options,
+		OptionKey{Key: KeyFile(kms)},
+		OptionForce{Force: true},
+	)...)
+	if err != nil {
+		return inspection, nil, nil, err
+	}
+
+	crt, err := os.ReadFile(certFile.Name())
+	if err != nil {
+		return inspection, nil, nil, err
+	}
+	return inspection, crt, nil, nil
+}
+
 type RootOptions struct {
 	PrimaryOptions
 }
@@ -115,6 +175,15 @@ func NewRootCA(
 	return NewRaw(opt.PrimaryOptions, append(options, OptionProfile{Profile: RootCA})...)
 }
 
+// NewSelfSigned
+// Create a self-signed leaf certificate, which is not trusted by any CA.
+func NewSelfSigned(
+	opt PrimaryOptions,
+	options ...stepin.CommandOption,
+) (stepin.Inspection, Crt, Key, error) {
+	return NewRaw(opt, append(options, OptionProfile{Profile: SelfSigned}, OptionSubtle{Subtle: true})...)
+}
+
 type RootlessOptions struct {
 	PrimaryOptions
 	RootCaCrt    Crt      `json:"rootCaCrt"`
@@ -122,6 +191,8 @@ type RootlessOptions struct {
 	RootPassword Password `json:"rootPassword"`
 }
 
+// NewRootless
+// Create a certificate signed by the CA, whose key is the one at the URI of OptionCAKMS if present, instead of RootCaKey
 func NewRootless(
 	opt RootlessOptions,
 	options ...stepin.CommandOption,
@@ -135,6 +206,17 @@ func NewRootless(
 	}()
 	_ = rootCaCrtFile.Close()
 
+	if _, caKMS := KMSOf(options); caKMS != "" {
+		return NewRaw(
+			opt.PrimaryOptions,
+			append(
+				options,
+				OptionCA{CA: CrtFile(rootCaCrtFile.Name())},
+				OptionCAKey{CAKey: KeyFile(caKMS)},
+			)...,
+		)
+	}
+
 	rootCaKeyFile, disRCKF, err := stepin.NewTmpFile("stepin_root_ca_key_*.txt", opt.RootCaKey)
 	if err != nil {
 		return "", nil, nil, err
@@ -0,0 +1,120 @@
+package create
+
+import (
+	"fmt"
+	"github.com/allape/stepin/stepin"
+	"slices"
+)
+
+const DefaultRSASize BitSize = 2048
+
+var AllCurves = []Curve{
+	P256,
+	P384,
+	P521,
+	Ed25519,
+}
+
+// KeyTypeCurves
+// Curves of each key type, the first one is the default
+var KeyTypeCurves = map[KeyType][]Curve{
+	EC:  {P256, P384, P521},
+	OKP: {Ed25519},
+}
+
+// AllRSASizes
+// step-cli refuses RSA keys smaller than 2048 bits, and larger ones than 8192 bits take minutes to generate
+var AllRSASizes = []BitSize{2048, 3072, 4096, 8192}
+
+// KeySpec
+// Parameters of a key pair to generate, zero values are the defaults of step-cli:
+// EC with P-256, OKP with Ed25519 and RSA with 2048 bits
+type KeySpec struct {
+	KeyType KeyType `json:"keyType"`
+	Curve   Curve   `json:"curve"` // for EC and OKP only
+	Size    BitSize `json:"size"`  // for RSA only
+}
+
+// WithDefaults
+// Fill the zero values with the defaults of the key type
+func (s KeySpec) WithDefaults() KeySpec {
+	if s.KeyType == "" {
+		s.KeyType = EC
+	}
+	switch s.KeyType {
+	case EC, OKP:
+		if s.Curve == "" && len(KeyTypeCurves[s.KeyType]) > 0 {
+			s.Curve = KeyTypeCurves[s.KeyType][0]
+		}
+	case RSA:
+		if s.Size == 0 {
+			s.Size = DefaultRSASize
+		}
+	}
+	return s
+}
+
+// Validate
+// Reject unknown key types, curves of other key types, sizes of EC and OKP keys and curves of RSA keys,
+// which step-cli either refuses with a vague message or silently ignores
+func (s KeySpec) Validate() error {
+	s = s.WithDefaults()
+	switch s.KeyType {
+	case EC, OKP:
+		if !slices.Contains(KeyTypeCurves[s.KeyType], s.Curve) {
+			return fmt.Errorf("curve %s is not for %s keys, available: %v", s.Curve, s.KeyType, KeyTypeCurves[s.KeyType])
+		}
+		if s.Size != 0 {
+			return fmt.Errorf("size is for %s keys only, %s keys are sized by their curves", RSA, s.KeyType)
+		}
+	case RSA:
+		if s.Curve != "" {
+			return fmt.Errorf("curve is not for %s keys", RSA)
+		}
+		if !slices.Contains(AllRSASizes, s.Size) {
+			return fmt.Errorf("rsa key of %d bits is not supported, available: %v", s.Size, AllRSASizes)
+		}
+	default:
+		return fmt.Errorf("invalid key type: %s", s.KeyType)
+	}
+	return nil
+}
+
+// Options
+// Options of the fields set, nothing for the zero value so step-cli takes its defaults
+func (s KeySpec) Options() []stepin.CommandOption {
+	var options []stepin.CommandOption
+	if s.KeyType != "" {
+		options = append(options, OptionKeyType{KTY: s.KeyType})
+	}
+	if s.Curve != "" {
+		options = append(options, OptionCurve{Curve: s.Curve})
+	}
+	if s.Size != 0 {
+		options = append(options, OptionSize{Size: s.Size})
+	}
+	return options
+}
+
+// KeySpecOf
+// Collect the key spec from OptionKeyType, OptionCurve and OptionSize, the last one of each wins
+func KeySpecOf(options []stepin.CommandOption) KeySpec {
+	var spec KeySpec
+	for _, option := range options {
+		switch o := option.(type) {
+		case OptionKeyType:
+			spec.KeyType = o.KTY
+		case OptionCurve:
+			spec.Curve = o.Curve
+		case OptionSize:
+			spec.Size = o.Size
+		}
+	}
+	return spec
+}
+
+// ValidateKeyOptions
+// Validate the combination of key type, curve and size in the options before any key is generated
+func ValidateKeyOptions(options []stepin.CommandOption) error {
+	return KeySpecOf(options).Validate()
+}
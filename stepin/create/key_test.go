@@ -0,0 +1,42 @@
+package create
+
+import (
+	"testing"
+)
+
+func TestKeySpec_Validate(t *testing.T) {
+	for _, spec := range []KeySpec{
+		{},
+		{KeyType: EC, Curve: P384},
+		{Curve: P521},
+		{KeyType: OKP},
+		{KeyType: RSA, Size: 4096},
+		{KeyType: RSA},
+	} {
+		if err := spec.Validate(); err != nil {
+			t.Fatalf("%+v should be valid: %v", spec, err)
+		}
+	}
+
+	for _, spec := range []KeySpec{
+		{KeyType: "DSA"},
+		{KeyType: EC, Curve: Ed25519},
+		{KeyType: OKP, Curve: P256},
+		{KeyType: OKP, Size: 2048},
+		{Size: 256},
+		{KeyType: RSA, Curve: P256},
+		{KeyType: RSA, Size: 1024},
+	} {
+		if err := spec.Validate(); err == nil {
+			t.Fatalf("%+v should be invalid", spec)
+		}
+	}
+
+	spec := KeySpecOf(KeySpec{KeyType: RSA, Size: 3072}.Options())
+	if spec.KeyType != RSA || spec.Curve != "" || spec.Size != 3072 {
+		t.Fatalf("unexpected spec from options: %+v", spec)
+	}
+	if len(KeySpec{}.Options()) != 0 {
+		t.Fatal("zero spec should add no option")
+	}
+}
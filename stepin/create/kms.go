@@ -0,0 +1,47 @@
+package create
+
+import (
+	"fmt"
+	"github.com/allape/stepin/stepin"
+	"go.step.sm/crypto/kms/uri"
+)
+
+// KMSSchemePKCS11
+// The only KMS supported, a key in a PKCS#11 token is addressed by a single URI of both the token and the key, e.g.
+// pkcs11:module-path=/usr/lib/softhsm/libsofthsm2.so;token=stepin;id=01;object=root-ca?pin-source=/run/secrets/pkcs11-pin,
+// which is given to step-cli as both --kms and --key, or --ca-kms and --ca-key
+const KMSSchemePKCS11 = "pkcs11"
+
+// ValidateKMSURI
+// A pkcs11 URI with the token and the id or object of the key,
+// pin-value is refused so that the PIN never lands in the database or the backups, put it in the file of pin-source instead
+func ValidateKMSURI(kms URI) error {
+	u, err := uri.ParseWithScheme(KMSSchemePKCS11, string(kms))
+	if err != nil {
+		return fmt.Errorf("invalid kms uri %s: %w", kms, err)
+	}
+	if !u.Has("token") && !u.Has("serial") && !u.Has("slot-id") {
+		return fmt.Errorf("kms uri %s should have token, serial or slot-id", kms)
+	}
+	if !u.Has("id") && !u.Has("object") {
+		return fmt.Errorf("kms uri %s should have id or object of the key", kms)
+	}
+	if u.Has("pin-value") {
+		return fmt.Errorf("pin-value in kms uri is not allowed, use pin-source instead")
+	}
+	return nil
+}
+
+// KMSOf
+// URIs of OptionKMS and OptionCAKMS in options, empty if absent
+func KMSOf(options []stepin.CommandOption) (kms URI, caKMS URI) {
+	for _, option := range options {
+		switch o := option.(type) {
+		case OptionKMS:
+			kms = o.KMS
+		case OptionCAKMS:
+			caKMS = o.CAKMS
+		}
+	}
+	return kms, caKMS
+}
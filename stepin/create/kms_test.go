@@ -0,0 +1,28 @@
+package create
+
+import (
+	"testing"
+)
+
+func TestValidateKMSURI(t *testing.T) {
+	for _, kms := range []URI{
+		"pkcs11:module-path=/usr/lib/softhsm/libsofthsm2.so;token=stepin;id=01;object=root-ca?pin-source=/run/secrets/pkcs11-pin",
+		"pkcs11:slot-id=0;object=root-ca",
+	} {
+		if err := ValidateKMSURI(kms); err != nil {
+			t.Fatalf("%s should be valid: %v", kms, err)
+		}
+	}
+
+	for _, kms := range []URI{
+		"",
+		"awskms:key-id=1234",
+		"pkcs11:id=01;object=root-ca",
+		"pkcs11:token=stepin",
+		"pkcs11:token=stepin;id=01?pin-value=1234",
+	} {
+		if err := ValidateKMSURI(kms); err == nil {
+			t.Fatalf("%s should be invalid", kms)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package create
+
+import (
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var dnsNameRegexp = regexp.MustCompile(`^(\*\.)?([a-zA-Z0-9_]([a-zA-Z0-9_-]{0,61}[a-zA-Z0-9_])?\.)*[a-zA-Z0-9_]([a-zA-Z0-9_-]{0,61}[a-zA-Z0-9_])?\.?$`)
+
+// SubjectAlternativeNames
+// Typed SAN records, every record will be checked against its type before being passed to `--san`.
+type SubjectAlternativeNames struct {
+	DNSNames       []string `json:"dnsNames"`
+	IPAddresses    []string `json:"ipAddresses"`
+	EmailAddresses []string `json:"emailAddresses"`
+	URIs           []string `json:"uris"`
+}
+
+// IsDNSName
+// IP addresses match the pattern of DNS names as well, so they are ruled out
+func IsDNSName(name string) bool {
+	return len(name) <= 253 && dnsNameRegexp.MatchString(name) && !IsIPAddress(name)
+}
+
+func IsIPAddress(ip string) bool {
+	return net.ParseIP(ip) != nil
+}
+
+func IsEmailAddress(email string) bool {
+	address, err := mail.ParseAddress(email)
+	return err == nil && address.Name == "" && address.Address == email
+}
+
+func IsURI(uri string) bool {
+	u, err := url.Parse(uri)
+	return err == nil && u.Scheme != "" && (u.Host != "" || u.Opaque != "")
+}
+
+func (s SubjectAlternativeNames) Trim() SubjectAlternativeNames {
+	return SubjectAlternativeNames{
+		DNSNames:       trimAll(s.DNSNames),
+		IPAddresses:    trimAll(s.IPAddresses),
+		EmailAddresses: trimAll(s.EmailAddresses),
+		URIs:           trimAll(s.URIs),
+	}
+}
+
+func (s SubjectAlternativeNames) Validate() error {
+	for _, name := range s.DNSNames {
+		if !IsDNSName(name) {
+			return fmt.Errorf("invalid dns name: %s", name)
+		}
+	}
+	for _, ip := range s.IPAddresses {
+		if !IsIPAddress(ip) {
+			return fmt.Errorf("invalid ip address: %s", ip)
+		}
+	}
+	for _, email := range s.EmailAddresses {
+		if !IsEmailAddress(email) {
+			return fmt.Errorf("invalid email address: %s", email)
+		}
+	}
+	for _, uri := range s.URIs {
+		if !IsURI(uri) {
+			return fmt.Errorf("invalid uri: %s", uri)
+		}
+	}
+	return nil
+}
+
+func (s SubjectAlternativeNames) IsEmpty() bool {
+	return len(s.DNSNames) == 0 && len(s.IPAddresses) == 0 && len(s.EmailAddresses) == 0 && len(s.URIs) == 0
+}
+
+// ToSANs
+// Flatten all records into the form `--san` accepts, duplicated records will be removed.
+func (s SubjectAlternativeNames) ToSANs() []SAN {
+	var sans []SAN
+	for _, records := range [][]string{s.DNSNames, s.IPAddresses, s.EmailAddresses, s.URIs} {
+		for _, record := range records {
+			if !slices.Contains(sans, SAN(record)) {
+				sans = append(sans, SAN(record))
+			}
+		}
+	}
+	return sans
+}
+
+func trimAll(records []string) []string {
+	var trimmed []string
+	for _, record := range records {
+		record = strings.TrimSpace(record)
+		if record != "" {
+			trimmed = append(trimmed, record)
+		}
+	}
+	return trimmed
+}
+
+// WithSubject
+// step-cli only uses the subject as SAN when no `--san` is provided,
+// so the subject will be added back if it is a valid SAN record.
+func (s SubjectAlternativeNames) WithSubject(subject SubjectName) SubjectAlternativeNames {
+	name := string(subject)
+	switch {
+	case slices.Contains(s.ToSANs(), SAN(name)):
+	case IsIPAddress(name):
+		s.IPAddresses = append([]string{name}, s.IPAddresses...)
+	case IsEmailAddress(name):
+		s.EmailAddresses = append([]string{name}, s.EmailAddresses...)
+	case IsDNSName(name):
+		s.DNSNames = append([]string{name}, s.DNSNames...)
+	}
+	return s
+}
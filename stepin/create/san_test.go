@@ -0,0 +1,121 @@
+package create
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestSubjectAlternativeNames_Validate(t *testing.T) {
+	cases := []struct {
+		name  string
+		sans  SubjectAlternativeNames
+		valid bool
+	}{
+		{"empty", SubjectAlternativeNames{}, true},
+		{"dns", SubjectAlternativeNames{DNSNames: []string{"example.internal", "*.example.internal", "localhost", "fqdn.example.internal."}}, true},
+		{"ip", SubjectAlternativeNames{IPAddresses: []string{"10.0.0.1", "::1", "fd00::1"}}, true},
+		{"email", SubjectAlternativeNames{EmailAddresses: []string{"admin@example.internal"}}, true},
+		{"uri", SubjectAlternativeNames{URIs: []string{"spiffe://example.internal/web", "https://example.internal", "urn:uuid:0b5e4a4c-6d1a-4a0e-9c53-3d5d5e0c7c2b"}}, true},
+		{"dns with space", SubjectAlternativeNames{DNSNames: []string{"example internal"}}, false},
+		{"dns with nested wildcard", SubjectAlternativeNames{DNSNames: []string{"*.*.example.internal"}}, false},
+		{"dns with leading hyphen", SubjectAlternativeNames{DNSNames: []string{"-example.internal"}}, false},
+		{"dns label too long", SubjectAlternativeNames{DNSNames: []string{"a234567890123456789012345678901234567890123456789012345678901234.internal"}}, false},
+		{"ip in dns names", SubjectAlternativeNames{DNSNames: []string{"10.0.0.1"}}, false},
+		{"ip out of range", SubjectAlternativeNames{IPAddresses: []string{"10.0.0.256"}}, false},
+		{"ip as dns", SubjectAlternativeNames{IPAddresses: []string{"example.internal"}}, false},
+		{"email with name", SubjectAlternativeNames{EmailAddresses: []string{"Admin <admin@example.internal>"}}, false},
+		{"email without domain", SubjectAlternativeNames{EmailAddresses: []string{"admin"}}, false},
+		{"uri without scheme", SubjectAlternativeNames{URIs: []string{"example.internal/web"}}, false},
+		{"uri without host", SubjectAlternativeNames{URIs: []string{"https://"}}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := c.sans.Validate()
+			if c.valid && err != nil {
+				t.Fatalf("%+v should be valid: %v", c.sans, err)
+			} else if !c.valid && err == nil {
+				t.Fatalf("%+v should be invalid", c.sans)
+			}
+		})
+	}
+}
+
+func TestSubjectAlternativeNames_WithSubject(t *testing.T) {
+	sans := SubjectAlternativeNames{
+		DNSNames:    []string{"www.example.internal"},
+		IPAddresses: []string{"10.0.0.1"},
+	}
+
+	cases := []struct {
+		name    string
+		subject SubjectName
+		want    SubjectAlternativeNames
+	}{
+		{"dns", "example.internal", SubjectAlternativeNames{
+			DNSNames:    []string{"example.internal", "www.example.internal"},
+			IPAddresses: []string{"10.0.0.1"},
+		}},
+		{"ip", "10.0.0.2", SubjectAlternativeNames{
+			DNSNames:    []string{"www.example.internal"},
+			IPAddresses: []string{"10.0.0.2", "10.0.0.1"},
+		}},
+		{"email", "admin@example.internal", SubjectAlternativeNames{
+			DNSNames:       []string{"www.example.internal"},
+			IPAddresses:    []string{"10.0.0.1"},
+			EmailAddresses: []string{"admin@example.internal"},
+		}},
+		{"duplicated dns", "www.example.internal", sans},
+		{"duplicated ip", "10.0.0.1", sans},
+		{"uri is not added", "spiffe://example.internal/web", sans},
+		{"plain name is not added", "Example Root CA", sans},
+		{"empty", "", sans},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := sans.WithSubject(c.subject)
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("got %+v, want %+v", got, c.want)
+			}
+		})
+	}
+
+	if len(sans.DNSNames) != 1 || len(sans.IPAddresses) != 1 {
+		t.Fatalf("original sans should not be modified: %+v", sans)
+	}
+}
+
+func TestSubjectAlternativeNames_ToSANs(t *testing.T) {
+	cases := []struct {
+		name string
+		sans SubjectAlternativeNames
+		want []SAN
+	}{
+		{"empty", SubjectAlternativeNames{}, nil},
+		{"in order of types", SubjectAlternativeNames{
+			DNSNames:       []string{"example.internal"},
+			IPAddresses:    []string{"10.0.0.1"},
+			EmailAddresses: []string{"admin@example.internal"},
+			URIs:           []string{"spiffe://example.internal/web"},
+		}, []SAN{"example.internal", "10.0.0.1", "admin@example.internal", "spiffe://example.internal/web"}},
+		{"duplicated", SubjectAlternativeNames{
+			DNSNames:    []string{"example.internal", "www.example.internal", "example.internal"},
+			IPAddresses: []string{"10.0.0.1", "10.0.0.1"},
+		}, []SAN{"example.internal", "www.example.internal", "10.0.0.1"}},
+		{"trimmed", SubjectAlternativeNames{
+			DNSNames:    []string{" example.internal ", "", "  "},
+			IPAddresses: []string{"10.0.0.1\n"},
+		}.Trim(), []SAN{"example.internal", "10.0.0.1"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := c.sans.ToSANs()
+			if !slices.Equal(got, c.want) {
+				t.Fatalf("got %v, want %v", got, c.want)
+			}
+			if c.sans.IsEmpty() != (len(c.want) == 0) {
+				t.Fatalf("unexpected emptiness of %+v", c.sans)
+			}
+		})
+	}
+}
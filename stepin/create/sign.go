@@ -0,0 +1,150 @@
+package create
+
+// https://smallstep.com/docs/step-cli/reference/certificate/sign/#usage
+
+import (
+	"encoding/pem"
+	"fmt"
+	"github.com/allape/stepin/stepin"
+	"slices"
+)
+
+type CSR []byte // Certificate signing request content
+
+type SignOptions struct {
+	CSR          CSR      `json:"csr"`
+	RootCaCrt    Crt      `json:"rootCaCrt"`
+	RootCaKey    Key      `json:"rootCaKey"`
+	RootPassword Password `json:"rootPassword"`
+}
+
+// SignCSR
+// Sign a certificate signing request with a CA, the profile is leaf unless OptionProfile is specified.
+// Only OptionProfile, OptionTemplate, OptionSet, OptionSetFile, OptionNotBefore, OptionNotAfter, OptionBundle
+// and OptionCAKMS for a CA key in a KMS instead of RootCaKey make sense here.
+func SignCSR(opt SignOptions, options ...stepin.CommandOption) (stepin.Inspection, Crt, error) {
+	if !slices.ContainsFunc(options, func(option stepin.CommandOption) bool {
+		_, ok := option.(OptionProfile)
+		return ok
+	}) {
+		options = append(options, OptionProfile{Profile: Leaf})
+	}
+
+	options, disposeTemplateFile, err := withInjectedTemplate(options)
+	if err != nil {
+		return "", nil, err
+	}
+	if disposeTemplateFile != nil {
+		defer func() {
+			_ = disposeTemplateFile()
+		}()
+	}
+
+	csrFile, disCF, err := stepin.NewTmpFile("stepin_csr_*.csr", opt.CSR)
+	if err != nil {
+		return "", nil, err
+	}
+	defer func() {
+		_ = disCF()
+	}()
+	_ = csrFile.Close()
+
+	caCrtFile, disCCF, err := stepin.NewTmpFile("stepin_ca_crt_*.txt", opt.RootCaCrt)
+	if err != nil {
+		return "", nil, err
+	}
+	defer func() {
+		_ = disCCF()
+	}()
+	_ = caCrtFile.Close()
+
+	commander := &stepin.Commander{
+		Executable: "step",
+		Arguments:  []string{csrFile.Name(), caCrtFile.Name()},
+	}
+
+	if _, caKMS := KMSOf(options); caKMS != "" {
+		err = ValidateKMSURI(caKMS)
+		if err != nil {
+			return "", nil, err
+		}
+		// `step certificate sign` takes the KMS of the CA key with --kms
+		options = slices.DeleteFunc(slices.Clone(options), func(option stepin.CommandOption) bool {
+			_, ok := option.(OptionCAKMS)
+			return ok
+		})
+		options = append(options, OptionKMS{KMS: caKMS})
+		commander.Arguments = append(commander.Arguments, string(caKMS))
+	} else {
+		caKeyFile, disCKF, err := stepin.NewTmpFile("stepin_ca_key_*.txt", opt.RootCaKey)
+		if err != nil {
+			return "", nil, err
+		}
+		defer func() {
+			_ = disCKF()
+		}()
+		_ = caKeyFile.Close()
+
+		caPasswordFile, disCPF, err := stepin.NewTmpFile("stepin_ca_password_*.txt", []byte(opt.RootPassword))
+		if err != nil {
+			return "", nil, err
+		}
+		defer func() {
+			_ = disCPF()
+		}()
+		_ = caPasswordFile.Close()
+
+		commander.Arguments = append(commander.Arguments, caKeyFile.Name())
+
+		// `step certificate sign` decrypts the CA key with --password-file
+		options = append(options, OptionPasswordFile{PasswordFile: PasswordFile(caPasswordFile.Name())})
+	}
+
+	for _, option := range options {
+		commander, err = option.Apply(commander)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	output, err := stepin.Exec(
+		commander.Executable,
+		append([]string{
+			"certificate",
+			"sign",
+		}, commander.Arguments...)...,
+	)
+	if err != nil {
+		return "", nil, err
+	}
+
+	crt := PEMCertificates([]byte(output))
+	if len(crt) == 0 {
+		return "", nil, fmt.Errorf("no certificate found in output of step-cli")
+	}
+
+	inspection, err := StepCLI{}.Inspect(crt, false, stepin.OptionCommandBin{
+		CommandBin: commander.Executable,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	return inspection, crt, nil
+}
+
+// PEMCertificates
+// Extract PEM encoded certificates from content, anything else is dropped
+func PEMCertificates(content []byte) Crt {
+	var crt Crt
+	for {
+		var block *pem.Block
+		block, content = pem.Decode(content)
+		if block == nil {
+			return crt
+		}
+		if block.Type == "CERTIFICATE" {
+			crt = append(crt, pem.EncodeToMemory(block)...)
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package create
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/allape/stepin/stepin"
+	"go.step.sm/crypto/x509util"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// region options not in the official documentation, they are injected into the certificate template
+
+type OptionCRLDistributionPoints struct {
+	stepin.CommandOption
+	CRLDistributionPoints []URI `json:"crlDistributionPoints"`
+}
+
+func (o OptionCRLDistributionPoints) Apply(commander *stepin.Commander) (*stepin.Commander, error) {
+	return commander, nil
+}
+
+type OptionOCSPServer struct {
+	stepin.CommandOption
+	OCSPServer []URI `json:"ocspServer"`
+}
+
+func (o OptionOCSPServer) Apply(commander *stepin.Commander) (*stepin.Commander, error) {
+	return commander, nil
+}
+
+type OptionNameConstraints struct {
+	stepin.CommandOption
+	NameConstraints NameConstraints `json:"nameConstraints"`
+}
+
+func (o OptionNameConstraints) Apply(commander *stepin.Commander) (*stepin.Commander, error) {
+	return commander, nil
+}
+
+type OptionMaxPathLen struct {
+	stepin.CommandOption
+	MaxPathLen int `json:"maxPathLen"` // -1 for unlimited
+}
+
+func (o OptionMaxPathLen) Apply(commander *stepin.Commander) (*stepin.Commander, error) {
+	return commander, nil
+}
+
+// endregion options not in the official documentation, they are injected into the certificate template
+
+// NameConstraints
+// Names a CA and the CAs under it are allowed to sign, the extension is always critical.
+// DNS and URI domains are like team-a.internal, which includes its subdomains,
+// or .team-a.internal and *.team-a.internal for the subdomains only. IP ranges are CIDRs.
+type NameConstraints struct {
+	PermittedDNSDomains     []string `json:"permittedDNSDomains"`
+	ExcludedDNSDomains      []string `json:"excludedDNSDomains"`
+	PermittedIPRanges       []string `json:"permittedIPRanges"`
+	ExcludedIPRanges        []string `json:"excludedIPRanges"`
+	PermittedEmailAddresses []string `json:"permittedEmailAddresses"` // an address, a host or a domain starting with a dot
+	ExcludedEmailAddresses  []string `json:"excludedEmailAddresses"`
+	PermittedURIDomains     []string `json:"permittedURIDomains"`
+	ExcludedURIDomains      []string `json:"excludedURIDomains"`
+}
+
+func (c NameConstraints) IsEmpty() bool {
+	return len(c.PermittedDNSDomains) == 0 && len(c.ExcludedDNSDomains) == 0 &&
+		len(c.PermittedIPRanges) == 0 && len(c.ExcludedIPRanges) == 0 &&
+		len(c.PermittedEmailAddresses) == 0 && len(c.ExcludedEmailAddresses) == 0 &&
+		len(c.PermittedURIDomains) == 0 && len(c.ExcludedURIDomains) == 0
+}
+
+// Normalize
+// Trim all names and replace the leading `*` of domains with `.`
+func (c NameConstraints) Normalize() NameConstraints {
+	domains := func(names []string) []string {
+		normalized := make([]string, 0, len(names))
+		for _, name := range names {
+			name = strings.TrimSpace(name)
+			if strings.HasPrefix(name, "*.") {
+				name = name[1:]
+			}
+			if name != "" {
+				normalized = append(normalized, name)
+			}
+		}
+		return normalized
+	}
+	return NameConstraints{
+		PermittedDNSDomains:     domains(c.PermittedDNSDomains),
+		ExcludedDNSDomains:      domains(c.ExcludedDNSDomains),
+		PermittedIPRanges:       domains(c.PermittedIPRanges),
+		ExcludedIPRanges:        domains(c.ExcludedIPRanges),
+		PermittedEmailAddresses: domains(c.PermittedEmailAddresses),
+		ExcludedEmailAddresses:  domains(c.ExcludedEmailAddresses),
+		PermittedURIDomains:     domains(c.PermittedURIDomains),
+		ExcludedURIDomains:      domains(c.ExcludedURIDomains),
+	}
+}
+
+// Validate
+// Check the normalized constraints
+func (c NameConstraints) Validate() error {
+	for _, domain := range slices.Concat(c.PermittedDNSDomains, c.ExcludedDNSDomains, c.PermittedURIDomains, c.ExcludedURIDomains) {
+		if !IsDNSName(strings.TrimPrefix(domain, ".")) {
+			return fmt.Errorf("invalid domain constraint: %s", domain)
+		}
+	}
+	for _, cidr := range slices.Concat(c.PermittedIPRanges, c.ExcludedIPRanges) {
+		_, _, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid ip range constraint %s: %w", cidr, err)
+		}
+	}
+	for _, email := range slices.Concat(c.PermittedEmailAddresses, c.ExcludedEmailAddresses) {
+		if !IsEmailAddress(email) && !IsDNSName(strings.TrimPrefix(email, ".")) {
+			return fmt.Errorf("invalid email constraint: %s", email)
+		}
+	}
+	return nil
+}
+
+// ConstraintOptions
+// OptionNameConstraints and OptionMaxPathLen of the ones set, e.g. to carry the constraints of a CA over to its renewal
+func ConstraintOptions(nameConstraints *NameConstraints, maxPathLen *int) []stepin.CommandOption {
+	var options []stepin.CommandOption
+	if nameConstraints != nil && !nameConstraints.IsEmpty() {
+		options = append(options, OptionNameConstraints{NameConstraints: *nameConstraints})
+	}
+	if maxPathLen != nil {
+		options = append(options, OptionMaxPathLen{MaxPathLen: *maxPathLen})
+	}
+	return options
+}
+
+// DefaultTemplate
+// The template step-cli uses for the profile when `--template` is absent
+func DefaultTemplate(profile Profile) (string, error) {
+	switch profile {
+	case RootCA:
+		return x509util.DefaultRootTemplate, nil
+	case IntermediateCA:
+		return x509util.DefaultIntermediateTemplate, nil
+	case Leaf, SelfSigned:
+		return x509util.DefaultLeafTemplate, nil
+	default:
+		return "", fmt.Errorf("invalid certificate profile: %s", profile)
+	}
+}
+
+// TemplateFields
+// Collect certificate template fields from options which are not flags of step-cli
+func TemplateFields(options []stepin.CommandOption) map[string]any {
+	fields := map[string]any{}
+	for _, option := range options {
+		switch o := option.(type) {
+		case OptionCRLDistributionPoints:
+			if len(o.CRLDistributionPoints) > 0 {
+				fields["crlDistributionPoints"] = o.CRLDistributionPoints
+			}
+		case OptionOCSPServer:
+			if len(o.OCSPServer) > 0 {
+				fields["ocspServer"] = o.OCSPServer
+			}
+		}
+	}
+	return fields
+}
+
+// TemplateOverrides
+// Collect certificate template fields which take precedence over the ones in the template, they are for CAs only
+func TemplateOverrides(options []stepin.CommandOption) (map[string]any, error) {
+	var profile Profile
+	fields := map[string]any{}
+	for _, option := range options {
+		switch o := option.(type) {
+		case OptionProfile:
+			profile = o.Profile
+		case OptionNameConstraints:
+			if !o.NameConstraints.IsEmpty() {
+				constraints := o.NameConstraints.Normalize()
+				err := constraints.Validate()
+				if err != nil {
+					return nil, err
+				}
+				fields["nameConstraints"] = map[string]any{
+					"critical":                true,
+					"permittedDNSDomains":     constraints.PermittedDNSDomains,
+					"excludedDNSDomains":      constraints.ExcludedDNSDomains,
+					"permittedIPRanges":       constraints.PermittedIPRanges,
+					"excludedIPRanges":        constraints.ExcludedIPRanges,
+					"permittedEmailAddresses": constraints.PermittedEmailAddresses,
+					"excludedEmailAddresses":  constraints.ExcludedEmailAddresses,
+					"permittedURIDomains":     constraints.PermittedURIDomains,
+					"excludedURIDomains":      constraints.ExcludedURIDomains,
+				}
+			}
+		case OptionMaxPathLen:
+			if o.MaxPathLen < -1 {
+				return nil, fmt.Errorf("invalid max path length: %d", o.MaxPathLen)
+			}
+			fields["basicConstraints"] = map[string]any{
+				"isCA":       true,
+				"maxPathLen": o.MaxPathLen,
+			}
+		}
+	}
+	if len(fields) > 0 && profile != RootCA && profile != IntermediateCA {
+		return nil, fmt.Errorf("name constraints and max path length are for CAs only")
+	}
+	return fields, nil
+}
+
+// InjectTemplate
+// Insert fields at the beginning of the root JSON object of a template,
+// fields defined by the template itself take precedence as the latter key wins while decoding.
+func InjectTemplate(template string, fields map[string]any) (string, error) {
+	if len(fields) == 0 {
+		return template, nil
+	}
+
+	start := -1
+	for i := 0; i < len(template); i++ {
+		if template[i] != '{' {
+			continue
+		}
+		if i+1 < len(template) && template[i+1] == '{' {
+			i++
+			continue
+		}
+		start = i
+		break
+	}
+	if start == -1 {
+		return "", fmt.Errorf("invalid template: root object not found")
+	}
+
+	lines, err := templateLines(fields)
+	if err != nil {
+		return "", err
+	}
+
+	injected := strings.Join(lines, ",")
+	rest := template[start+1:]
+	if !strings.HasPrefix(strings.TrimSpace(rest), "}") {
+		injected += ","
+	}
+
+	return template[:start+1] + injected + rest, nil
+}
+
+// templateLines
+// Lines of the fields sorted by keys
+func templateLines(fields map[string]any) ([]string, error) {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	lines := make([]string, 0, len(keys))
+	for _, key := range keys {
+		value, err := json.Marshal(fields[key])
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, fmt.Sprintf("\n\t%q: %s", key, value))
+	}
+	return lines, nil
+}
+
+// AppendTemplate
+// Insert fields at the end of the root JSON object of a template, they take precedence over the ones defined by the template.
+func AppendTemplate(template string, fields map[string]any) (string, error) {
+	if len(fields) == 0 {
+		return template, nil
+	}
+
+	trimmed := strings.TrimRightFunc(template, unicode.IsSpace)
+	end := len(trimmed) - 1
+	if end < 1 || trimmed[end] != '}' || trimmed[end-1] == '}' {
+		return "", fmt.Errorf("invalid template: root object not found")
+	}
+
+	lines, err := templateLines(fields)
+	if err != nil {
+		return "", err
+	}
+
+	injected := strings.Join(lines, ",") + "\n"
+	if !strings.HasSuffix(strings.TrimRightFunc(trimmed[:end], unicode.IsSpace), "{") {
+		injected = "," + injected
+	}
+
+	return trimmed[:end] + injected + template[end:], nil
+}
+
+// withInjectedTemplate
+// step-cli can not take template fields from flags, so a template file will be rendered with them.
+func withInjectedTemplate(options []stepin.CommandOption) ([]stepin.CommandOption, stepin.DisposeFunc, error) {
+	fields := TemplateFields(options)
+	overrides, err := TemplateOverrides(options)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(fields) == 0 && len(overrides) == 0 {
+		return options, nil, nil
+	}
+
+	var (
+		template string
+		profile  Profile
+		others   []stepin.CommandOption
+	)
+	for _, option := range options {
+		switch o := option.(type) {
+		case OptionProfile:
+			profile = o.Profile
+		case OptionNameConstraints, OptionMaxPathLen:
+			continue
+		case OptionTemplate:
+			if o.Template != "" {
+				content, err := os.ReadFile(string(o.Template))
+				if err != nil {
+					return nil, nil, err
+				}
+				template = string(content)
+				continue
+			}
+		}
+		others = append(others, option)
+	}
+
+	if template == "" {
+		template, err = DefaultTemplate(profile)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	template, err = InjectTemplate(template, fields)
+	if err != nil {
+		return nil, nil, err
+	}
+	template, err = AppendTemplate(template, overrides)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	templateFile, dispose, err := stepin.NewTmpFile("stepin_template_*.tpl", []byte(template))
+	if err != nil {
+		return nil, nil, err
+	}
+	_ = templateFile.Close()
+
+	return append(others, OptionTemplate{Template: FilePath(templateFile.Name())}), dispose, nil
+}
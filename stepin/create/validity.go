@@ -0,0 +1,123 @@
+package create
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var iso8601Duration = regexp.MustCompile(`^P(?:(\d+)Y)?(?:(\d+)M)?(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+// Duration
+// Go-style like 2160h30m, or ISO-8601 like P90D, P1Y6M or PT12H,
+// whose years, months, weeks and days follow the calendar instead of being multiples of 24 hours
+type Duration string
+
+// AddTo
+// The time at the end of the duration starting from t
+func (d Duration) AddTo(t time.Time) (time.Time, error) {
+	s := strings.TrimSpace(string(d))
+	if s == "" {
+		return time.Time{}, fmt.Errorf("empty duration")
+	}
+
+	if !strings.HasPrefix(strings.ToUpper(s), "P") {
+		duration, err := time.ParseDuration(s)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid duration %s: %w", d, err)
+		}
+		if duration <= 0 {
+			return time.Time{}, fmt.Errorf("duration %s should be positive", d)
+		}
+		return t.Add(duration), nil
+	}
+
+	s = strings.ToUpper(s)
+	matches := iso8601Duration.FindStringSubmatch(s)
+	if matches == nil || s == "P" || strings.HasSuffix(s, "T") {
+		return time.Time{}, fmt.Errorf("invalid iso-8601 duration %s", d)
+	}
+
+	var numbers [6]int
+	for i := range numbers {
+		if matches[i+1] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(matches[i+1])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid iso-8601 duration %s: %w", d, err)
+		}
+		numbers[i] = n
+	}
+	var seconds float64
+	if matches[7] != "" {
+		var err error
+		seconds, err = strconv.ParseFloat(matches[7], 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid iso-8601 duration %s: %w", d, err)
+		}
+	}
+
+	end := t.AddDate(numbers[0], numbers[1], numbers[2]*7+numbers[3]).
+		Add(time.Duration(numbers[4])*time.Hour + time.Duration(numbers[5])*time.Minute + time.Duration(seconds*float64(time.Second)))
+	if !end.After(t) {
+		return time.Time{}, fmt.Errorf("duration %s should be positive", d)
+	}
+	return end, nil
+}
+
+// Validity
+// Validity window of a cert to create, all zero for the defaults of the backend.
+// NotAfter and Duration are exclusive, Duration counts from NotBefore, which is now if absent.
+type Validity struct {
+	NotBefore *time.Time `json:"notBefore"` // set it in the past to tolerate clock skew of the clients
+	NotAfter  *time.Time `json:"notAfter"`
+	Duration  Duration   `json:"duration"`
+}
+
+func (v Validity) IsZero() bool {
+	return v.NotBefore == nil && v.NotAfter == nil && v.Duration == ""
+}
+
+// Window
+// Resolve the window at now, notAfter is zero if neither NotAfter nor Duration is set
+func (v Validity) Window(now time.Time) (notBefore, notAfter time.Time, err error) {
+	notBefore = now
+	if v.NotBefore != nil {
+		notBefore = *v.NotBefore
+	}
+
+	switch {
+	case v.NotAfter != nil && v.Duration != "":
+		return time.Time{}, time.Time{}, fmt.Errorf("notAfter and duration are exclusive")
+	case v.NotAfter != nil:
+		notAfter = *v.NotAfter
+	case v.Duration != "":
+		notAfter, err = v.Duration.AddTo(notBefore)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+
+	if !notAfter.IsZero() {
+		if !notBefore.Before(notAfter) {
+			return time.Time{}, time.Time{}, fmt.Errorf("notBefore should be before notAfter")
+		}
+		if !notAfter.After(now) {
+			return time.Time{}, time.Time{}, fmt.Errorf("notAfter should be in the future")
+		}
+	}
+
+	return notBefore, notAfter, nil
+}
+
+// ClampNotAfter
+// A cert can not outlive its issuer, notAfter is cut to caNotAfter if later
+func ClampNotAfter(notAfter, caNotAfter time.Time) time.Time {
+	if !caNotAfter.IsZero() && notAfter.After(caNotAfter) {
+		return caNotAfter
+	}
+	return notAfter
+}
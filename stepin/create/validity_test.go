@@ -0,0 +1,68 @@
+package create
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDuration_AddTo(t *testing.T) {
+	from := time.Date(2024, 2, 29, 12, 0, 0, 0, time.UTC)
+
+	for d, expected := range map[Duration]time.Time{
+		"2160h":      from.Add(2160 * time.Hour),
+		"90m30s":     from.Add(90*time.Minute + 30*time.Second),
+		"P90D":       time.Date(2024, 5, 29, 12, 0, 0, 0, time.UTC),
+		"P1Y":        time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC),
+		"P1Y2M":      time.Date(2025, 4, 29, 12, 0, 0, 0, time.UTC),
+		"P2W":        time.Date(2024, 3, 14, 12, 0, 0, 0, time.UTC),
+		"PT12H":      time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		"p1dt1h1m1s": time.Date(2024, 3, 1, 13, 1, 1, 0, time.UTC),
+	} {
+		end, err := d.AddTo(from)
+		if err != nil {
+			t.Fatalf("%s: %v", d, err)
+		}
+		if !end.Equal(expected) {
+			t.Fatalf("%s: expected %s, got %s", d, expected, end)
+		}
+	}
+
+	for _, d := range []Duration{"", "P", "PT", "P1H", "1d", "-1h", "0s", "P0D"} {
+		if _, err := d.AddTo(from); err == nil {
+			t.Fatalf("%q should be invalid", d)
+		}
+	}
+}
+
+func TestValidity_Window(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	backdated := now.Add(-5 * time.Minute)
+	later := now.AddDate(0, 0, 30)
+
+	notBefore, notAfter, err := Validity{NotBefore: &backdated, Duration: "P90D"}.Window(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !notBefore.Equal(backdated) || !notAfter.Equal(backdated.AddDate(0, 0, 90)) {
+		t.Fatalf("unexpected window: %s %s", notBefore, notAfter)
+	}
+
+	notBefore, notAfter, err = Validity{}.Window(now)
+	if err != nil || !notBefore.Equal(now) || !notAfter.IsZero() {
+		t.Fatalf("unexpected window: %s %s %v", notBefore, notAfter, err)
+	}
+
+	for _, v := range []Validity{
+		{NotAfter: &later, Duration: "P1D"},
+		{NotBefore: &later, NotAfter: &later},
+		{NotAfter: &backdated},
+	} {
+		if _, _, err = v.Window(now); err == nil {
+			t.Fatalf("%+v should be invalid", v)
+		}
+	}
+
+	if !ClampNotAfter(later, now).Equal(now) || !ClampNotAfter(now, later).Equal(now) {
+		t.Fatal("not after should be clamped to the ca")
+	}
+}
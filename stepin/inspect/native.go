@@ -0,0 +1,266 @@
+package inspect
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"github.com/allape/stepin/stepin"
+	"net"
+	"strings"
+	"time"
+)
+
+const validityTimeFormat = "Jan 2 15:04:05 2006 MST"
+
+var keyUsageNames = []struct {
+	usage x509.KeyUsage
+	name  string
+}{
+	{x509.KeyUsageDigitalSignature, "Digital Signature"},
+	{x509.KeyUsageContentCommitment, "Content Commitment"},
+	{x509.KeyUsageKeyEncipherment, "Key Encipherment"},
+	{x509.KeyUsageDataEncipherment, "Data Encipherment"},
+	{x509.KeyUsageKeyAgreement, "Key Agreement"},
+	{x509.KeyUsageCertSign, "Certificate Sign"},
+	{x509.KeyUsageCRLSign, "CRL Sign"},
+	{x509.KeyUsageEncipherOnly, "Encipher Only"},
+	{x509.KeyUsageDecipherOnly, "Decipher Only"},
+}
+
+var extKeyUsageNames = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageAny:             "Any Usage",
+	x509.ExtKeyUsageServerAuth:      "Server Authentication",
+	x509.ExtKeyUsageClientAuth:      "Client Authentication",
+	x509.ExtKeyUsageCodeSigning:     "Code Signing",
+	x509.ExtKeyUsageEmailProtection: "E-mail Protection",
+	x509.ExtKeyUsageTimeStamping:    "Time Stamping",
+	x509.ExtKeyUsageOCSPSigning:     "OCSP Signing",
+}
+
+// Native
+// Similar output to `step certificate inspect` without running step-cli,
+// only the first certificate in a bundle will be inspected, which is what step-cli does by default.
+func Native(crt []byte, short bool) (stepin.Inspection, error) {
+	block, _ := pem.Decode(crt)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return "", fmt.Errorf("no certificate found")
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "", err
+	}
+
+	if short {
+		return stepin.Inspection(shortText(cert)), nil
+	}
+	return stepin.Inspection(text(cert)), nil
+}
+
+func shortText(cert *x509.Certificate) string {
+	typ := "TLS"
+	if cert.IsCA {
+		if cert.CheckSignatureFrom(cert) == nil {
+			typ = "Root CA"
+		} else {
+			typ = "Intermediate CA"
+		}
+	}
+
+	var buf strings.Builder
+	_, _ = fmt.Fprintf(&buf, "X.509v3 %s Certificate (%s) [Serial: %s]\n", typ, publicKeyName(cert), cert.SerialNumber)
+	for i, name := range append([]string{cert.Subject.CommonName}, sanNames(cert)...) {
+		if i == 0 {
+			_, _ = fmt.Fprintf(&buf, "  Subject:     %s\n", name)
+		} else if name != cert.Subject.CommonName {
+			_, _ = fmt.Fprintf(&buf, "               %s\n", name)
+		}
+	}
+	_, _ = fmt.Fprintf(&buf, "  Issuer:      %s\n", cert.Issuer.CommonName)
+	_, _ = fmt.Fprintf(&buf, "  Valid from:  %s\n", cert.NotBefore.Format(time.RFC3339))
+	_, _ = fmt.Fprintf(&buf, "          to:  %s\n", cert.NotAfter.Format(time.RFC3339))
+	return buf.String()
+}
+
+func text(cert *x509.Certificate) string {
+	var buf strings.Builder
+	line := func(indent int, format string, args ...any) {
+		_, _ = fmt.Fprintf(&buf, "%s%s\n", strings.Repeat(" ", indent), fmt.Sprintf(format, args...))
+	}
+	critical := func(name string, ext pkix.Extension) {
+		if ext.Critical {
+			line(12, "%s: critical", name)
+		} else {
+			line(12, "%s:", name)
+		}
+	}
+
+	line(0, "Certificate:")
+	line(4, "Data:")
+	line(8, "Version: %d (%#x)", cert.Version, cert.Version-1)
+	line(8, "Serial Number: %d (%#x)", cert.SerialNumber, cert.SerialNumber)
+	line(4, "Signature Algorithm: %s", cert.SignatureAlgorithm)
+	line(8, "Issuer: %s", cert.Issuer)
+	line(8, "Validity")
+	line(12, "Not Before: %s", cert.NotBefore.UTC().Format(validityTimeFormat))
+	line(12, "Not After : %s", cert.NotAfter.UTC().Format(validityTimeFormat))
+	line(8, "Subject: %s", cert.Subject)
+	line(8, "Subject Public Key Info:")
+	line(12, "Public Key Algorithm: %s", cert.PublicKeyAlgorithm)
+	line(16, "Public-Key: (%s)", publicKeyName(cert))
+
+	if len(cert.Extensions) > 0 {
+		line(8, "X509v3 extensions:")
+	}
+	for _, ext := range cert.Extensions {
+		switch {
+		case ext.Id.Equal([]int{2, 5, 29, 15}):
+			critical("X509v3 Key Usage", ext)
+			var usages []string
+			for _, ku := range keyUsageNames {
+				if cert.KeyUsage&ku.usage != 0 {
+					usages = append(usages, ku.name)
+				}
+			}
+			line(16, "%s", strings.Join(usages, ", "))
+		case ext.Id.Equal([]int{2, 5, 29, 37}):
+			critical("X509v3 Extended Key Usage", ext)
+			var usages []string
+			for _, eku := range cert.ExtKeyUsage {
+				if name, ok := extKeyUsageNames[eku]; ok {
+					usages = append(usages, name)
+				} else {
+					usages = append(usages, fmt.Sprintf("%d", eku))
+				}
+			}
+			for _, oid := range cert.UnknownExtKeyUsage {
+				usages = append(usages, oid.String())
+			}
+			line(16, "%s", strings.Join(usages, ", "))
+		case ext.Id.Equal([]int{2, 5, 29, 19}):
+			critical("X509v3 Basic Constraints", ext)
+			constraints := "CA:FALSE"
+			if cert.IsCA {
+				constraints = "CA:TRUE"
+			}
+			if cert.MaxPathLen > 0 || cert.MaxPathLenZero {
+				constraints += fmt.Sprintf(", pathlen:%d", cert.MaxPathLen)
+			}
+			line(16, "%s", constraints)
+		case ext.Id.Equal([]int{2, 5, 29, 14}):
+			critical("X509v3 Subject Key Identifier", ext)
+			line(16, "%s", hexColon(cert.SubjectKeyId))
+		case ext.Id.Equal([]int{2, 5, 29, 35}):
+			critical("X509v3 Authority Key Identifier", ext)
+			line(16, "keyid:%s", hexColon(cert.AuthorityKeyId))
+		case ext.Id.Equal([]int{2, 5, 29, 17}):
+			critical("X509v3 Subject Alternative Name", ext)
+			line(16, "%s", strings.Join(typedSANNames(cert), ", "))
+		case ext.Id.Equal([]int{2, 5, 29, 30}):
+			critical("X509v3 Name Constraints", ext)
+			for _, c := range nameConstraints(cert) {
+				line(16, "%s", c)
+			}
+		case ext.Id.Equal([]int{2, 5, 29, 31}):
+			critical("X509v3 CRL Distribution Points", ext)
+			for _, point := range cert.CRLDistributionPoints {
+				line(16, "URI:%s", point)
+			}
+		case ext.Id.Equal([]int{1, 3, 6, 1, 5, 5, 7, 1, 1}):
+			critical("Authority Information Access", ext)
+			for _, server := range cert.OCSPServer {
+				line(16, "OCSP - URI:%s", server)
+			}
+			for _, issuer := range cert.IssuingCertificateURL {
+				line(16, "CA Issuers - URI:%s", issuer)
+			}
+		default:
+			critical(ext.Id.String(), ext)
+			line(16, "%s", hexColon(ext.Value))
+		}
+	}
+
+	line(4, "Signature Algorithm: %s", cert.SignatureAlgorithm)
+	line(9, "%s", hexColon(cert.Signature))
+
+	return buf.String()
+}
+
+func publicKeyName(cert *x509.Certificate) string {
+	switch key := cert.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		return fmt.Sprintf("ECDSA %s", key.Curve.Params().Name)
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %d", key.N.BitLen())
+	case ed25519.PublicKey:
+		return "Ed25519"
+	default:
+		return cert.PublicKeyAlgorithm.String()
+	}
+}
+
+func sanNames(cert *x509.Certificate) []string {
+	names := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	names = append(names, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	return names
+}
+
+func typedSANNames(cert *x509.Certificate) []string {
+	var names []string
+	for _, name := range cert.DNSNames {
+		names = append(names, "DNS:"+name)
+	}
+	for _, ip := range cert.IPAddresses {
+		names = append(names, "IP Address:"+ip.String())
+	}
+	for _, email := range cert.EmailAddresses {
+		names = append(names, "email:"+email)
+	}
+	for _, uri := range cert.URIs {
+		names = append(names, "URI:"+uri.String())
+	}
+	return names
+}
+
+func nameConstraints(cert *x509.Certificate) []string {
+	var constraints []string
+	add := func(kind, typ string, values []string) {
+		if len(values) > 0 {
+			constraints = append(constraints, fmt.Sprintf("%s %s: %s", kind, typ, strings.Join(values, ", ")))
+		}
+	}
+	ranges := func(ipNets []*net.IPNet) []string {
+		var values []string
+		for _, ipNet := range ipNets {
+			values = append(values, ipNet.String())
+		}
+		return values
+	}
+	add("Permitted", "DNS", cert.PermittedDNSDomains)
+	add("Permitted", "IP Range", ranges(cert.PermittedIPRanges))
+	add("Permitted", "Email", cert.PermittedEmailAddresses)
+	add("Permitted", "URI", cert.PermittedURIDomains)
+	add("Excluded", "DNS", cert.ExcludedDNSDomains)
+	add("Excluded", "IP Range", ranges(cert.ExcludedIPRanges))
+	add("Excluded", "Email", cert.ExcludedEmailAddresses)
+	add("Excluded", "URI", cert.ExcludedURIDomains)
+	return constraints
+}
+
+func hexColon(data []byte) string {
+	parts := make([]string, len(data))
+	for i, b := range data {
+		parts[i] = fmt.Sprintf("%02x", b)
+	}
+	return strings.Join(parts, ":")
+}
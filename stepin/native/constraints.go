@@ -0,0 +1,47 @@
+package native
+
+import (
+	"crypto/x509"
+	"github.com/allape/stepin/stepin/create"
+	"net"
+)
+
+// NameConstraints
+// Name constraints of a certificate, nil if the extension is absent
+func NameConstraints(crt *x509.Certificate) *create.NameConstraints {
+	ranges := func(nets []*net.IPNet) []string {
+		cidrs := make([]string, 0, len(nets))
+		for _, n := range nets {
+			cidrs = append(cidrs, n.String())
+		}
+		return cidrs
+	}
+
+	constraints := create.NameConstraints{
+		PermittedDNSDomains:     crt.PermittedDNSDomains,
+		ExcludedDNSDomains:      crt.ExcludedDNSDomains,
+		PermittedIPRanges:       ranges(crt.PermittedIPRanges),
+		ExcludedIPRanges:        ranges(crt.ExcludedIPRanges),
+		PermittedEmailAddresses: crt.PermittedEmailAddresses,
+		ExcludedEmailAddresses:  crt.ExcludedEmailAddresses,
+		PermittedURIDomains:     crt.PermittedURIDomains,
+		ExcludedURIDomains:      crt.ExcludedURIDomains,
+	}
+	if constraints.IsEmpty() {
+		return nil
+	}
+	return &constraints
+}
+
+// MaxPathLen
+// Max path length of a CA certificate, -1 for unlimited, nil for non-CA certificates
+func MaxPathLen(crt *x509.Certificate) *int {
+	if !crt.BasicConstraintsValid || !crt.IsCA {
+		return nil
+	}
+	maxPathLen := crt.MaxPathLen
+	if maxPathLen == 0 && !crt.MaxPathLenZero {
+		maxPathLen = -1
+	}
+	return &maxPathLen
+}
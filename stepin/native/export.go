@@ -0,0 +1,74 @@
+package native
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"github.com/allape/stepin/stepin/create"
+	"github.com/smallstep/pkcs7"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// EncodeDER
+// DER of the first certificate
+func EncodeDER(crt create.Crt) ([]byte, error) {
+	cert, err := ParseCrt(crt)
+	if err != nil {
+		return nil, err
+	}
+	return cert.Raw, nil
+}
+
+// EncodePKCS8
+// Unencrypted PKCS#8 PEM of the key
+func EncodePKCS8(key create.Key, password create.Password) ([]byte, error) {
+	signer, err := ParseKey(key, password)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "PRIVATE KEY",
+		Bytes: der,
+	}), nil
+}
+
+// EncodePKCS7
+// Certs-only PKCS#7 in DER, aka .p7b
+func EncodePKCS7(chain []*x509.Certificate) ([]byte, error) {
+	var der []byte
+	for _, cert := range chain {
+		der = append(der, cert.Raw...)
+	}
+	return pkcs7.DegenerateCertificate(der)
+}
+
+// EncodePKCS12
+// PKCS#12 of the key and the chain, chain[0] is the certificate of the key.
+// Legacy encryption is for clients which do not support AES, e.g. Windows Server 2016 and Java 8.
+func EncodePKCS12(
+	key create.Key,
+	password create.Password,
+	chain []*x509.Certificate,
+	exportPassword create.Password,
+	legacy bool,
+) ([]byte, error) {
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("certificate is required")
+	}
+
+	signer, err := ParseKey(key, password)
+	if err != nil {
+		return nil, err
+	}
+
+	encoder := pkcs12.Modern
+	if legacy {
+		encoder = pkcs12.LegacyDES
+	}
+
+	return encoder.Encode(signer, chain[0], chain[1:], string(exportPassword))
+}
@@ -0,0 +1,135 @@
+package native
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/allape/stepin/stepin/create"
+	"github.com/smallstep/pkcs7"
+	"software.sslmate.com/src/go-pkcs12"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	backend := Backend{}
+
+	_, rootCrt, rootKey, err := backend.NewRootCA(create.RootOptions{
+		PrimaryOptions: create.PrimaryOptions{
+			Subject:  "root",
+			Password: "123456",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, leafCrt, leafKey, err := backend.NewTLS(create.RootlessOptions{
+		PrimaryOptions: create.PrimaryOptions{
+			Subject: "example.internal",
+		},
+		RootCaCrt:    rootCrt,
+		RootCaKey:    rootKey,
+		RootPassword: "123456",
+	}, create.OptionKeyType{KTY: create.RSA})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chain, err := ParseCrts(leafCrt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 2 {
+		t.Fatalf("leaf should be bundled with root, got %d certs", len(chain))
+	}
+
+	signer, err := ParseKey(leafKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// region DER
+
+	der, err := EncodeDER(leafCrt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cert.Equal(chain[0]) {
+		t.Fatal("der should be the leaf")
+	}
+
+	// endregion DER
+
+	// region PKCS#8
+
+	p8, err := EncodePKCS8(leafKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(p8)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		t.Fatalf("unexpected pkcs8 pem: %s", p8)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.(crypto.Signer).Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(signer.Public()) {
+		t.Fatal("pkcs8 key should be the leaf key")
+	}
+
+	// encrypted CA key
+	_, err = EncodePKCS8(rootKey, "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// endregion PKCS#8
+
+	// region PKCS#7
+
+	p7b, err := EncodePKCS7(chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p7, err := pkcs7.Parse(p7b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p7.Certificates) != 2 || !p7.Certificates[0].Equal(chain[0]) || !p7.Certificates[1].Equal(chain[1]) {
+		t.Fatalf("p7b should contain the chain, got %d certs", len(p7.Certificates))
+	}
+
+	// endregion PKCS#7
+
+	// region PKCS#12
+
+	for _, legacy := range []bool{false, true} {
+		p12, err := EncodePKCS12(leafKey, "", chain, "export", legacy)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decodedKey, decodedCert, decodedCAs, err := pkcs12.DecodeChain(p12, "export")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !decodedCert.Equal(chain[0]) || len(decodedCAs) != 1 || !decodedCAs[0].Equal(chain[1]) {
+			t.Fatal("p12 should contain the chain")
+		}
+		if !decodedKey.(crypto.Signer).Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(signer.Public()) {
+			t.Fatal("p12 key should be the leaf key")
+		}
+
+		_, _, _, err = pkcs12.DecodeChain(p12, "wrong")
+		if err == nil {
+			t.Fatal("p12 should not be decoded with a wrong password")
+		}
+	}
+
+	// endregion PKCS#12
+}
@@ -0,0 +1,32 @@
+package native
+
+import (
+	"crypto"
+	"crypto/x509"
+	"github.com/allape/stepin/stepin/create"
+)
+
+// DetectProfile
+// Profile of an existing certificate by its basic constraints, self-issued CAs are root CAs
+func DetectProfile(crt *x509.Certificate) create.Profile {
+	// CheckSignatureFrom refuses non-CA parents
+	selfSigned := string(crt.RawIssuer) == string(crt.RawSubject) &&
+		crt.CheckSignature(crt.SignatureAlgorithm, crt.RawTBSCertificate, crt.Signature) == nil
+	if crt.BasicConstraintsValid && crt.IsCA {
+		if selfSigned {
+			return create.RootCA
+		}
+		return create.IntermediateCA
+	}
+	if selfSigned {
+		return create.SelfSigned
+	}
+	return create.Leaf
+}
+
+// KeyMatches
+// Check if the private key is the one of the certificate
+func KeyMatches(crt *x509.Certificate, key crypto.Signer) bool {
+	publicKey, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	return ok && publicKey.Equal(crt.PublicKey)
+}
@@ -0,0 +1,73 @@
+package native
+
+import (
+	"github.com/allape/stepin/stepin/create"
+	"testing"
+)
+
+func TestDetectProfile(t *testing.T) {
+	backend := Backend{}
+
+	_, rootCrt, rootKey, err := backend.NewRootCA(create.RootOptions{
+		PrimaryOptions: create.PrimaryOptions{Subject: "root", Password: "123456"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, interCrt, interKey, err := backend.NewIntermediateCA(create.RootlessOptions{
+		PrimaryOptions: create.PrimaryOptions{Subject: "intermediate", Password: "456789"},
+		RootCaCrt:      rootCrt,
+		RootCaKey:      rootKey,
+		RootPassword:   "123456",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, leafCrt, _, err := backend.NewTLS(create.RootlessOptions{
+		PrimaryOptions: create.PrimaryOptions{Subject: "leaf.internal"},
+		RootCaCrt:      interCrt,
+		RootCaKey:      interKey,
+		RootPassword:   "456789",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, selfSignedCrt, _, err := backend.NewSelfSigned(create.PrimaryOptions{Subject: "localhost"}, create.OptionNoPassword{NoPassword: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for crt, profile := range map[string]create.Profile{
+		string(rootCrt):       create.RootCA,
+		string(interCrt):      create.IntermediateCA,
+		string(leafCrt):       create.Leaf,
+		string(selfSignedCrt): create.SelfSigned,
+	} {
+		parsed, err := ParseCrt(create.Crt(crt))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if detected := DetectProfile(parsed); detected != profile {
+			t.Fatalf("expected %s, got %s", profile, detected)
+		}
+	}
+
+	root, err := ParseCrt(rootCrt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ParseKey(rootKey, "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !KeyMatches(root, signer) {
+		t.Fatal("key of root should match")
+	}
+	signer, err = ParseKey(interKey, "456789")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if KeyMatches(root, signer) {
+		t.Fatal("key of intermediate should not match root")
+	}
+}
@@ -0,0 +1,194 @@
+package native
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"github.com/allape/stepin/stepin/create"
+	"go.step.sm/crypto/keyutil"
+	"go.step.sm/crypto/pemutil"
+	"net"
+	"net/url"
+)
+
+// GenerateKey
+// Same defaults as step-cli, see create.KeySpec
+func GenerateKey(kty create.KeyType, curve create.Curve, size create.BitSize) (crypto.Signer, error) {
+	spec := create.KeySpec{KeyType: kty, Curve: curve, Size: size}
+	err := spec.Validate()
+	if err != nil {
+		return nil, err
+	}
+	spec = spec.WithDefaults()
+
+	return keyutil.GenerateSigner(string(spec.KeyType), string(spec.Curve), int(spec.Size))
+}
+
+// ParseKey
+// Parse a PEM private key, which may be encrypted the way step-cli does
+func ParseKey(key create.Key, password create.Password) (crypto.Signer, error) {
+	var options []pemutil.Options
+	if password != "" {
+		options = append(options, pemutil.WithPassword([]byte(password)))
+	}
+
+	parsed, err := pemutil.Parse(key, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a private key", parsed)
+	}
+	return signer, nil
+}
+
+// SerializeKey
+// Encode a private key into PEM, the key will be encrypted if a password is provided
+func SerializeKey(key crypto.Signer, password create.Password) (create.Key, error) {
+	var options []pemutil.Options
+	if password != "" {
+		options = append(options, pemutil.WithPassword([]byte(password)))
+	}
+
+	block, err := pemutil.Serialize(key, options...)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+// ParseCrts
+// Parse all certificates in a PEM bundle
+func ParseCrts(crt create.Crt) ([]*x509.Certificate, error) {
+	var crts []*x509.Certificate
+
+	rest := []byte(crt)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		parsed, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		crts = append(crts, parsed)
+	}
+
+	if len(crts) == 0 {
+		return nil, fmt.Errorf("no certificate found")
+	}
+
+	return crts, nil
+}
+
+// ParseCrt
+// Parse the first certificate in a PEM bundle
+func ParseCrt(crt create.Crt) (*x509.Certificate, error) {
+	crts, err := ParseCrts(crt)
+	if err != nil {
+		return nil, err
+	}
+	return crts[0], nil
+}
+
+func EncodeCrt(crts ...*x509.Certificate) create.Crt {
+	var encoded []byte
+	for _, crt := range crts {
+		encoded = append(encoded, pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: crt.Raw,
+		})...)
+	}
+	return encoded
+}
+
+// ParseCSR
+// Parse a PEM encoded certificate signing request
+func ParseCSR(csr create.CSR) (*x509.CertificateRequest, error) {
+	rest := []byte(csr)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return nil, fmt.Errorf("no certificate request found")
+		}
+		if block.Type == "CERTIFICATE REQUEST" || block.Type == "NEW CERTIFICATE REQUEST" {
+			return x509.ParseCertificateRequest(block.Bytes)
+		}
+	}
+}
+
+// CSRNames
+// All subject alternative names in a certificate signing request, in the order of DNS, IP, email and URI
+func CSRNames(csr *x509.CertificateRequest) []string {
+	return sanNames(csr.DNSNames, csr.IPAddresses, csr.EmailAddresses, csr.URIs)
+}
+
+// CrtNames
+// All subject alternative names in a certificate, in the same order as CSRNames
+func CrtNames(crt *x509.Certificate) []string {
+	return sanNames(crt.DNSNames, crt.IPAddresses, crt.EmailAddresses, crt.URIs)
+}
+
+func sanNames(dnsNames []string, ips []net.IP, emails []string, uris []*url.URL) []string {
+	names := make([]string, 0, len(dnsNames)+len(ips)+len(emails)+len(uris))
+	names = append(names, dnsNames...)
+	for _, ip := range ips {
+		names = append(names, ip.String())
+	}
+	names = append(names, emails...)
+	for _, uri := range uris {
+		names = append(names, uri.String())
+	}
+	return names
+}
+
+// KeyAlgorithm
+// Describe a public key in terms of create.KeyType, e.g. EC P-256, RSA 2048, OKP Ed25519
+func KeyAlgorithm(publicKey crypto.PublicKey) string {
+	switch key := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		return fmt.Sprintf("%s %s", create.EC, key.Curve.Params().Name)
+	case *rsa.PublicKey:
+		return fmt.Sprintf("%s %d", create.RSA, key.N.BitLen())
+	case ed25519.PublicKey:
+		return fmt.Sprintf("%s %s", create.OKP, create.Ed25519)
+	default:
+		return fmt.Sprintf("%T", publicKey)
+	}
+}
+
+// KeyParams
+// Key type, curve and size of a public key, the same as the ones GenerateKey takes
+func KeyParams(publicKey crypto.PublicKey) (create.KeyType, create.Curve, create.BitSize) {
+	switch key := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		return create.EC, create.Curve(key.Curve.Params().Name), create.BitSize(key.Curve.Params().BitSize)
+	case *rsa.PublicKey:
+		return create.RSA, "", create.BitSize(key.N.BitLen())
+	case ed25519.PublicKey:
+		return create.OKP, create.Ed25519, 0
+	default:
+		return "", "", 0
+	}
+}
+
+// Fingerprint
+// Lower case hex of SHA-256 of the DER encoded certificate, same as `step certificate fingerprint`
+func Fingerprint(crt *x509.Certificate) string {
+	sum := sha256.Sum256(crt.Raw)
+	return hex.EncodeToString(sum[:])
+}
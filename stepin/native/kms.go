@@ -0,0 +1,76 @@
+package native
+
+import (
+	"context"
+	"crypto"
+	"github.com/allape/stepin/stepin/create"
+	"go.step.sm/crypto/kms/apiv1"
+	"go.step.sm/crypto/kms/pkcs11"
+	"go.step.sm/crypto/kms/uri"
+	"sync"
+)
+
+var (
+	tokensLocker sync.Mutex
+	tokens       = map[string]*pkcs11.PKCS11{}
+)
+
+// token
+// PKCS#11 context of the token in the URI, opened once and kept for later signings,
+// logging in to a token for every signature is slow and some modules limit the sessions
+func token(kms create.URI) (*pkcs11.PKCS11, error) {
+	u, err := uri.ParseWithScheme(create.KMSSchemePKCS11, string(kms))
+	if err != nil {
+		return nil, err
+	}
+	u.Values.Del("id")
+	u.Values.Del("object")
+	name := u.String()
+
+	tokensLocker.Lock()
+	defer tokensLocker.Unlock()
+
+	if t, ok := tokens[name]; ok {
+		return t, nil
+	}
+
+	t, err := pkcs11.New(context.Background(), apiv1.Options{
+		Type: apiv1.PKCS11,
+		URI:  name,
+	})
+	if err != nil {
+		return nil, err
+	}
+	tokens[name] = t
+
+	return t, nil
+}
+
+// KMSSigner
+// Signer of the key at the URI in a PKCS#11 token, see create.ValidateKMSURI,
+// the key never leaves the token, only digests are sent to it.
+// Unsupported if compiled without cgo.
+func KMSSigner(kms create.URI) (crypto.Signer, error) {
+	err := create.ValidateKMSURI(kms)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := token(kms)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.CreateSigner(&apiv1.CreateSignerRequest{
+		SigningKey: string(kms),
+	})
+}
+
+// CASigner
+// Signer of a CA, by the key at caKMS if not empty, or the PEM caKey decrypted with caPassword
+func CASigner(caKey create.Key, caPassword create.Password, caKMS create.URI) (crypto.Signer, error) {
+	if caKMS != "" {
+		return KMSSigner(caKMS)
+	}
+	return ParseKey(caKey, caPassword)
+}
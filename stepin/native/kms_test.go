@@ -0,0 +1,95 @@
+package native
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"github.com/allape/stepin/stepin/create"
+	"go.step.sm/crypto/kms/apiv1"
+	"go.step.sm/crypto/kms/pkcs11"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKMSSigner_MissingModule(t *testing.T) {
+	_, err := KMSSigner("pkcs11:module-path=/nonexistent/libsofthsm2.so;token=stepin;id=01;object=root-ca")
+	if err == nil {
+		t.Fatal("missing module should fail")
+	}
+}
+
+// TestBackend_KMS
+// Run against a SoftHSMv2 token, e.g.
+// softhsm2-util --init-token --free --label stepin --so-pin 0000 --pin 1234
+func TestBackend_KMS(t *testing.T) {
+	module := os.Getenv("STEPIN_TEST_PKCS11_MODULE")
+	if module == "" {
+		t.Skip("STEPIN_TEST_PKCS11_MODULE is not set")
+	}
+	pinFile := filepath.Join(t.TempDir(), "pin")
+	err := os.WriteFile(pinFile, []byte(os.Getenv("STEPIN_TEST_PKCS11_PIN")), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	rootKMS := create.URI(fmt.Sprintf(
+		"pkcs11:module-path=%s;token=%s;id=%s;object=stepin-test-%x?pin-source=%s",
+		module, os.Getenv("STEPIN_TEST_PKCS11_TOKEN"), hex.EncodeToString(id), id, pinFile,
+	))
+
+	token, err := pkcs11.New(context.Background(), apiv1.Options{Type: apiv1.PKCS11, URI: string(rootKMS)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = token.CreateKey(&apiv1.CreateKeyRequest{Name: string(rootKMS), SignatureAlgorithm: apiv1.ECDSAWithSHA384})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = token.DeleteKey(string(rootKMS))
+		_ = token.Close()
+	}()
+
+	backend := Backend{}
+
+	_, rootCrt, rootKey, err := backend.NewRootCA(create.RootOptions{
+		PrimaryOptions: create.PrimaryOptions{Subject: "kms root"},
+	}, create.OptionKMS{KMS: rootKMS})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rootKey) != 0 {
+		t.Fatal("key in kms should not be returned")
+	}
+
+	_, leafCrt, _, err := backend.NewTLS(create.RootlessOptions{
+		PrimaryOptions: create.PrimaryOptions{Subject: "kms.example.internal"},
+		RootCaCrt:      rootCrt,
+	}, create.OptionCAKMS{CAKMS: rootKMS})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := ParseCrt(rootCrt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, curve, _ := KeyParams(root.PublicKey); curve != create.P384 {
+		t.Fatalf("root should have the key in kms, got %s", curve)
+	}
+	leaf, err := ParseCrt(leafCrt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	_, err = leaf.Verify(x509.VerifyOptions{DNSName: "kms.example.internal", Roots: roots})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
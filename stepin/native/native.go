@@ -0,0 +1,288 @@
+package native
+
+// Pure Go implementation of create.Backend, behaves like `step certificate create`
+
+import (
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"github.com/allape/stepin/stepin"
+	"github.com/allape/stepin/stepin/create"
+	"github.com/allape/stepin/stepin/inspect"
+	"go.step.sm/crypto/x509util"
+	"os"
+	"time"
+)
+
+const (
+	DefaultLeafValidity = 24 * time.Hour
+	DefaultCAValidity   = 10 * 365 * 24 * time.Hour
+)
+
+var _ create.Backend = (*Backend)(nil)
+
+// Backend
+// Backend which signs in process with go.step.sm/crypto, no `step` binary is needed
+type Backend struct{}
+
+func (b Backend) NewRootCA(opt create.RootOptions, options ...stepin.CommandOption) (stepin.Inspection, create.Crt, create.Key, error) {
+	return b.New(opt.PrimaryOptions, nil, nil, "", append(options, create.OptionProfile{Profile: create.RootCA})...)
+}
+
+func (b Backend) NewSelfSigned(opt create.PrimaryOptions, options ...stepin.CommandOption) (stepin.Inspection, create.Crt, create.Key, error) {
+	return b.New(opt, nil, nil, "", append(options, create.OptionProfile{Profile: create.SelfSigned})...)
+}
+
+func (b Backend) NewIntermediateCA(opt create.RootlessOptions, options ...stepin.CommandOption) (stepin.Inspection, create.Crt, create.Key, error) {
+	return b.New(opt.PrimaryOptions, opt.RootCaCrt, opt.RootCaKey, opt.RootPassword, append(options, create.OptionProfile{Profile: create.IntermediateCA})...)
+}
+
+func (b Backend) NewLeaf(opt create.RootlessOptions, options ...stepin.CommandOption) (stepin.Inspection, create.Crt, create.Key, error) {
+	return b.New(opt.PrimaryOptions, opt.RootCaCrt, opt.RootCaKey, opt.RootPassword, append(options, create.OptionProfile{Profile: create.Leaf})...)
+}
+
+func (b Backend) NewTLS(opt create.RootlessOptions, options ...stepin.CommandOption) (stepin.Inspection, create.Crt, create.Key, error) {
+	return b.NewLeaf(opt, append(
+		options,
+		create.OptionBundle{Bundle: true},
+		create.OptionNoPassword{NoPassword: true},
+	)...)
+}
+
+func (b Backend) Inspect(crt create.Crt, short bool, _ ...stepin.CommandOption) (stepin.Inspection, error) {
+	return inspect.Native(crt, short)
+}
+
+// New
+// Create a certificate of the profile in options, caCrt and caKey, or OptionCAKMS instead of caKey, are required for intermediate-ca and leaf
+func (b Backend) New(
+	opt create.PrimaryOptions,
+	caCrt create.Crt,
+	caKey create.Key,
+	caPassword create.Password,
+	options ...stepin.CommandOption,
+) (stepin.Inspection, create.Crt, create.Key, error) {
+	s, err := newSettings(options)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	tpl, validity, err := template(s, options)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	if opt.Password == "" && !s.noPassword && s.kms == "" {
+		return "", nil, nil, fmt.Errorf("password is required to encrypt the private key")
+	}
+
+	var (
+		signer crypto.Signer
+		key    create.Key
+	)
+	if s.kms != "" {
+		// nothing to return, the key stays in the token
+		signer, err = KMSSigner(s.kms)
+	} else if s.keyFile != "" {
+		key, err = os.ReadFile(string(s.keyFile))
+		if err != nil {
+			return "", nil, nil, err
+		}
+		signer, err = ParseKey(key, opt.Password)
+	} else {
+		signer, err = GenerateKey(s.kty, s.curve, s.size)
+		if err == nil {
+			key, err = SerializeKey(signer, opt.Password)
+		}
+	}
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	sans := make([]string, 0, len(s.sans))
+	for _, san := range s.sans {
+		sans = append(sans, string(san))
+	}
+	if len(sans) == 0 && (s.profile == create.Leaf || s.profile == create.SelfSigned) {
+		sans = append(sans, string(opt.Subject))
+	}
+
+	data := x509util.CreateTemplateData(string(opt.Subject), sans)
+	if len(s.userData) > 0 {
+		data.SetUserData(s.userData)
+	}
+
+	certificate, err := x509util.NewCertificateFromX509(&x509.Certificate{
+		Subject:   pkix.Name{CommonName: string(opt.Subject)},
+		PublicKey: signer.Public(),
+	}, x509util.WithTemplate(tpl, data))
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	encoded, err := sign(s, certificate.GetCertificate(), validity, signer, caCrt, caKey, caPassword)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	inspection, err := b.Inspect(encoded, false)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	return inspection, encoded, key, nil
+}
+
+// SignCSR
+// Sign a PEM encoded certificate signing request like `step certificate sign`, the profile is leaf unless OptionProfile is specified
+func (b Backend) SignCSR(opt create.SignOptions, options ...stepin.CommandOption) (stepin.Inspection, create.Crt, error) {
+	s, err := newSettings(append([]stepin.CommandOption{create.OptionProfile{Profile: create.Leaf}}, options...))
+	if err != nil {
+		return "", nil, err
+	}
+	if s.profile != create.Leaf && s.profile != create.IntermediateCA {
+		return "", nil, fmt.Errorf("profile %s can not be used to sign a csr", s.profile)
+	}
+
+	tpl, validity, err := template(s, options)
+	if err != nil {
+		return "", nil, err
+	}
+
+	csr, err := ParseCSR(opt.CSR)
+	if err != nil {
+		return "", nil, err
+	}
+
+	sans := make([]string, 0, len(s.sans))
+	for _, san := range s.sans {
+		sans = append(sans, string(san))
+	}
+	if len(sans) == 0 {
+		sans = CSRNames(csr)
+	}
+
+	data := x509util.CreateTemplateData(csr.Subject.CommonName, sans)
+	if len(s.userData) > 0 {
+		data.SetUserData(s.userData)
+	}
+
+	var certificate *x509util.Certificate
+	if s.skipCSRSignature {
+		certificate, err = x509util.NewCertificateFromX509(&x509.Certificate{
+			Subject:   csr.Subject,
+			PublicKey: csr.PublicKey,
+		}, x509util.WithTemplate(tpl, data))
+	} else {
+		certificate, err = x509util.NewCertificate(csr, x509util.WithTemplate(tpl, data))
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	encoded, err := sign(s, certificate.GetCertificate(), validity, nil, opt.RootCaCrt, opt.RootCaKey, opt.RootPassword)
+	if err != nil {
+		return "", nil, err
+	}
+
+	inspection, err := b.Inspect(encoded, false)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return inspection, encoded, nil
+}
+
+// template
+// Certificate template and default validity of the profile in settings
+func template(s *settings, options []stepin.CommandOption) (string, time.Duration, error) {
+	tpl, err := create.DefaultTemplate(s.profile)
+	if err != nil {
+		return "", 0, err
+	}
+
+	validity := DefaultLeafValidity
+	if s.profile == create.RootCA || s.profile == create.IntermediateCA {
+		validity = DefaultCAValidity
+	}
+
+	if s.template != "" {
+		tpl = s.template
+	}
+
+	tpl, err = create.InjectTemplate(tpl, create.TemplateFields(options))
+	if err != nil {
+		return "", 0, err
+	}
+
+	overrides, err := create.TemplateOverrides(options)
+	if err != nil {
+		return "", 0, err
+	}
+	tpl, err = create.AppendTemplate(tpl, overrides)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return tpl, validity, nil
+}
+
+// sign
+// Fill the validity of template and sign it with the CA, or with signer itself for root-ca and self-signed
+func sign(
+	s *settings,
+	template *x509.Certificate,
+	validity time.Duration,
+	signer crypto.Signer,
+	caCrt create.Crt,
+	caKey create.Key,
+	caPassword create.Password,
+) (create.Crt, error) {
+	now := time.Now()
+	if !s.notBefore.IsZero() {
+		template.NotBefore = s.notBefore
+	} else if template.NotBefore.IsZero() {
+		template.NotBefore = now
+	}
+	if !s.notAfter.IsZero() {
+		template.NotAfter = s.notAfter
+	} else if template.NotAfter.IsZero() {
+		template.NotAfter = now.Add(validity)
+	}
+
+	var (
+		issuer       = template
+		issuerSigner = signer
+		chain        []*x509.Certificate
+		err          error
+	)
+	if s.profile == create.IntermediateCA || s.profile == create.Leaf {
+		if len(caCrt) == 0 || (len(caKey) == 0 && s.caKMS == "") {
+			return nil, fmt.Errorf("ca certificate and key are required for %s", s.profile)
+		}
+		chain, err = ParseCrts(caCrt)
+		if err != nil {
+			return nil, err
+		}
+		issuer = chain[0]
+		// a cert never outlives its issuer
+		template.NotAfter = create.ClampNotAfter(template.NotAfter, issuer.NotAfter)
+		issuerSigner, err = CASigner(caKey, caPassword, s.caKMS)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	crt, err := x509util.CreateCertificate(template, issuer, template.PublicKey, issuerSigner)
+	if err != nil {
+		return nil, err
+	}
+
+	crts := []*x509.Certificate{crt}
+	if s.bundle {
+		crts = append(crts, chain...)
+	}
+
+	return EncodeCrt(crts...), nil
+}
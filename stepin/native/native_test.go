@@ -0,0 +1,310 @@
+package native
+
+import (
+	"crypto/x509"
+	"github.com/allape/stepin/stepin/create"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackend(t *testing.T) {
+	backend := Backend{}
+
+	_, rootCrt, rootKey, err := backend.NewRootCA(create.RootOptions{
+		PrimaryOptions: create.PrimaryOptions{
+			Subject:  "root",
+			Password: "123456",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, interCrt, interKey, err := backend.NewIntermediateCA(create.RootlessOptions{
+		PrimaryOptions: create.PrimaryOptions{
+			Subject:  "intermediate",
+			Password: "456789",
+		},
+		RootCaCrt:    rootCrt,
+		RootCaKey:    rootKey,
+		RootPassword: "123456",
+	}, create.OptionKeyType{KTY: create.RSA}, create.OptionSize{Size: 3072})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notAfter := time.Now().Add(90 * 24 * time.Hour).Truncate(time.Second)
+	inspection, leafCrt, leafKey, err := backend.NewTLS(create.RootlessOptions{
+		PrimaryOptions: create.PrimaryOptions{
+			Subject: "example.internal",
+		},
+		RootCaCrt:    interCrt,
+		RootCaKey:    interKey,
+		RootPassword: "456789",
+	},
+		create.OptionKeyType{KTY: create.OKP},
+		create.OptionNotAfter{NotAfter: notAfter},
+		create.OptionSAN{SAN: []create.SAN{"example.internal", "10.0.0.1", "admin@example.internal"}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(inspection), "DNS:example.internal") {
+		t.Fatalf("unexpected inspection: %s", inspection)
+	}
+
+	roots, err := ParseCrts(rootCrt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafs, err := ParseCrts(leafCrt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leafs) != 2 {
+		t.Fatalf("leaf should be bundled with its issuer, got %d certs", len(leafs))
+	}
+
+	rootPool := x509.NewCertPool()
+	rootPool.AddCert(roots[0])
+	interPool := x509.NewCertPool()
+	interPool.AddCert(leafs[1])
+	_, err = leafs[0].Verify(x509.VerifyOptions{
+		DNSName:       "example.internal",
+		Roots:         rootPool,
+		Intermediates: interPool,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !leafs[0].NotAfter.Equal(notAfter) {
+		t.Fatalf("unexpected not after: %s", leafs[0].NotAfter)
+	}
+	if len(leafs[0].IPAddresses) != 1 || len(leafs[0].EmailAddresses) != 1 {
+		t.Fatalf("unexpected sans: %v %v", leafs[0].IPAddresses, leafs[0].EmailAddresses)
+	}
+	if leafs[1].PublicKeyAlgorithm != x509.RSA {
+		t.Fatalf("unexpected intermediate key algorithm: %s", leafs[1].PublicKeyAlgorithm)
+	}
+
+	_, longCrt, _, err := backend.NewTLS(create.RootlessOptions{
+		PrimaryOptions: create.PrimaryOptions{
+			Subject: "long.example.internal",
+		},
+		RootCaCrt:    interCrt,
+		RootCaKey:    interKey,
+		RootPassword: "456789",
+	}, create.OptionNotAfter{NotAfter: leafs[1].NotAfter.AddDate(1, 0, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	long, err := ParseCrt(longCrt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !long.NotAfter.Equal(leafs[1].NotAfter) {
+		t.Fatalf("not after should be clamped to the one of the issuer: %s", long.NotAfter)
+	}
+
+	if strings.Contains(string(leafKey), "ENCRYPTED") {
+		t.Fatal("leaf key should not be encrypted")
+	}
+	_, err = ParseKey(rootKey, "")
+	if err == nil {
+		t.Fatal("root key should be encrypted")
+	}
+}
+
+func TestBackend_NewSelfSigned(t *testing.T) {
+	_, crt, key, err := Backend{}.NewSelfSigned(create.PrimaryOptions{
+		Subject:  "localhost",
+		Password: "123456",
+	}, create.OptionKeyType{KTY: create.EC}, create.OptionCurve{Curve: create.P384})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := ParseCrt(crt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature); err != nil {
+		t.Fatal(err)
+	}
+	if cert.IsCA || cert.DNSNames[0] != "localhost" {
+		t.Fatalf("unexpected self-signed cert: %v %v", cert.IsCA, cert.DNSNames)
+	}
+
+	_, err = ParseKey(key, "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, _, err = Backend{}.NewSelfSigned(create.PrimaryOptions{
+		Subject:  "localhost",
+		Password: "123456",
+	}, create.OptionKeyType{KTY: create.OKP}, create.OptionCurve{Curve: create.P256})
+	if err == nil {
+		t.Fatal("OKP key with P-256 curve should fail")
+	}
+}
+
+func TestBackend_NameConstraints(t *testing.T) {
+	backend := Backend{}
+
+	_, rootCrt, rootKey, err := backend.NewRootCA(create.RootOptions{
+		PrimaryOptions: create.PrimaryOptions{
+			Subject:  "root",
+			Password: "123456",
+		},
+	}, create.OptionMaxPathLen{MaxPathLen: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, interCrt, interKey, err := backend.NewIntermediateCA(create.RootlessOptions{
+		PrimaryOptions: create.PrimaryOptions{
+			Subject:  "team-a",
+			Password: "456789",
+		},
+		RootCaCrt:    rootCrt,
+		RootCaKey:    rootKey,
+		RootPassword: "123456",
+	}, create.OptionNameConstraints{NameConstraints: create.NameConstraints{
+		PermittedDNSDomains: []string{"*.team-a.internal"},
+		PermittedIPRanges:   []string{"10.20.0.0/16"},
+	}}, create.OptionMaxPathLen{MaxPathLen: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := ParseCrt(rootCrt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if maxPathLen := MaxPathLen(root); maxPathLen == nil || *maxPathLen != -1 {
+		t.Fatalf("unexpected max path length of root: %v", maxPathLen)
+	}
+
+	inter, err := ParseCrt(interCrt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if maxPathLen := MaxPathLen(inter); maxPathLen == nil || *maxPathLen != 0 {
+		t.Fatalf("unexpected max path length of intermediate: %v", maxPathLen)
+	}
+	constraints := NameConstraints(inter)
+	if !inter.PermittedDNSDomainsCritical || constraints == nil ||
+		constraints.PermittedDNSDomains[0] != ".team-a.internal" || constraints.PermittedIPRanges[0] != "10.20.0.0/16" {
+		t.Fatalf("unexpected name constraints: %v", constraints)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(inter)
+
+	for name, ok := range map[string]bool{
+		"web.team-a.internal": true,
+		"web.team-b.internal": false,
+	} {
+		_, leafCrt, _, err := backend.NewTLS(create.RootlessOptions{
+			PrimaryOptions: create.PrimaryOptions{Subject: create.SubjectName(name)},
+			RootCaCrt:      interCrt,
+			RootCaKey:      interKey,
+			RootPassword:   "456789",
+		}, create.OptionSAN{SAN: []create.SAN{create.SAN(name), "10.20.0.1"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := ParseCrt(leafCrt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if MaxPathLen(leaf) != nil || NameConstraints(leaf) != nil {
+			t.Fatalf("unexpected constraints on leaf %s", name)
+		}
+		_, err = leaf.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates})
+		if (err == nil) != ok {
+			t.Fatalf("unexpected verification of %s: %v", name, err)
+		}
+	}
+
+	_, _, _, err = backend.NewTLS(create.RootlessOptions{
+		PrimaryOptions: create.PrimaryOptions{Subject: "leaf"},
+		RootCaCrt:      interCrt,
+		RootCaKey:      interKey,
+		RootPassword:   "456789",
+	}, create.OptionMaxPathLen{MaxPathLen: 0})
+	if err == nil {
+		t.Fatal("max path length on leaf should fail")
+	}
+}
+
+func TestBackend_RenewCA(t *testing.T) {
+	backend := Backend{}
+
+	_, rootCrt, rootKey, err := backend.NewRootCA(create.RootOptions{
+		PrimaryOptions: create.PrimaryOptions{
+			Subject:  "root",
+			Password: "123456",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rootless := create.RootlessOptions{
+		PrimaryOptions: create.PrimaryOptions{
+			Subject:  "team-a",
+			Password: "456789",
+		},
+		RootCaCrt:    rootCrt,
+		RootCaKey:    rootKey,
+		RootPassword: "123456",
+	}
+	_, interCrt, interKey, err := backend.NewIntermediateCA(rootless, create.OptionNameConstraints{NameConstraints: create.NameConstraints{
+		PermittedDNSDomains: []string{"team-a.internal"},
+	}}, create.OptionMaxPathLen{MaxPathLen: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inter, err := ParseCrt(interCrt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// renewed the way RenewCert does, with the same key and the constraints parsed from the renewed one
+	keyFile := filepath.Join(t.TempDir(), "inter.key")
+	err = os.WriteFile(keyFile, interKey, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, renewedCrt, _, err := backend.NewIntermediateCA(rootless, append(
+		create.ConstraintOptions(NameConstraints(inter), MaxPathLen(inter)),
+		create.OptionKey{Key: create.KeyFile(keyFile)},
+	)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	renewed, err := ParseCrt(renewedCrt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(renewed.PermittedDNSDomains, inter.PermittedDNSDomains) || renewed.PermittedDNSDomains[0] != "team-a.internal" {
+		t.Fatalf("unexpected permitted dns domains of renewed ca: %v", renewed.PermittedDNSDomains)
+	}
+	if maxPathLen := MaxPathLen(renewed); maxPathLen == nil || *maxPathLen != 1 {
+		t.Fatalf("unexpected max path length of renewed ca: %v", maxPathLen)
+	}
+	if len(create.ConstraintOptions(nil, nil)) != 0 {
+		t.Fatal("no option for absent constraints")
+	}
+}
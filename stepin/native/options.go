@@ -0,0 +1,108 @@
+package native
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/allape/stepin/stepin"
+	"github.com/allape/stepin/stepin/create"
+	"os"
+	"time"
+)
+
+// settings
+// Collected values of step-cli options, see create.d.go for the meaning of each field
+type settings struct {
+	profile    create.Profile
+	kty        create.KeyType
+	curve      create.Curve
+	size       create.BitSize
+	notBefore  time.Time
+	notAfter   time.Time
+	sans       []create.SAN
+	template   string
+	userData   map[string]any
+	keyFile    create.KeyFile
+	kms        create.URI // the key of the certificate is the one at the URI, instead of keyFile or a new one
+	caKMS      create.URI // the key of the CA is the one at the URI, instead of the PEM one
+	noPassword bool
+	bundle     bool
+
+	skipCSRSignature bool
+}
+
+func newSettings(options []stepin.CommandOption) (*settings, error) {
+	s := &settings{
+		userData: map[string]any{},
+	}
+
+	for _, option := range options {
+		switch o := option.(type) {
+		case stepin.OptionCommandBin, create.OptionForce, create.OptionSubtle, create.OptionPasswordFile:
+			// meaningless without step-cli, passwords are taken from create.PrimaryOptions
+		case create.OptionCRLDistributionPoints, create.OptionOCSPServer:
+			// injected into the template, see create.TemplateFields
+		case create.OptionNameConstraints, create.OptionMaxPathLen:
+			// appended to the template, see create.TemplateOverrides
+		case create.OptionProfile:
+			s.profile = o.Profile
+		case create.OptionKeyType:
+			s.kty = o.KTY
+		case create.OptionCurve:
+			s.curve = o.Curve
+		case create.OptionSize:
+			s.size = o.Size
+		case create.OptionNotBefore:
+			s.notBefore = o.NotBefore
+		case create.OptionNotAfter:
+			s.notAfter = o.NotAfter
+		case create.OptionSAN:
+			s.sans = append(s.sans, o.SAN...)
+		case create.OptionTemplate:
+			if o.Template == "" {
+				continue
+			}
+			content, err := os.ReadFile(string(o.Template))
+			if err != nil {
+				return nil, err
+			}
+			s.template = string(content)
+		case create.OptionSet:
+			for _, set := range o.Set {
+				s.userData[set.Key] = set.Value
+			}
+		case create.OptionSetFile:
+			if o.SetFile == "" {
+				continue
+			}
+			content, err := os.ReadFile(string(o.SetFile))
+			if err != nil {
+				return nil, err
+			}
+			err = json.Unmarshal(content, &s.userData)
+			if err != nil {
+				return nil, fmt.Errorf("invalid set file: %w", err)
+			}
+		case create.OptionKey:
+			s.keyFile = o.Key
+		case create.OptionKMS:
+			s.kms = o.KMS
+		case create.OptionCAKMS:
+			s.caKMS = o.CAKMS
+		case create.OptionNoPassword:
+			s.noPassword = o.NoPassword
+		case create.OptionBundle:
+			s.bundle = o.Bundle
+		case create.OptionSkipCSRSignature:
+			s.skipCSRSignature = o.SkipCSRSignature
+		default:
+			return nil, fmt.Errorf("option %T is not supported by native backend", option)
+		}
+	}
+
+	err := create.KeySpec{KeyType: s.kty, Curve: s.curve, Size: s.size}.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
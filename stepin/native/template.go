@@ -0,0 +1,49 @@
+package native
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"github.com/allape/stepin/stepin/create"
+	"go.step.sm/crypto/x509util"
+)
+
+const sampleSubject = "example.internal"
+
+// ValidateTemplate
+// Render the template with a sample subject and the user data, as well as the fields injected by the server
+func ValidateTemplate(template string, userData map[string]any) error {
+	if template == "" {
+		return fmt.Errorf("template is empty")
+	}
+
+	// fields are injected into templates while issuing with a public url
+	injected, err := create.InjectTemplate(template, map[string]any{
+		"crlDistributionPoints": []create.URI{"http://" + sampleSubject + "/crl"},
+	})
+	if err != nil {
+		return err
+	}
+
+	signer, err := GenerateKey(create.EC, create.P256, 0)
+	if err != nil {
+		return err
+	}
+
+	data := x509util.CreateTemplateData(sampleSubject, []string{sampleSubject})
+	if len(userData) > 0 {
+		data.SetUserData(userData)
+	}
+
+	for _, tpl := range []string{template, injected} {
+		_, err = x509util.NewCertificateFromX509(&x509.Certificate{
+			Subject:   pkix.Name{CommonName: sampleSubject},
+			PublicKey: signer.Public(),
+		}, x509util.WithTemplate(tpl, data))
+		if err != nil {
+			return fmt.Errorf("invalid template: %w", err)
+		}
+	}
+
+	return nil
+}
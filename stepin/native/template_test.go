@@ -0,0 +1,64 @@
+package native
+
+import (
+	"github.com/allape/stepin/stepin/create"
+	"go.step.sm/crypto/x509util"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateTemplate(t *testing.T) {
+	err := ValidateTemplate(x509util.DefaultLeafTemplate, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tpl := `{
+	"subject": {{ toJson .Subject }},
+	"sans": {{ toJson .SANs }},
+	"keyUsage": ["digitalSignature"],
+	"extKeyUsage": ["clientAuth"],
+	"emailAddresses": [{{ toJson .Insecure.User.email }}]
+}`
+	err = ValidateTemplate(tpl, map[string]any{"email": "someone@example.internal"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, invalid := range []string{
+		"",
+		"not json",
+		`{"subject": {{ toJson .Subject }},}`,
+		`{"subject": {{ .Subject.Unknown }}}`,
+	} {
+		err = ValidateTemplate(invalid, nil)
+		if err == nil {
+			t.Fatalf("template should be invalid: %s", invalid)
+		}
+	}
+
+	file := filepath.Join(t.TempDir(), "template.tpl")
+	err = os.WriteFile(file, []byte(tpl), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, crt, _, err := Backend{}.NewSelfSigned(
+		create.PrimaryOptions{Subject: "localhost"},
+		create.OptionNoPassword{NoPassword: true},
+		create.OptionTemplate{Template: create.FilePath(file)},
+		create.OptionSet{Set: []create.Set{{Key: "email", Value: "someone@example.internal"}}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := ParseCrt(crt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.EmailAddresses) != 1 || cert.EmailAddresses[0] != "someone@example.internal" {
+		t.Fatalf("unexpected email addresses: %v", cert.EmailAddresses)
+	}
+}
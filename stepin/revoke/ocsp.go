@@ -0,0 +1,86 @@
+package revoke
+
+// https://www.rfc-editor.org/rfc/rfc6960
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"github.com/allape/stepin/stepin/create"
+	"github.com/allape/stepin/stepin/native"
+	"golang.org/x/crypto/ocsp"
+	"time"
+)
+
+const (
+	OCSPRequestMimeType  = "application/ocsp-request"
+	OCSPResponseMimeType = "application/ocsp-response"
+)
+
+// ParseOCSPRequest
+// Parse a DER encoded request and check it is asking about a cert issued by the CA
+func ParseOCSPRequest(der []byte, ca *x509.Certificate) (*ocsp.Request, error) {
+	request, err := ocsp.ParseRequest(der)
+	if err != nil {
+		return nil, err
+	}
+
+	if !request.HashAlgorithm.Available() {
+		return nil, fmt.Errorf("unsupported hash algorithm: %v", request.HashAlgorithm)
+	}
+
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	_, err = asn1.Unmarshal(ca.RawSubjectPublicKeyInfo, &spki)
+	if err != nil {
+		return nil, err
+	}
+
+	nameHash := request.HashAlgorithm.New()
+	nameHash.Write(ca.RawSubject)
+	keyHash := request.HashAlgorithm.New()
+	keyHash.Write(spki.PublicKey.RightAlign())
+
+	if !bytes.Equal(nameHash.Sum(nil), request.IssuerNameHash) || !bytes.Equal(keyHash.Sum(nil), request.IssuerKeyHash) {
+		return nil, fmt.Errorf("request is not for certs issued by %s", ca.Subject)
+	}
+
+	return request, nil
+}
+
+// NewOCSPResponse
+// Sign a response with signer of the CA key, see native.CASigner, entry is nil for good certs,
+// and status should be ocsp.Unknown if the serial number is not issued by the CA.
+func NewOCSPResponse(
+	caCrt create.Crt,
+	signer crypto.Signer,
+	request *ocsp.Request,
+	status int,
+	entry *Entry,
+	thisUpdate time.Time,
+	nextUpdate time.Time,
+) ([]byte, error) {
+	ca, err := native.ParseCrt(caCrt)
+	if err != nil {
+		return nil, err
+	}
+
+	template := ocsp.Response{
+		Status:       status,
+		SerialNumber: request.SerialNumber,
+		IssuerHash:   request.HashAlgorithm,
+		ThisUpdate:   thisUpdate,
+		NextUpdate:   nextUpdate,
+	}
+	if status == ocsp.Revoked && entry != nil {
+		template.RevokedAt = entry.RevokedAt
+		template.RevocationReason = int(entry.Reason)
+	}
+
+	return ocsp.CreateResponse(ca, ca, template, signer)
+}
@@ -0,0 +1,83 @@
+package revoke
+
+import (
+	"github.com/allape/stepin/stepin/create"
+	"github.com/allape/stepin/stepin/native"
+	"golang.org/x/crypto/ocsp"
+	"testing"
+	"time"
+)
+
+func TestNewOCSPResponse(t *testing.T) {
+	_, caCrt, caKey, err := native.Backend{}.NewRootCA(create.RootOptions{
+		PrimaryOptions: create.PrimaryOptions{
+			Subject:  "root",
+			Password: "123456",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, otherCrt, _, err := native.Backend{}.NewRootCA(create.RootOptions{
+		PrimaryOptions: create.PrimaryOptions{
+			Subject:  "other",
+			Password: "123456",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, leafCrt, _, err := native.Backend{}.NewTLS(create.RootlessOptions{
+		PrimaryOptions: create.PrimaryOptions{Subject: "example.internal"},
+		RootCaCrt:      caCrt,
+		RootCaKey:      caKey,
+		RootPassword:   "123456",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca, _ := native.ParseCrt(caCrt)
+	other, _ := native.ParseCrt(otherCrt)
+	leaf, _ := native.ParseCrt(leafCrt)
+
+	der, err := ocsp.CreateRequest(leaf, ca, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ParseOCSPRequest(der, other)
+	if err == nil {
+		t.Fatal("request for another ca should be rejected")
+	}
+
+	request, err := ParseOCSPRequest(der, ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := native.ParseKey(caKey, "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().Truncate(time.Second)
+	response, err := NewOCSPResponse(caCrt, signer, request, ocsp.Revoked, &Entry{
+		SerialNumber: leaf.SerialNumber,
+		RevokedAt:    now,
+		Reason:       Superseded,
+	}, now, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ocsp.ParseResponseForCert(response, leaf, ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Status != ocsp.Revoked || parsed.RevocationReason != int(Superseded) || !parsed.RevokedAt.Equal(now) {
+		t.Fatalf("unexpected response: %d %d %s", parsed.Status, parsed.RevocationReason, parsed.RevokedAt)
+	}
+}
@@ -0,0 +1,107 @@
+package revoke
+
+// https://www.rfc-editor.org/rfc/rfc5280#section-5.3.1
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"fmt"
+	"github.com/allape/stepin/stepin/create"
+	"github.com/allape/stepin/stepin/native"
+	"math/big"
+	"time"
+)
+
+type Reason int
+
+const (
+	Unspecified          Reason = 0
+	KeyCompromise        Reason = 1
+	CACompromise         Reason = 2
+	AffiliationChanged   Reason = 3
+	Superseded           Reason = 4
+	CessationOfOperation Reason = 5
+	CertificateHold      Reason = 6
+	PrivilegeWithdrawn   Reason = 9
+	AACompromise         Reason = 10
+)
+
+var AllReasons = map[string]Reason{
+	"unspecified":          Unspecified,
+	"keyCompromise":        KeyCompromise,
+	"cACompromise":         CACompromise,
+	"affiliationChanged":   AffiliationChanged,
+	"superseded":           Superseded,
+	"cessationOfOperation": CessationOfOperation,
+	"certificateHold":      CertificateHold,
+	"privilegeWithdrawn":   PrivilegeWithdrawn,
+	"aACompromise":         AACompromise,
+}
+
+func ParseReason(name string) (Reason, error) {
+	if name == "" {
+		return Unspecified, nil
+	}
+	reason, ok := AllReasons[name]
+	if !ok {
+		return Unspecified, fmt.Errorf("invalid revocation reason: %s", name)
+	}
+	return reason, nil
+}
+
+func (r Reason) String() string {
+	for name, reason := range AllReasons {
+		if reason == r {
+			return name
+		}
+	}
+	return fmt.Sprintf("unknown(%d)", int(r))
+}
+
+type Entry struct {
+	SerialNumber *big.Int
+	RevokedAt    time.Time
+	Reason       Reason
+}
+
+// IssuedBy
+// Check whether crt is signed by ca
+func IssuedBy(crt, ca *x509.Certificate) bool {
+	if string(crt.RawIssuer) != string(ca.RawSubject) {
+		return false
+	}
+	return crt.CheckSignatureFrom(ca) == nil
+}
+
+// NewCRL
+// Create a DER encoded CRL signed by the CA with signer, see native.CASigner
+func NewCRL(
+	caCrt create.Crt,
+	signer crypto.Signer,
+	number int64,
+	entries []Entry,
+	thisUpdate time.Time,
+	nextUpdate time.Time,
+) ([]byte, error) {
+	ca, err := native.ParseCrt(caCrt)
+	if err != nil {
+		return nil, err
+	}
+
+	revoked := make([]x509.RevocationListEntry, 0, len(entries))
+	for _, entry := range entries {
+		revoked = append(revoked, x509.RevocationListEntry{
+			SerialNumber:   entry.SerialNumber,
+			RevocationTime: entry.RevokedAt,
+			ReasonCode:     int(entry.Reason),
+		})
+	}
+
+	return x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		RevokedCertificateEntries: revoked,
+		Number:                    big.NewInt(number),
+		ThisUpdate:                thisUpdate,
+		NextUpdate:                nextUpdate,
+	}, ca, signer)
+}
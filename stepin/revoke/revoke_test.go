@@ -0,0 +1,74 @@
+package revoke
+
+import (
+	"crypto/x509"
+	"github.com/allape/stepin/stepin/create"
+	"github.com/allape/stepin/stepin/native"
+	"testing"
+	"time"
+)
+
+func TestNewCRL(t *testing.T) {
+	_, caCrt, caKey, err := native.Backend{}.NewRootCA(create.RootOptions{
+		PrimaryOptions: create.PrimaryOptions{
+			Subject:  "root",
+			Password: "123456",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, leafCrt, _, err := native.Backend{}.NewTLS(create.RootlessOptions{
+		PrimaryOptions: create.PrimaryOptions{Subject: "example.internal"},
+		RootCaCrt:      caCrt,
+		RootCaKey:      caKey,
+		RootPassword:   "123456",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca, _ := native.ParseCrt(caCrt)
+	leaf, _ := native.ParseCrt(leafCrt)
+	if !IssuedBy(leaf, ca) {
+		t.Fatal("leaf should be issued by ca")
+	}
+
+	signer, err := native.ParseKey(caKey, "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().Truncate(time.Second)
+	der, err := NewCRL(caCrt, signer, 7, []Entry{
+		{SerialNumber: leaf.SerialNumber, RevokedAt: now, Reason: KeyCompromise},
+	}, now, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = crl.CheckSignatureFrom(ca); err != nil {
+		t.Fatal(err)
+	}
+	if crl.Number.Int64() != 7 || len(crl.RevokedCertificateEntries) != 1 {
+		t.Fatalf("unexpected crl: %d %d", crl.Number, len(crl.RevokedCertificateEntries))
+	}
+	entry := crl.RevokedCertificateEntries[0]
+	if entry.SerialNumber.Cmp(leaf.SerialNumber) != 0 || entry.ReasonCode != int(KeyCompromise) {
+		t.Fatalf("unexpected entry: %v %d", entry.SerialNumber, entry.ReasonCode)
+	}
+
+	reason, err := ParseReason("keyCompromise")
+	if err != nil || reason != KeyCompromise {
+		t.Fatalf("unexpected reason: %v %v", reason, err)
+	}
+	_, err = ParseReason("removeFromCRL")
+	if err == nil {
+		t.Fatal("removeFromCRL should not be accepted")
+	}
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"reflect"
+)
+
+const copyBatchSize = 100
+
+// Copied
+// Number of rows copied into a table
+type Copied struct {
+	Table string
+	Rows  int64
+}
+
+// Copy
+// Copy all rows of the models from src into the empty dst in one transaction, IDs are kept,
+// so are the encrypted fields, both sides should be migrated already.
+func Copy(src, dst *gorm.DB, models ...any) ([]Copied, error) {
+	copied := make([]Copied, len(models))
+
+	tables := make([]string, len(models))
+	for i, model := range models {
+		stmt := &gorm.Statement{DB: dst}
+		err := stmt.Parse(model)
+		if err != nil {
+			return nil, err
+		}
+		tables[i] = stmt.Schema.Table
+		copied[i].Table = tables[i]
+
+		var count int64
+		err = dst.Model(model).Count(&count).Error
+		if err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			return nil, fmt.Errorf("target database is not empty, %s has %d rows", tables[i], count)
+		}
+	}
+
+	err := dst.Transaction(func(tx *gorm.DB) error {
+		for i, model := range models {
+			table := tables[i]
+			rows := reflect.New(reflect.SliceOf(reflect.TypeOf(model).Elem()))
+			err := src.Model(model).Order("id ASC").FindInBatches(rows.Interface(), copyBatchSize, func(_ *gorm.DB, _ int) error {
+				if rows.Elem().Len() == 0 {
+					return nil
+				}
+				err := tx.Create(rows.Interface()).Error
+				if err != nil {
+					return fmt.Errorf("failed to copy %s: %w", table, err)
+				}
+				copied[i].Rows += int64(rows.Elem().Len())
+				return nil
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return ResetSequences(tx, models...)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return copied, nil
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"fmt"
+	"github.com/allape/gocrud"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/url"
+	"strings"
+)
+
+// the search handlers of gocrud quote columns with backticks, which only MySQL and SQLite accept,
+// these ones leave the quoting to the dialect
+
+// KeywordStatement
+// The same as gocrud.KeywordStatement
+func KeywordStatement(name string, operator gocrud.Operator, vt gocrud.ValueTransformer[string, any]) gocrud.SearchHandler {
+	return func(db *gorm.DB, values []string, _ url.Values) *gorm.DB {
+		if ok, value := gocrud.ValuableArray(values); ok {
+			var anyValue any = value
+			if vt != nil {
+				anyValue = vt(value)
+				if anyValue == nil {
+					return db
+				}
+			}
+
+			if operator == gocrud.OperatorIn || operator == gocrud.OperatorNotIn {
+				if arr, ok := anyValue.([]any); ok && len(arr) == 0 {
+					return db.Where("1 != 1")
+				}
+			}
+
+			db = db.Where(clause.Expr{
+				SQL:  fmt.Sprintf("? %s ?", operator),
+				Vars: []any{clause.Column{Name: name}, anyValue},
+			})
+		}
+		return db
+	}
+}
+
+// KeywordEqual
+// The same as gocrud.KeywordEqual
+func KeywordEqual(name string, vt gocrud.ValueTransformer[string, any]) gocrud.SearchHandler {
+	return KeywordStatement(name, gocrud.OperatorEqual, vt)
+}
+
+// KeywordLike
+// Case-insensitive on all drivers, LIKE of PostgreSQL is case-sensitive
+func KeywordLike(name string, vt gocrud.ValueTransformer[string, any]) gocrud.SearchHandler {
+	return func(db *gorm.DB, values []string, _ url.Values) *gorm.DB {
+		if ok, value := gocrud.ValuableArray(values); ok {
+			var anyValue any = value
+			if vt != nil {
+				anyValue = vt(value)
+				if anyValue == nil {
+					return db
+				}
+			}
+			db = db.Where(clause.Expr{
+				SQL:  "LOWER(?) LIKE ?",
+				Vars: []any{clause.Column{Name: name}, strings.ToLower(fmt.Sprintf("%%%s%%", anyValue))},
+			})
+		}
+		return db
+	}
+}
+
+// SortBy
+// The same as gocrud.SortBy
+func SortBy(name string) gocrud.SearchHandler {
+	return func(db *gorm.DB, values []string, _ url.Values) *gorm.DB {
+		if ok, value := gocrud.ValuableArray(values); ok {
+			db = db.Order(clause.OrderByColumn{
+				Column: clause.Column{Name: name},
+				Desc:   strings.ToLower(value) == "desc",
+			})
+		}
+		return db
+	}
+}
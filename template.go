@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"github.com/allape/gocrud"
+	"github.com/allape/stepin/audit"
+	"github.com/allape/stepin/auth"
+	"github.com/allape/stepin/model"
+	"github.com/allape/stepin/stepin"
+	"github.com/allape/stepin/stepin/create"
+	"github.com/allape/stepin/stepin/native"
+	"github.com/allape/stepin/storage"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+func SetupTemplateController(group *gin.RouterGroup, db *gorm.DB) error {
+	save := audit.Record(db, audit.TemplateSave)
+	remove := audit.Record(db, audit.TemplateDelete)
+
+	group = group.Group(
+		"template",
+		func(context *gin.Context) {
+			switch context.Request.Method {
+			case http.MethodPut:
+				save(context)
+			case http.MethodDelete:
+				remove(context)
+			}
+		},
+		// templates can add any extension to the certs, e.g. basic constraints of a CA
+		auth.RequireFunc(func(context *gin.Context) (model.Role, error) {
+			if context.Request.Method == http.MethodGet {
+				return model.RoleViewer, nil
+			}
+			return model.RoleCAAdmin, nil
+		}),
+	)
+
+	return gocrud.New(group, db, gocrud.Crud[model.Template]{
+		EnableGetAll: true,
+		SearchHandlers: gocrud.SearchHandlers{
+			"name":    storage.KeywordLike("name", nil),
+			"profile": storage.KeywordEqual("profile", nil),
+		},
+		WillSave: func(record *model.Template, context *gin.Context, db *gorm.DB) {
+			record.Name = strings.TrimSpace(record.Name)
+			if record.Name == "" {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), fmt.Errorf("name is required"))
+				return
+			}
+			if record.Profile != "" && !slices.Contains(create.AllProfiles, record.Profile) {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), fmt.Errorf("invalid certificate profile"))
+				return
+			}
+			err := native.ValidateTemplate(record.Content, record.SampleData)
+			if err != nil {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+				return
+			}
+		},
+	})
+}
+
+// TemplateOptions
+// Options to issue a cert of the profile with the stored template and `--set` variables, templateID is optional.
+// The returned dispose func removes the temporary template file, it is never nil.
+func TemplateOptions(
+	db *gorm.DB,
+	templateID gocrud.ID,
+	profile create.Profile,
+	sets []create.Set,
+) ([]stepin.CommandOption, stepin.DisposeFunc, error) {
+	dispose := func() error { return nil }
+
+	var options []stepin.CommandOption
+
+	for _, set := range sets {
+		if strings.TrimSpace(set.Key) == "" {
+			return nil, dispose, fmt.Errorf("key of set is required")
+		}
+	}
+	if len(sets) > 0 {
+		options = append(options, create.OptionSet{Set: sets})
+	}
+
+	if templateID == 0 {
+		return options, dispose, nil
+	}
+
+	var template model.Template
+	err := db.Model(&template).First(&template, templateID).Error
+	if err != nil {
+		return nil, dispose, fmt.Errorf("template %d: %w", templateID, err)
+	}
+	if template.Profile != "" && template.Profile != profile {
+		return nil, dispose, fmt.Errorf("template %s is for %s only", template.Name, template.Profile)
+	}
+
+	templateFile, dispose, err := stepin.NewTmpFile("stepin_template_*.tpl", []byte(template.Content))
+	if err != nil {
+		return nil, func() error { return nil }, err
+	}
+	_ = templateFile.Close()
+
+	return append(options, create.OptionTemplate{Template: create.FilePath(templateFile.Name())}), dispose, nil
+}
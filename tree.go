@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"github.com/allape/gocrud"
+	"github.com/allape/stepin/model"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const DefaultExpiringInDays = 30
+
+// ExpirySummary
+// Expiry of the certs under a node, revoked and superseded ones are only counted in their own fields
+type ExpirySummary struct {
+	Valid        int        `json:"valid"`
+	Expiring     int        `json:"expiring"` // valid but expire within expiringInDays
+	Expired      int        `json:"expired"`
+	Revoked      int        `json:"revoked"`
+	Superseded   int        `json:"superseded"`   // renewed by another cert
+	NextNotAfter *time.Time `json:"nextNotAfter"` // the earliest expiry of the valid ones
+}
+
+func (s *ExpirySummary) add(other ExpirySummary) {
+	s.Valid += other.Valid
+	s.Expiring += other.Expiring
+	s.Expired += other.Expired
+	s.Revoked += other.Revoked
+	s.Superseded += other.Superseded
+	if other.NextNotAfter != nil && (s.NextNotAfter == nil || other.NextNotAfter.Before(*s.NextNotAfter)) {
+		s.NextNotAfter = other.NextNotAfter
+	}
+}
+
+type CertTreeNode struct {
+	*model.Cert
+	Children []*CertTreeNode `json:"children"`
+	Count    int             `json:"count"`  // number of all descendants
+	Expiry   ExpirySummary   `json:"expiry"` // of all descendants
+}
+
+// CertTree
+// Nest certs under their issuers, certs without a known issuer are roots
+func CertTree(certs []model.Cert, superseded map[gocrud.ID]bool, now time.Time, expiringIn time.Duration) []*CertTreeNode {
+	nodes := make(map[gocrud.ID]*CertTreeNode, len(certs))
+	for i := range certs {
+		nodes[certs[i].ID] = &CertTreeNode{
+			Cert:     certs[i].Strip(),
+			Children: []*CertTreeNode{},
+		}
+	}
+
+	roots := make([]*CertTreeNode, 0)
+	for i := range certs {
+		node := nodes[certs[i].ID]
+		parent, ok := nodes[certs[i].IssuerID]
+		if ok && parent != node {
+			parent.Children = append(parent.Children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+
+	var summarize func(node *CertTreeNode) ExpirySummary
+	summarize = func(node *CertTreeNode) ExpirySummary {
+		for _, child := range node.Children {
+			own := ExpirySummary{}
+			switch {
+			case child.RevokedAt != nil:
+				own.Revoked = 1
+			case superseded[child.ID]:
+				own.Superseded = 1
+			case !child.NotAfter.After(now):
+				own.Expired = 1
+			default:
+				own.Valid = 1
+				if child.NotAfter.Before(now.Add(expiringIn)) {
+					own.Expiring = 1
+				}
+				notAfter := child.NotAfter
+				own.NextNotAfter = &notAfter
+			}
+
+			node.Expiry.add(own)
+			node.Expiry.add(summarize(child))
+			node.Count += 1 + child.Count
+		}
+		return node.Expiry
+	}
+	for _, root := range roots {
+		summarize(root)
+	}
+
+	return roots
+}
+
+func SetupCertTreeController(group *gin.RouterGroup, db *gorm.DB) error {
+	group.GET("cert/tree", func(context *gin.Context) {
+		expiringInDays := DefaultExpiringInDays
+		if value := context.Query("expiringInDays"); value != "" {
+			days, err := strconv.Atoi(value)
+			if err != nil || days < 0 {
+				gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), fmt.Errorf("invalid expiringInDays"))
+				return
+			}
+			expiringInDays = days
+		}
+
+		var certs []model.Cert
+		err := db.Model(&model.Cert{}).Scopes(model.NotDeleted).Omit("crt", "key", "inspection").Order("id ASC").Find(&certs).Error
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		superseded := make(map[gocrud.ID]bool)
+		for _, cert := range certs {
+			if cert.SupersedesID != 0 {
+				superseded[cert.SupersedesID] = true
+			}
+		}
+
+		context.JSON(http.StatusOK, gocrud.R[[]*CertTreeNode]{
+			Code: gocrud.RestCoder.OK(),
+			Data: CertTree(certs, superseded, time.Now(), time.Duration(expiringInDays)*24*time.Hour),
+		})
+	})
+
+	return nil
+}
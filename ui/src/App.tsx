@@ -21,9 +21,13 @@ import { CertCrudy, createCert } from "./api/cert.ts";
 import {
   ICert,
   ICreateCertBody,
+  KeyType,
+  KeyTypeCurves,
   KeyTypes,
   Profile,
   Profiles,
+  RSASizes,
+  SANFields,
 } from "./model/cert.ts";
 import styles from "./style.module.scss";
 
@@ -37,6 +41,7 @@ export default function App(): ReactElement {
   const [recordOptions, setRecordOptions] = useState<ILV<ICert["id"]>[]>([]);
 
   const [form] = Form.useForm<ICreateCertBody>();
+  const keyType = Form.useWatch<KeyType | undefined>("keyType", form);
 
   const getList = useCallback(async () => {
     await execute(async () => {
@@ -72,6 +77,11 @@ export default function App(): ReactElement {
         title: t("name"),
         dataIndex: "name",
       },
+      {
+        title: t("sans"),
+        dataIndex: "sans",
+        render: (v?: string[]) => v?.map((san) => <Tag key={san}>{san}</Tag>),
+      },
       {
         title: t("inspection"),
         dataIndex: "inspection",
@@ -89,6 +99,11 @@ export default function App(): ReactElement {
           );
         },
       },
+      {
+        title: t("notAfter"),
+        dataIndex: "notAfter",
+        render: (v) => (v ? new Date(v).toLocaleString() : "-"),
+      },
       {
         title: t("createdAt"),
         dataIndex: "createdAt",
@@ -109,6 +124,14 @@ export default function App(): ReactElement {
               >
                 {t("crt")}
               </Button>
+              <Button
+                size="small"
+                type="link"
+                href={`${config.SERVER_URL}/cert/fullchain/${record.id}`}
+                target="_blank"
+              >
+                {t("fullchain")}
+              </Button>
               <Button
                 disabled={
                   record.profile === "root-ca" ||
@@ -219,6 +242,11 @@ export default function App(): ReactElement {
           >
             <Input placeholder="name" allowClear />
           </Form.Item>
+          {SANFields.map((field) => (
+            <Form.Item key={field} name={["sans", field]} label={t(field)}>
+              <Select mode="tags" open={false} placeholder={t(field)} />
+            </Form.Item>
+          ))}
           <Form.Item name="pass" label={t("password")}>
             <Input placeholder="password" allowClear />
           </Form.Item>
@@ -250,8 +278,38 @@ export default function App(): ReactElement {
               },
             ]}
           >
-            <Select options={KeyTypes} showSearch optionFilterProp="label" />
+            <Select
+              options={KeyTypes}
+              showSearch
+              optionFilterProp="label"
+              onChange={() =>
+                form.setFieldsValue({ curve: undefined, size: undefined })
+              }
+            />
           </Form.Item>
+          {keyType === "RSA" ? (
+            <Form.Item name="size" label={t("keySize")}>
+              <Select
+                options={RSASizes.map((size) => ({
+                  label: `${size}`,
+                  value: size,
+                }))}
+                allowClear
+                placeholder={t("keySize")}
+              />
+            </Form.Item>
+          ) : (
+            <Form.Item name="curve" label={t("curve")}>
+              <Select
+                options={KeyTypeCurves[keyType || "EC"].map((curve) => ({
+                  label: curve,
+                  value: curve,
+                }))}
+                allowClear
+                placeholder={t("curve")}
+              />
+            </Form.Item>
+          )}
           <Form.Item
             name="parentCaID"
             label={t("parentCA")}
@@ -9,8 +9,10 @@ const Translation = {
     profile: "Profile",
     unknown: "Unknown",
     createdAt: "Create Time",
+    notAfter: "Expire Time",
     download: "Download",
     crt: "Crt",
+    fullchain: "Full Chain",
     key: "Key",
     title: "Certificate Management",
     signNewCertificate: "Sign New Certificate",
@@ -19,9 +21,16 @@ const Translation = {
     password: "Password",
     lifeSpan: "Life Span (In Year)",
     keyType: "Key Type",
+    curve: "Curve",
+    keySize: "Key Size (In Bit)",
     parentCA: "Parent CA",
-    parentCATips: "Required while signing a leaf or a self-signed cert",
+    parentCATips: "Required while signing an intermediate CA or a leaf cert",
     parentCaPassword: "Parent CA Password",
+    sans: "SANs",
+    dnsNames: "DNS Names",
+    ipAddresses: "IP Addresses",
+    emailAddresses: "Email Addresses",
+    uris: "URIs",
   },
 };
 
@@ -10,8 +10,10 @@ const Translation: TT = {
     profile: "证书类型",
     unknown: "未知",
     createdAt: "创建时间",
+    notAfter: "过期时间",
     download: "下载",
     crt: "Crt",
+    fullchain: "证书链",
     key: "Key",
     title: "证书管理",
     signNewCertificate: "签发新证书",
@@ -20,9 +22,16 @@ const Translation: TT = {
     password: "密码",
     lifeSpan: "有效期 (In Year)",
     keyType: "Key 类型",
+    curve: "曲线",
+    keySize: "Key 长度 (In Bit)",
     parentCA: "上级 CA",
-    parentCATips: "子证书或自签名证书时必填",
+    parentCATips: "中间证书或子证书时必填",
     parentCaPassword: "上级 CA 密码",
+    sans: "备用名称",
+    dnsNames: "DNS 名称",
+    ipAddresses: "IP 地址",
+    emailAddresses: "电子邮件地址",
+    uris: "URI",
   },
 };
 
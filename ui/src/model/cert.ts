@@ -19,11 +19,11 @@ export const Profiles: IColoredLV<Profile>[] = [
     color: "green",
     value: "leaf",
   },
-  // {
-  //   label: "Self-Signed",
-  //   color: "blue",
-  //   value: "self-signed",
-  // },
+  {
+    label: "Self-Signed",
+    color: "blue",
+    value: "self-signed",
+  },
 ];
 
 export type KeyType = "EC" | "OKP" | "RSA";
@@ -46,19 +46,88 @@ export const KeyTypes: IColoredLV<KeyType>[] = [
   },
 ];
 
+export type Curve = "P-256" | "P-384" | "P-521" | "Ed25519";
+
+export const KeyTypeCurves: Record<KeyType, Curve[]> = {
+  EC: ["P-256", "P-384", "P-521"],
+  OKP: ["Ed25519"],
+  RSA: [],
+};
+
+export const RSASizes = [2048, 3072, 4096, 8192];
+
+export type CertStatus =
+  | "active"
+  | "expired"
+  | "revoked"
+  | "superseded"
+  | "archived";
+
 export interface ICert extends IBase {
   profile: Profile;
   name: string;
+  sans?: string[];
   crt?: string;
   key?: string;
   inspection: string;
+  serialNumber?: string;
+  issuer?: string;
+  notBefore?: string;
+  notAfter?: string;
+  keyAlgorithm?: string;
+  fingerprint?: string;
+  nameConstraints?: INameConstraints;
+  maxPathLen?: number;
+  issuerID?: number;
+  supersedesID?: number;
+  revokedAt?: string;
+  status?: CertStatus;
+  archivedAt?: string;
+  keyType?: KeyType;
+  curve?: Curve;
+  keySize?: number;
+  kms?: string;
 }
 
 export interface ICreateCertBody extends Pick<ICert, "name"> {
   _profile: Profile;
   pass?: string;
-  years: number;
+  years?: number;
+  notBefore?: string;
+  notAfter?: string;
+  duration?: string;
   keyType: KeyType;
+  curve?: Curve;
+  size?: number;
   parentCaID?: number;
   parentCaPassword?: string;
+  sans?: ISANs;
+  nameConstraints?: INameConstraints;
+  maxPathLen?: number;
+  kms?: string;
 }
+
+export interface INameConstraints {
+  permittedDNSDomains?: string[];
+  excludedDNSDomains?: string[];
+  permittedIPRanges?: string[];
+  excludedIPRanges?: string[];
+  permittedEmailAddresses?: string[];
+  excludedEmailAddresses?: string[];
+  permittedURIDomains?: string[];
+  excludedURIDomains?: string[];
+}
+
+export interface ISANs {
+  dnsNames?: string[];
+  ipAddresses?: string[];
+  emailAddresses?: string[];
+  uris?: string[];
+}
+
+export const SANFields: (keyof ISANs)[] = [
+  "dnsNames",
+  "ipAddresses",
+  "emailAddresses",
+  "uris",
+];
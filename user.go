@@ -0,0 +1,316 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/allape/gocrud"
+	"github.com/allape/stepin/audit"
+	"github.com/allape/stepin/auth"
+	"github.com/allape/stepin/env"
+	"github.com/allape/stepin/model"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+type PutUserBody struct {
+	Username string     `json:"username"`
+	Password string     `json:"password"`
+	Role     model.Role `json:"role"`
+}
+
+type PutTokenBody struct {
+	Name string `json:"name"`
+	Days int    `json:"days"` // never expires if zero
+}
+
+type PutTokenResult struct {
+	Token  *model.Token `json:"token"`
+	Secret string       `json:"secret"` // only returned once
+}
+
+// BootstrapAdmin
+// Create a CA admin if there is no user, a random password will be logged if STEPIN_ADMIN_PASSWORD is empty
+func BootstrapAdmin(db *gorm.DB) error {
+	var count int64
+	err := db.Model(&model.User{}).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	password := env.AdminPassword
+	if password == "" {
+		bs := make([]byte, 12)
+		_, err = rand.Read(bs)
+		if err != nil {
+			return err
+		}
+		password = base64.RawURLEncoding.EncodeToString(bs)
+		l.Warn().Printf("bootstrap admin %s is created with password: %s", env.AdminUsername, password)
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	return db.Create(&model.User{
+		Username:     env.AdminUsername,
+		PasswordHash: hash,
+		Role:         model.RoleCAAdmin,
+	}).Error
+}
+
+func SetupUserController(group *gin.RouterGroup, db *gorm.DB) error {
+	group.GET("user/me", func(context *gin.Context) {
+		context.JSON(http.StatusOK, gocrud.R[*model.User]{
+			Code: gocrud.RestCoder.OK(),
+			Data: auth.CurrentUser(context),
+		})
+	})
+
+	group.GET("user/all", auth.Require(model.RoleCAAdmin), func(context *gin.Context) {
+		var users []model.User
+		err := db.Model(&model.User{}).Order("id ASC").Find(&users).Error
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+		context.JSON(http.StatusOK, gocrud.R[[]model.User]{
+			Code: gocrud.RestCoder.OK(),
+			Data: users,
+		})
+	})
+
+	group.PUT("user", audit.Record(db, audit.UserCreate), auth.Require(model.RoleCAAdmin), func(context *gin.Context) {
+		var body PutUserBody
+		err := context.BindJSON(&body)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+			return
+		}
+
+		body.Username = strings.TrimSpace(body.Username)
+		if body.Username == "" {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), fmt.Errorf("username is required"))
+			return
+		}
+
+		user := model.User{Username: body.Username}
+		err = handleUserBody(&user, body)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+			return
+		}
+
+		var count int64
+		err = db.Model(&model.User{}).Where("username = ?", user.Username).Count(&count).Error
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+		if count > 0 {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.Conflict(), fmt.Errorf("user %s exists", user.Username))
+			return
+		}
+
+		err = db.Create(&user).Error
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		context.JSON(http.StatusOK, gocrud.R[*model.User]{
+			Code: gocrud.RestCoder.OK(),
+			Data: &user,
+		})
+	})
+
+	group.POST("user/:id", audit.Record(db, audit.UserUpdate), auth.Require(model.RoleCAAdmin), func(context *gin.Context) {
+		var body PutUserBody
+		err := context.BindJSON(&body)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+			return
+		}
+
+		var user model.User
+		err = db.Model(&user).First(&user, context.Param("id")).Error
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.NotFound(), err)
+			return
+		}
+
+		if body.Role == "" {
+			body.Role = user.Role
+		}
+		if user.ID == auth.CurrentUser(context).ID && body.Role != user.Role {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), fmt.Errorf("role of the current user can not be changed"))
+			return
+		}
+
+		err = handleUserBody(&user, body)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+			return
+		}
+
+		err = db.Model(&user).Select("password_hash", "role").Updates(&user).Error
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		context.JSON(http.StatusOK, gocrud.R[*model.User]{
+			Code: gocrud.RestCoder.OK(),
+			Data: &user,
+		})
+	})
+
+	group.DELETE("user/:id", audit.Record(db, audit.UserDelete), auth.Require(model.RoleCAAdmin), func(context *gin.Context) {
+		var user model.User
+		err := db.Model(&user).First(&user, context.Param("id")).Error
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.NotFound(), err)
+			return
+		}
+
+		if user.ID == auth.CurrentUser(context).ID {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), fmt.Errorf("current user can not be deleted"))
+			return
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&model.Token{}).Error
+			if err != nil {
+				return err
+			}
+			return tx.Unscoped().Delete(&user).Error
+		})
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		context.JSON(http.StatusOK, gocrud.R[*model.User]{
+			Code: gocrud.RestCoder.OK(),
+			Data: &user,
+		})
+	})
+
+	group.GET("token/all", func(context *gin.Context) {
+		var tokens []model.Token
+		err := db.Model(&model.Token{}).Where("user_id = ?", auth.CurrentUser(context).ID).Order("id ASC").Find(&tokens).Error
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+		context.JSON(http.StatusOK, gocrud.R[[]model.Token]{
+			Code: gocrud.RestCoder.OK(),
+			Data: tokens,
+		})
+	})
+
+	group.PUT("token", audit.Record(db, audit.TokenCreate), func(context *gin.Context) {
+		var body PutTokenBody
+		err := context.BindJSON(&body)
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), err)
+			return
+		}
+
+		if body.Days < 0 {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.BadRequest(), fmt.Errorf("invalid days"))
+			return
+		}
+
+		secret, hash, err := auth.NewToken()
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		token := model.Token{
+			UserID: auth.CurrentUser(context).ID,
+			Name:   strings.TrimSpace(body.Name),
+			Hash:   hash,
+		}
+		if body.Days > 0 {
+			expiresAt := time.Now().AddDate(0, 0, body.Days)
+			token.ExpiresAt = &expiresAt
+		}
+
+		err = db.Create(&token).Error
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		context.JSON(http.StatusOK, gocrud.R[PutTokenResult]{
+			Code: gocrud.RestCoder.OK(),
+			Data: PutTokenResult{
+				Token:  &token,
+				Secret: secret,
+			},
+		})
+	})
+
+	group.DELETE("token/:id", audit.Record(db, audit.TokenDelete), func(context *gin.Context) {
+		var token model.Token
+		err := db.Model(&token).First(&token, context.Param("id")).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.NotFound(), err)
+			return
+		} else if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		user := auth.CurrentUser(context)
+		if token.UserID != user.ID && !user.Role.Includes(model.RoleCAAdmin) {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.NotFound(), gorm.ErrRecordNotFound)
+			return
+		}
+
+		err = db.Unscoped().Delete(&token).Error
+		if err != nil {
+			gocrud.MakeErrorResponse(context, gocrud.RestCoder.InternalServerError(), err)
+			return
+		}
+
+		context.JSON(http.StatusOK, gocrud.R[*model.Token]{
+			Code: gocrud.RestCoder.OK(),
+			Data: &token,
+		})
+	})
+
+	return nil
+}
+
+func handleUserBody(user *model.User, body PutUserBody) error {
+	if !slices.Contains(model.AllRoles, body.Role) {
+		return fmt.Errorf("invalid role")
+	}
+	user.Role = body.Role
+
+	if body.Password != "" {
+		if len(body.Password) < 8 {
+			return fmt.Errorf("password should be at least 8 characters")
+		}
+		hash, err := auth.HashPassword(body.Password)
+		if err != nil {
+			return err
+		}
+		user.PasswordHash = hash
+	}
+
+	return nil
+}